@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	broker "github.com/HaykAghajanyan/chat-backend/internal/brocker"
+	"github.com/HaykAghajanyan/chat-backend/internal/config"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/storage"
+	"github.com/jmoiron/sqlx"
+)
+
+// app holds the repositories shared by the commands
+type app struct {
+	cfg *config.Config
+	db  *sqlx.DB
+	ctx context.Context
+
+	userRepo    *repository.UserRepository
+	messageRepo *repository.MessageRepository
+	sessionRepo *repository.SessionRepository
+	exportRepo  *repository.ExportRepository
+	auditRepo   *repository.AuditRepository
+	statsRepo   *repository.StatsRepository
+
+	broker *broker.Broker
+}
+
+func newApp(cfg *config.Config, db *sqlx.DB) *app {
+	return &app{
+		cfg:         cfg,
+		db:          db,
+		ctx:         context.Background(),
+		userRepo:    repository.NewUserRepository(db),
+		messageRepo: repository.NewMessageRepository(db),
+		sessionRepo: repository.NewSessionRepository(db),
+		exportRepo:  repository.NewExportRepository(db),
+		auditRepo:   repository.NewAuditRepository(db),
+		statsRepo:   repository.NewStatsRepository(db),
+	}
+}
+
+func (a *app) close() {
+	if a.broker != nil {
+		a.broker.Close()
+		a.broker = nil
+	}
+}
+
+func (a *app) uploadStore() (*storage.Local, error) {
+	return storage.NewLocal(a.cfg.Storage.UploadDir, a.cfg.Storage.BaseURL)
+}
+
+func (a *app) exportStore() (*storage.Local, error) {
+	return storage.NewLocal(a.cfg.Storage.ExportDir, "")
+}
+
+// findUser resolves a user ID, email or username
+func (a *app) findUser(ref string) (*models.User, error) {
+	if ref == "" {
+		return nil, fmt.Errorf("-user is required")
+	}
+
+	var (
+		user *models.User
+		err  error
+	)
+	if id, convErr := strconv.Atoi(ref); convErr == nil {
+		user, err = a.userRepo.GetByID(a.ctx, id)
+	} else if strings.Contains(ref, "@") {
+		user, err = a.userRepo.GetByEmail(a.ctx, ref)
+	} else {
+		user, err = a.userRepo.GetByUsername(a.ctx, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user %q not found", ref)
+	}
+	return user, nil
+}
+
+// disconnect closes the user's live connections on every server node. The
+// broker is only connected when a command needs it.
+func (a *app) disconnect(userID int, reason string) {
+	if a.broker == nil {
+		a.broker = broker.New(a.cfg.Redis.Addr)
+	}
+
+	msg := broker.Message{UserID: userID, Kind: broker.KindDisconnect, Payload: []byte(reason)}
+	if err := a.broker.Send(a.ctx, msg); err != nil {
+		log.Printf("warning: failed to disconnect user %d: %v", userID, err)
+	}
+}
+
+// audit records a chatctl action. There is no acting user, so the entry is
+// marked with its source instead.
+func (a *app) audit(action models.AuditAction, userID int, metadata map[string]any) {
+	if metadata == nil {
+		metadata = map[string]any{}
+	}
+	metadata["source"] = "chatctl"
+	data, _ := json.Marshal(metadata)
+
+	entry := &models.AuditEntry{
+		Action:     action,
+		TargetType: sql.NullString{String: "user", Valid: true},
+		TargetID:   sql.NullInt64{Int64: int64(userID), Valid: true},
+		Metadata:   data,
+	}
+	if err := a.auditRepo.Create(a.ctx, entry); err != nil {
+		log.Printf("warning: failed to write audit entry: %v", err)
+	}
+}
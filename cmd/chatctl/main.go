@@ -0,0 +1,83 @@
+// Command chatctl runs operational tasks against the chat database: managing
+// users and sessions, purging data, applying migrations and printing stats.
+// It reads the same environment as the server.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/config"
+	"github.com/HaykAghajanyan/chat-backend/internal/database"
+)
+
+type command struct {
+	usage string
+	run   func(app *app, args []string) error
+}
+
+var commands = map[string]command{
+	"create-user":     {"-username NAME -email EMAIL [-password PW] [-role ROLE]", createUser},
+	"set-role":        {"-user USER -role user|moderator|admin", setRole},
+	"reset-password":  {"-user USER [-password PW]", resetPassword},
+	"revoke-sessions": {"-user USER", revokeSessions},
+	"purge-user":      {"-user USER -yes", purgeUser},
+	"migrate":         {"up | down [-steps N] | status", migrateCmd},
+	"stats":           {"", stats},
+}
+
+func main() {
+	log.SetFlags(0)
+
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "chatctl: unknown command %q\n\n", os.Args[1])
+		usage()
+		os.Exit(2)
+	}
+
+	cfg := config.Load()
+	db, err := database.NewConnection(database.Config{
+		Host:     cfg.Database.Host,
+		Port:     cfg.Database.Port,
+		User:     cfg.Database.User,
+		Password: cfg.Database.Password,
+		DBName:   cfg.Database.DBName,
+		SSLMode:  cfg.Database.SSLMode,
+	})
+	if err != nil {
+		log.Fatalf("chatctl: failed to connect to database: %v", err)
+	}
+
+	a := newApp(cfg, db)
+	err = cmd.run(a, os.Args[2:])
+	a.close()
+	db.Close()
+
+	if err != nil {
+		log.Fatalf("chatctl %s: %v", os.Args[1], err)
+	}
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: chatctl <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "USER is a user ID, email or username.")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].usage)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/migrate"
+	"github.com/HaykAghajanyan/chat-backend/migrations"
+)
+
+// migrateCmd applies, reverts or lists the embedded migrations
+func migrateCmd(a *app, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("expected up, down or status")
+	}
+
+	migrator, err := migrate.New(a.db, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(a.ctx)
+		for _, m := range applied {
+			fmt.Printf("applied %s\n", m)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+
+	case "down":
+		fs := flag.NewFlagSet("migrate down", flag.ExitOnError)
+		steps := fs.Int("steps", 1, "number of migrations to revert")
+		fs.Parse(args[1:])
+
+		reverted, err := migrator.Down(a.ctx, *steps)
+		for _, m := range reverted {
+			fmt.Printf("reverted %s\n", m)
+		}
+		return err
+
+	case "status":
+		statuses, err := migrator.Status(a.ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "MIGRATION\tAPPLIED AT\tNOTE")
+		for _, s := range statuses {
+			applied, note := "pending", ""
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			if s.Modified {
+				note = "modified after it was applied"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", s.Migration, applied, note)
+		}
+		return w.Flush()
+	}
+
+	return fmt.Errorf("unknown migrate command %q, expected up, down or status", args[0])
+}
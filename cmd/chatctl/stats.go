@@ -0,0 +1,40 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+)
+
+func stats(a *app, args []string) error {
+	fs := flag.NewFlagSet("stats", flag.ExitOnError)
+	fs.Parse(args)
+
+	s, err := a.statsRepo.Platform(a.ctx)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	rows := []struct {
+		name  string
+		value int
+	}{
+		{"users", s.Users},
+		{"  active", s.ActiveUsers},
+		{"  suspended", s.SuspendedUsers},
+		{"  banned", s.BannedUsers},
+		{"  deleted", s.DeletedUsers},
+		{"  new (24h)", s.NewUsers24h},
+		{"messages", s.Messages},
+		{"  sent (24h)", s.Messages24h},
+		{"  held for review", s.HeldMessages},
+		{"reports open", s.OpenReports},
+		{"reports claimed", s.ClaimedReports},
+	}
+	for _, row := range rows {
+		fmt.Fprintf(w, "%s\t%d\n", row.name, row.value)
+	}
+	return w.Flush()
+}
@@ -0,0 +1,274 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/storage"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Same minimum as the registration and password endpoints
+const minPasswordLength = 6
+
+func createUser(a *app, args []string) error {
+	fs := flag.NewFlagSet("create-user", flag.ExitOnError)
+	username := fs.String("username", "", "username")
+	email := fs.String("email", "", "email address, marked as verified")
+	password := fs.String("password", "", "password; generated and printed if empty")
+	role := fs.String("role", string(models.RoleUser), "user, moderator or admin")
+	fs.Parse(args)
+
+	if *username == "" || *email == "" {
+		return errors.New("-username and -email are required")
+	}
+	if !models.Role(*role).Valid() {
+		return fmt.Errorf("invalid role %q", *role)
+	}
+
+	existing, err := a.userRepo.GetByEmail(a.ctx, *email)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		if existing, err = a.userRepo.GetByUsername(a.ctx, *username); err != nil {
+			return err
+		}
+	}
+	if existing != nil {
+		return fmt.Errorf("user %d (%s) already exists", existing.ID, existing.Email)
+	}
+
+	pw, generated, err := choosePassword(*password)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	user := &models.User{
+		Username:     *username,
+		Email:        *email,
+		PasswordHash: string(hash),
+	}
+	// A failed role update must not leave behind a verified account with the default role
+	err = repository.RunInTx(a.ctx, a.db, func(tx *sqlx.Tx) error {
+		users := a.userRepo.WithTx(tx)
+		if err := users.Create(a.ctx, user); err != nil {
+			return err
+		}
+		if err := users.MarkEmailVerified(a.ctx, user.ID); err != nil {
+			return err
+		}
+		if models.Role(*role) != models.RoleUser {
+			return users.UpdateRole(a.ctx, user.ID, models.Role(*role))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	a.audit(models.AuditActionUserCreated, user.ID, map[string]any{"role": *role})
+
+	fmt.Printf("created user %d (%s) with role %s\n", user.ID, user.Username, *role)
+	if generated {
+		fmt.Printf("password: %s\n", pw)
+	}
+	return nil
+}
+
+func setRole(a *app, args []string) error {
+	fs := flag.NewFlagSet("set-role", flag.ExitOnError)
+	ref := fs.String("user", "", "user ID, email or username")
+	role := fs.String("role", "", "user, moderator or admin")
+	fs.Parse(args)
+
+	if !models.Role(*role).Valid() {
+		return fmt.Errorf("invalid role %q", *role)
+	}
+	user, err := a.findUser(*ref)
+	if err != nil {
+		return err
+	}
+	if user.Role == models.Role(*role) {
+		fmt.Printf("user %d already has role %s\n", user.ID, *role)
+		return nil
+	}
+
+	if err := a.userRepo.UpdateRole(a.ctx, user.ID, models.Role(*role)); err != nil {
+		return err
+	}
+	// Tokens carry the role, so the user signs in again to pick up the new one
+	if err := a.sessionRepo.RevokeAllForUser(a.ctx, user.ID); err != nil {
+		return err
+	}
+	a.disconnect(user.ID, "role changed")
+
+	a.audit(models.AuditActionUserRoleChanged, user.ID, map[string]any{"from": user.Role, "to": *role})
+
+	fmt.Printf("user %d (%s): role %s -> %s, sessions revoked\n", user.ID, user.Username, user.Role, *role)
+	return nil
+}
+
+func resetPassword(a *app, args []string) error {
+	fs := flag.NewFlagSet("reset-password", flag.ExitOnError)
+	ref := fs.String("user", "", "user ID, email or username")
+	password := fs.String("password", "", "new password; generated and printed if empty")
+	fs.Parse(args)
+
+	user, err := a.findUser(*ref)
+	if err != nil {
+		return err
+	}
+
+	pw, generated, err := choosePassword(*password)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(pw), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := a.userRepo.UpdatePassword(a.ctx, user.ID, string(hash)); err != nil {
+		return err
+	}
+	if err := a.sessionRepo.RevokeAllForUser(a.ctx, user.ID); err != nil {
+		return err
+	}
+	a.disconnect(user.ID, "password reset")
+
+	a.audit(models.AuditActionPasswordReset, user.ID, nil)
+
+	fmt.Printf("password of user %d (%s) reset, sessions revoked\n", user.ID, user.Username)
+	if generated {
+		fmt.Printf("password: %s\n", pw)
+	}
+	return nil
+}
+
+func revokeSessions(a *app, args []string) error {
+	fs := flag.NewFlagSet("revoke-sessions", flag.ExitOnError)
+	ref := fs.String("user", "", "user ID, email or username")
+	fs.Parse(args)
+
+	user, err := a.findUser(*ref)
+	if err != nil {
+		return err
+	}
+
+	if err := a.sessionRepo.RevokeAllForUser(a.ctx, user.ID); err != nil {
+		return err
+	}
+	a.disconnect(user.ID, "session revoked")
+
+	a.audit(models.AuditActionSessionsRevoked, user.ID, nil)
+
+	fmt.Printf("sessions of user %d (%s) revoked\n", user.ID, user.Username)
+	return nil
+}
+
+// purgeUser deletes everything stored about the user: messages in both
+// directions, export archives and the avatar, then anonymises the account
+func purgeUser(a *app, args []string) error {
+	fs := flag.NewFlagSet("purge-user", flag.ExitOnError)
+	ref := fs.String("user", "", "user ID, email or username")
+	yes := fs.Bool("yes", false, "confirm the purge")
+	fs.Parse(args)
+
+	user, err := a.findUser(*ref)
+	if err != nil {
+		return err
+	}
+	if !*yes {
+		return fmt.Errorf("this permanently deletes all data of user %d (%s); rerun with -yes", user.ID, user.Username)
+	}
+
+	// Files are only removed once the rows are gone, so a failed purge can be rerun
+	err = repository.RunInTx(a.ctx, a.db, func(tx *sqlx.Tx) error {
+		if err := a.sessionRepo.WithTx(tx).RevokeAllForUser(a.ctx, user.ID); err != nil {
+			return err
+		}
+		if err := a.messageRepo.WithTx(tx).DeleteAllForUser(a.ctx, user.ID); err != nil {
+			return err
+		}
+		return a.userRepo.WithTx(tx).Anonymize(a.ctx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+	a.disconnect(user.ID, "account deleted")
+
+	a.deleteStoredFiles(user)
+
+	a.audit(models.AuditActionUserPurged, user.ID, map[string]any{"username": user.Username})
+
+	fmt.Printf("user %d (%s) purged\n", user.ID, user.Username)
+	return nil
+}
+
+// deleteStoredFiles removes the user's avatar and export archives. Failures are
+// reported but do not stop the purge.
+func (a *app) deleteStoredFiles(user *models.User) {
+	uploads, err := a.uploadStore()
+	if err != nil {
+		log.Printf("warning: %v", err)
+	} else if key, ok := avatarKey(uploads, user.AvatarURL); ok {
+		if err := uploads.Delete(key); err != nil {
+			log.Printf("warning: failed to delete avatar: %v", err)
+		}
+	}
+
+	exports, err := a.exportRepo.ListByUser(a.ctx, user.ID)
+	if err != nil {
+		log.Printf("warning: failed to list exports: %v", err)
+		return
+	}
+	exportStore, err := a.exportStore()
+	if err != nil {
+		log.Printf("warning: %v", err)
+		return
+	}
+	for _, export := range exports {
+		if !export.StorageKey.Valid {
+			continue
+		}
+		if err := exportStore.Delete(export.StorageKey.String); err != nil {
+			log.Printf("warning: failed to delete export %d: %v", export.ID, err)
+		}
+	}
+}
+
+// choosePassword returns the given password, or a random one if it is empty
+func choosePassword(password string) (string, bool, error) {
+	if password != "" {
+		if len(password) < minPasswordLength {
+			return "", false, fmt.Errorf("password must be at least %d characters", minPasswordLength)
+		}
+		return password, false, nil
+	}
+
+	b := make([]byte, 12)
+	if _, err := rand.Read(b); err != nil {
+		return "", false, err
+	}
+	return hex.EncodeToString(b), true, nil
+}
+
+func avatarKey(store *storage.Local, url sql.NullString) (string, bool) {
+	if !url.Valid {
+		return "", false
+	}
+	return store.KeyFromURL(url.String)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/config"
+	"github.com/jmoiron/sqlx"
+)
+
+func newTestApp(t *testing.T) (*app, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return newApp(&config.Config{}, sqlx.NewDb(db, "postgres")), mock
+}
+
+func TestCreateUserRollsBack(t *testing.T) {
+	a, mock := newTestApp(t)
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE email").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectQuery("SELECT \\* FROM users WHERE username").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	mock.ExpectBegin()
+	mock.ExpectQuery("INSERT INTO users").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at", "updated_at"}).AddRow(5, time.Now(), time.Now()))
+	mock.ExpectExec("UPDATE users").WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE users SET role").WithArgs("admin", 5).WillReturnError(errors.New("boom"))
+	mock.ExpectRollback()
+
+	err := createUser(a, []string{"-username", "ann", "-email", "ann@example.com", "-password", "secret1", "-role", "admin"})
+	if err == nil {
+		t.Fatal("createUser returned nil for a failed role update")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPurgeUserRollsBack(t *testing.T) {
+	a, mock := newTestApp(t)
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(3).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username"}).AddRow(3, "bob"))
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE sessions").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM messages").WithArgs(3).WillReturnResult(sqlmock.NewResult(0, 4))
+	mock.ExpectExec("UPDATE users").WithArgs(3).WillReturnError(errors.New("boom"))
+	mock.ExpectRollback()
+
+	// Nothing is disconnected, deleted from storage or audited after the rollback
+	if err := purgeUser(a, []string{"-user", "3", "-yes"}); err == nil {
+		t.Fatal("purgeUser returned nil for a failed anonymize")
+	}
+	if a.broker != nil {
+		t.Fatal("user disconnected although the purge failed")
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
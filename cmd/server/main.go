@@ -1,25 +1,47 @@
 package main
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	broker "github.com/HaykAghajanyan/chat-backend/internal/brocker"
 	"github.com/HaykAghajanyan/chat-backend/internal/config"
 	"github.com/HaykAghajanyan/chat-backend/internal/database"
 	"github.com/HaykAghajanyan/chat-backend/internal/handlers"
+	"github.com/HaykAghajanyan/chat-backend/internal/logging"
+	"github.com/HaykAghajanyan/chat-backend/internal/mailer"
 	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
+	"github.com/HaykAghajanyan/chat-backend/internal/migrate"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/moderation"
+	"github.com/HaykAghajanyan/chat-backend/internal/ratelimit"
 	"github.com/HaykAghajanyan/chat-backend/internal/repository"
 	"github.com/HaykAghajanyan/chat-backend/internal/service"
+	"github.com/HaykAghajanyan/chat-backend/internal/storage"
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
 	"github.com/HaykAghajanyan/chat-backend/internal/websocket"
+	"github.com/HaykAghajanyan/chat-backend/migrations"
 
 	"github.com/go-chi/chi/v5"
-	chimiddleware "github.com/go-chi/chi/v5/middleware"
 	"github.com/go-chi/cors"
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
 )
 
 func main() {
 	cfg := config.Load()
+	if err := logging.Setup(os.Stderr, cfg.Log.Level, cfg.Log.Format); err != nil {
+		fatal("invalid logging configuration", err)
+	}
+	stopTracing := setupTracing(cfg.Tracing)
 
 	// Initialize database
 	db, err := database.NewConnection(database.Config{
@@ -31,73 +53,402 @@ func main() {
 		SSLMode:  cfg.Database.SSLMode,
 	})
 	if err != nil {
-		log.Fatalf("Failed to connect to database: %v", err)
+		fatal("failed to connect to database", err)
+	}
+
+	migrator, err := migrate.New(db, migrations.FS)
+	if err != nil {
+		fatal("failed to load migrations", err)
+	}
+	if cfg.MigrateOnStart {
+		applied, err := migrator.Up(context.Background())
+		for _, m := range applied {
+			slog.Info("applied migration", "migration", m.String())
+		}
+		if err != nil {
+			fatal("failed to migrate database", err)
+		}
 	}
-	defer db.Close()
+
+	database.RegisterMetrics(db)
 
 	// Initialize repositories
 	userRepo := repository.NewUserRepository(db)
 	messageRepo := repository.NewMessageRepository(db)
+	sessionRepo := repository.NewSessionRepository(db)
+	auditRepo := repository.NewAuditRepository(db)
+	exportRepo := repository.NewExportRepository(db)
+	blockRepo := repository.NewBlockRepository(db)
+	reportRepo := repository.NewReportRepository(db)
+	statsRepo := repository.NewStatsRepository(db)
 
-	// Initialize services
-	authService := service.NewAuthService(userRepo, cfg.JWTSecret)
+	// Initialize mailer
+	var mail mailer.Mailer
+	switch cfg.Mail.Driver {
+	case "smtp":
+		mail = mailer.NewSMTPMailer(mailer.SMTPConfig{
+			Host:     cfg.Mail.SMTPHost,
+			Port:     cfg.Mail.SMTPPort,
+			Username: cfg.Mail.SMTPUsername,
+			Password: cfg.Mail.SMTPPassword,
+			From:     cfg.Mail.From,
+		})
+	default:
+		mail = mailer.NewLogMailer(cfg.Mail.LogPath)
+	}
+
+	// Initialize file storage
+	store, err := storage.NewLocal(cfg.Storage.UploadDir, cfg.Storage.BaseURL)
+	if err != nil {
+		fatal("failed to initialize storage", err)
+	}
+	// Exports are private and only served through the authenticated download endpoint
+	exportStore, err := storage.NewLocal(cfg.Storage.ExportDir, "")
+	if err != nil {
+		fatal("failed to initialize export storage", err)
+	}
 
 	// Initialize Redis
 	redisBroker := broker.New(cfg.Redis.Addr)
 
+	// Initialize rate limiting
+	limiter := ratelimit.NewRedis(redisBroker.Client())
+	rateLimiter := middleware.NewRateLimiter(limiter)
+	apiLimit := mustParseRule("RATE_LIMIT_API", cfg.RateLimit.API)
+	authLimit := mustParseRule("RATE_LIMIT_AUTH", cfg.RateLimit.Auth)
+	chatLimit := mustParseRule("RATE_LIMIT_CHAT", cfg.RateLimit.Chat)
+	wsLimits := handlers.WSRateLimits{
+		Chat:       chatLimit,
+		Typing:     mustParseRule("RATE_LIMIT_TYPING", cfg.RateLimit.Typing),
+		Presence:   mustParseRule("RATE_LIMIT_PRESENCE", cfg.RateLimit.Presence),
+		Other:      mustParseRule("RATE_LIMIT_WS", cfg.RateLimit.WSOther),
+		Connection: mustParseRule("RATE_LIMIT_WS_CONNECTION", cfg.RateLimit.WSConnection),
+		Strikes:    mustParseRule("RATE_LIMIT_WS_STRIKES", cfg.RateLimit.WSStrikes),
+	}
+
+	// Initialize message moderation
+	moderator := moderation.Chain{
+		moderation.MaxLength{Max: cfg.Moderation.MaxLength},
+		moderation.Spam{MaxLinks: cfg.Moderation.MaxLinks, MaxRepeatedChars: cfg.Moderation.MaxRepeatedChars},
+		moderation.NewRepeat(redisBroker.Client(), cfg.Moderation.RepeatLimit, cfg.Moderation.RepeatWindow),
+	}
+	if cfg.Moderation.WordListDir != "" {
+		lists, err := moderation.LoadWordLists(cfg.Moderation.WordListDir)
+		if err != nil {
+			fatal("failed to load moderation word lists", err)
+		}
+		action, err := moderation.ParseAction(cfg.Moderation.WordListAction)
+		if err != nil {
+			fatal("invalid MODERATION_WORDLIST_ACTION", err)
+		}
+		moderator = append(moderator, moderation.NewWordList(lists, action))
+	}
+
+	// Initialize services
+	loginGuard := service.NewLoginGuard(redisBroker.Client(), auditRepo)
+	authService := service.NewAuthService(userRepo, sessionRepo, loginGuard, mail, cfg.JWTSecret, cfg.AppBaseURL)
+
 	// Initialize WebSocket hub
-	hub := websocket.NewHub(redisBroker)
+	hub := websocket.NewHub(redisBroker, userRepo, blockRepo)
 	go hub.Run() // Start hub in background
+	websocket.RegisterMetrics(hub)
+
+	userService := service.NewUserService(db, userRepo, messageRepo, sessionRepo, blockRepo, hub, store, cfg.DeletedMessagesPolicy)
+	messageService := service.NewMessageService(messageRepo, userRepo, auditRepo, hub, moderator)
+	exportService := service.NewExportService(exportRepo, userRepo, messageRepo, sessionRepo, store, exportStore, hub)
+	adminService := service.NewAdminService(userRepo, sessionRepo, statsRepo, auditRepo, hub)
+	reportService := service.NewReportService(reportRepo, messageRepo, userRepo, auditRepo, adminService, hub)
+	exportCtx, stopExports := context.WithCancel(context.Background())
+	exportService.Start(exportCtx)
 
 	// Initialize middleware
 	authMiddleware := middleware.NewAuthMiddleware(authService)
+	hubCheck := handlers.NewHealthCheck("hub", hub.Ping)
+	readiness := handlers.NewReadiness(
+		[]handlers.HealthCheck{hubCheck},
+		[]handlers.HealthCheck{
+			handlers.NewHealthCheck("postgres", db.PingContext),
+			handlers.NewHealthCheck("redis", redisBroker.Ping),
+			handlers.NewHealthCheck("broker_subscription", redisBroker.CheckSubscription),
+			hubCheck,
+			{Name: "migrations", Check: schemaCheck(migrator)},
+		},
+	)
 
 	// Initialize handlers
 	authHandler := handlers.NewAuthHandler(authService)
-	userHandler := handlers.NewUserHandler(userRepo)
-	messageHandler := handlers.NewMessageHandler(messageRepo, userRepo)
-	wsHandler := handlers.NewWebSocketHandler(hub, messageRepo, authService)
+	userHandler := handlers.NewUserHandler(userRepo, userService)
+	messageHandler := handlers.NewMessageHandler(messageRepo, userRepo, messageService)
+	exportHandler := handlers.NewExportHandler(exportService)
+	reportHandler := handlers.NewReportHandler(reportService)
+	adminHandler := handlers.NewAdminHandler(adminService)
+	wsConfig := websocket.Config{
+		ReadBufferSize:       cfg.WebSocket.ReadBufferSize,
+		WriteBufferSize:      cfg.WebSocket.WriteBufferSize,
+		SendBufferSize:       cfg.WebSocket.SendBufferSize,
+		OverflowSize:         cfg.WebSocket.OverflowSize,
+		MaxMessageSize:       cfg.WebSocket.MaxMessageSize,
+		WriteWait:            cfg.WebSocket.WriteWait,
+		PongWait:             cfg.WebSocket.PongWait,
+		EnableCompression:    cfg.WebSocket.EnableCompression,
+		CompressionLevel:     cfg.WebSocket.CompressionLevel,
+		CompressionThreshold: cfg.WebSocket.CompressionThreshold,
+	}
+	wsHandler := handlers.NewWebSocketHandler(hub, messageRepo, messageService, authService, wsConfig, limiter, wsLimits)
+	streamHandler := handlers.NewStreamHandler(hub, messageRepo, wsConfig)
+
+	trustedProxies, err := middleware.ParseTrustedProxies(cfg.TrustedProxies)
+	if err != nil {
+		fatal("invalid TRUSTED_PROXIES", err)
+	}
 
 	r := chi.NewRouter()
 
 	// Global middleware
-	r.Use(chimiddleware.Logger)
-	r.Use(chimiddleware.Recoverer)
+	r.Use(middleware.RealIP(trustedProxies))
+	r.Use(middleware.RequestLogger)
+	r.Use(middleware.Metrics)
+	r.Use(middleware.Tracing)
+	r.Use(middleware.Recoverer)
 	r.Use(cors.Handler(cors.Options{
 		AllowedOrigins:   []string{"http://localhost:3000", "http://localhost:5173"},
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Content-Type", "Authorization"},
+		AllowedMethods:   []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Content-Type", "Authorization", middleware.RequestIDHeader},
+		ExposedHeaders:   []string{middleware.RequestIDHeader},
 		AllowCredentials: true,
 	}))
 
 	// Public routes
-	r.Get("/health", handlers.Health)
+	r.Get("/livez", readiness.Live)
+	r.Get("/readyz", readiness.Ready)
+	r.Get("/health", readiness.Live)
+	r.Get("/ready", readiness.Ready)
 	r.Get("/db-health", handlers.DatabaseHealthCheck(db))
 
+	// Prometheus scrape endpoint. nginx does not expose it publicly.
+	r.Handle("/metrics", promhttp.Handler())
+
+	// Uploaded files (avatars)
+	r.Handle(cfg.Storage.BaseURL+"/*", http.StripPrefix(cfg.Storage.BaseURL, store.Handler()))
+
 	// Auth routes
-	r.Post("/api/auth/register", authHandler.Register)
-	r.Post("/api/auth/login", authHandler.Login)
+	r.Group(func(r chi.Router) {
+		r.Use(rateLimiter.Limit("auth", authLimit))
+
+		r.Post("/api/auth/register", authHandler.Register)
+		r.Post("/api/auth/login", authHandler.Login)
+		r.Post("/api/auth/verify-email", authHandler.VerifyEmail)
+		r.Post("/api/auth/forgot-password", authHandler.ForgotPassword)
+		r.Post("/api/auth/reset-password", authHandler.ResetPassword)
+	})
 
 	// WebSocket route
 	r.Get("/ws", wsHandler.HandleWebSocket)
 
+	// Fallback transports for clients that cannot use websockets. EventSource
+	// cannot set headers, so the token may be passed as a query parameter.
+	r.Group(func(r chi.Router) {
+		r.Use(authMiddleware.RequireStreamAuth)
+		r.Use(rateLimiter.Limit("api", apiLimit))
+
+		r.Get("/api/events", streamHandler.Events)
+		r.Get("/api/events/poll", streamHandler.Poll)
+	})
+
 	// Protected routes
 	r.Group(func(r chi.Router) {
 		r.Use(authMiddleware.RequireAuth)
+		r.Use(rateLimiter.Limit("api", apiLimit))
+
+		r.Post("/api/auth/resend-verification", authHandler.ResendVerification)
 
 		// User routes
 		r.Get("/api/users/me", userHandler.GetMe)
+		r.Patch("/api/users/me", userHandler.UpdateMe)
+		r.Delete("/api/users/me", userHandler.DeleteMe)
+		r.Post("/api/users/me/password", userHandler.ChangePassword)
+		r.Post("/api/users/me/avatar", userHandler.UploadAvatar)
+		r.Delete("/api/users/me/avatar", userHandler.DeleteAvatar)
+
+		// Data export routes
+		r.Post("/api/users/me/exports", exportHandler.RequestExport)
+		r.Get("/api/users/me/exports", exportHandler.ListExports)
+		r.Get("/api/users/me/exports/{exportID}", exportHandler.GetExport)
+		r.Get("/api/users/me/exports/{exportID}/download", exportHandler.DownloadExport)
 		r.Get("/api/users/search", userHandler.SearchUsers)
+		r.Get("/api/users/{userID}/presence", userHandler.GetPresence)
+		r.Get("/api/users/me/blocks", userHandler.GetBlockedUsers)
+		r.Post("/api/users/{userID}/block", userHandler.BlockUser)
+		r.Delete("/api/users/{userID}/block", userHandler.UnblockUser)
 
 		// Message routes
+		r.With(rateLimiter.Limit("chat", chatLimit)).Post("/api/messages", messageHandler.SendMessage)
 		r.Get("/api/messages/conversations", messageHandler.GetConversationList)
 		r.Get("/api/messages/conversation/{userID}", messageHandler.GetConversation)
 		r.Get("/api/messages/unread-count", messageHandler.GetUnreadCount)
 		r.Put("/api/messages/{messageID}/read", messageHandler.MarkAsRead)
+
+		// Abuse reports
+		r.Post("/api/reports", reportHandler.CreateReport)
+
+		// Report review queue
+		r.Route("/api/admin/reports", func(r chi.Router) {
+			r.Use(middleware.RequireRole(models.RoleModerator))
+
+			r.Get("/", reportHandler.ListReports)
+			r.Get("/{reportID}", reportHandler.GetReport)
+			r.Post("/{reportID}/claim", reportHandler.ClaimReport)
+			r.Post("/{reportID}/resolve", reportHandler.ResolveReport)
+		})
+
+		// Held message review queue
+		r.Route("/api/admin/messages/held", func(r chi.Router) {
+			r.Use(middleware.RequireRole(models.RoleModerator))
+
+			r.Get("/", messageHandler.ListHeldMessages)
+			r.Post("/{messageID}/approve", messageHandler.ApproveHeldMessage)
+			r.Post("/{messageID}/reject", messageHandler.RejectHeldMessage)
+		})
+
+		// Account administration
+		r.Group(func(r chi.Router) {
+			r.Use(middleware.RequireRole(models.RoleAdmin))
+
+			r.Get("/api/admin/users", adminHandler.ListUsers)
+			r.Post("/api/admin/users/{userID}/suspend", adminHandler.SuspendUser)
+			r.Post("/api/admin/users/{userID}/ban", adminHandler.BanUser)
+			r.Post("/api/admin/users/{userID}/reinstate", adminHandler.ReinstateUser)
+			r.Put("/api/admin/users/{userID}/role", adminHandler.UpdateRole)
+			r.Get("/api/admin/stats", adminHandler.Stats)
+		})
 	})
 
-	log.Printf("Server starting on port %s", cfg.Port)
-	if err := http.ListenAndServe(":"+cfg.Port, r); err != nil {
-		log.Fatal(err)
+	srv := &http.Server{
+		Addr:     ":" + cfg.Port,
+		Handler:  r,
+		ErrorLog: slog.NewLogLogger(slog.Default().Handler(), slog.LevelWarn),
 	}
+
+	go func() {
+		slog.Info("server starting", "port", cfg.Port)
+		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal("server failed", err)
+		}
+	}()
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	<-ctx.Done()
+	stop()
+
+	shutdown(cfg.Shutdown, readiness, srv, hub, exportService, stopExports, redisBroker, db, stopTracing)
+}
+
+// setupTracing installs the configured span exporter. The returned function
+// flushes the remaining spans.
+func setupTracing(cfg config.TracingConfig) func(context.Context) error {
+	var (
+		exporter sdktrace.SpanExporter
+		err      error
+	)
+	switch cfg.Exporter {
+	case "":
+		return func(context.Context) error { return nil }
+	case "otlp":
+		exporter, err = tracing.NewOTLPExporter(context.Background(), cfg.OTLPEndpoint)
+	case "stdout":
+		exporter, err = tracing.NewStdoutExporter(os.Stdout)
+	default:
+		err = fmt.Errorf("unknown exporter %q", cfg.Exporter)
+	}
+	if err != nil {
+		fatal("invalid TRACING_EXPORTER", err)
+	}
+
+	shutdown, err := tracing.Setup(exporter, cfg.ServiceName, cfg.SampleRatio)
+	if err != nil {
+		fatal("failed to set up tracing", err)
+	}
+	slog.Info("tracing enabled", "exporter", cfg.Exporter, "sample_ratio", cfg.SampleRatio)
+	return shutdown
+}
+
+// shutdown stops the server in dependency order: the readiness probe fails
+// first, then WebSocket clients are told to reconnect elsewhere and drained,
+// in-flight HTTP requests and export jobs finish, and finally the broker and
+// the database pool are closed.
+func shutdown(
+	cfg config.ShutdownConfig,
+	readiness *handlers.Readiness,
+	srv *http.Server,
+	hub *websocket.Hub,
+	exportService *service.ExportService,
+	stopExports context.CancelFunc,
+	redisBroker *broker.Broker,
+	db *sqlx.DB,
+	stopTracing func(context.Context) error,
+) {
+	slog.Info("shutting down, readiness probe failing", "delay", cfg.ReadinessDelay.String())
+	readiness.SetDraining()
+	time.Sleep(cfg.ReadinessDelay)
+
+	// The WebSocket drain and the HTTP shutdown have separate budgets so slow
+	// clients cannot leave in-flight requests without time to finish
+	wsCtx, cancelWS := context.WithTimeout(context.Background(), cfg.Timeout)
+	defer cancelWS()
+	if err := hub.Shutdown(wsCtx, cfg.ReconnectDelay, cfg.FlushTimeout); err != nil {
+		slog.Warn("websocket drain incomplete", "error", err)
+	}
+
+	httpCtx, cancelHTTP := context.WithTimeout(context.Background(), cfg.HTTPTimeout)
+	defer cancelHTTP()
+	if err := srv.Shutdown(httpCtx); err != nil {
+		slog.Warn("http shutdown incomplete, closing remaining connections", "error", err)
+		srv.Close()
+	}
+
+	stopExports()
+	exportService.Wait()
+
+	if err := redisBroker.Close(); err != nil {
+		slog.Error("failed to close broker", "error", err)
+	}
+	if err := db.Close(); err != nil {
+		slog.Error("failed to close database", "error", err)
+	}
+
+	flushCtx, cancelFlush := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancelFlush()
+	if err := stopTracing(flushCtx); err != nil {
+		slog.Warn("failed to flush spans", "error", err)
+	}
+	slog.Info("server stopped")
+}
+
+// schemaCheck fails readiness while the database lacks migrations this binary
+// depends on. Versions newer than the binary are fine during a rolling deploy.
+func schemaCheck(migrator *migrate.Migrator) func(context.Context) (any, error) {
+	return func(ctx context.Context) (any, error) {
+		version, err := migrator.Version(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if version.Pending > 0 {
+			return version, fmt.Errorf("%w: %d not applied", migrate.ErrPending, version.Pending)
+		}
+		return version, nil
+	}
+}
+
+func mustParseRule(name, value string) ratelimit.Rule {
+	rule, err := ratelimit.ParseRule(value)
+	if err != nil {
+		fatal("invalid "+name, err)
+	}
+	return rule
+}
+
+// fatal logs an error that prevents the server from running and exits
+func fatal(msg string, err error) {
+	slog.Error(msg, "error", err)
+	os.Exit(1)
 }
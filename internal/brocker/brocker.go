@@ -3,20 +3,69 @@ package broker
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
 
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
 	"github.com/redis/go-redis/v9"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const messageChannel = "chat:messages"
 
+// Message kinds. Deliveries carry a payload for the user's connections, the
+// others instruct whichever node holds the user's connections.
+const (
+	KindDeliver    = ""
+	KindDisconnect = "disconnect"
+
+	// KindPresence carries a presence frame about UserID for that user's subscribers
+	KindPresence = "presence"
+
+	// KindUnwatch removes the user ID in the payload from UserID's presence
+	// subscriptions
+	KindUnwatch = "unwatch"
+)
+
 type Message struct {
 	UserID  int    `json:"user_id"`
+	Kind    string `json:"kind,omitempty"`
 	Payload []byte `json:"payload"`
+
+	// SentAt is set by Send and used to measure the subscription lag
+	SentAt time.Time `json:"sent_at,omitzero"`
+
+	// Traceparent is the W3C trace context of the publishing span, so the
+	// delivery on the receiving node continues the sender's trace
+	Traceparent string `json:"traceparent,omitempty"`
 }
 
+var (
+	publishDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name: "chat_broker_publish_duration_seconds",
+		Help: "Time to publish a message to Redis.",
+	})
+	publishErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "chat_broker_publish_errors_total",
+		Help: "Messages that could not be published to Redis.",
+	})
+	subscriptionLag = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "chat_broker_subscription_lag_seconds",
+		Help:    "Time from publishing a message to its delivery to this node's subscription. Includes clock skew between nodes.",
+		Buckets: []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	})
+)
+
 type Broker struct {
-	client *redis.Client
+	client     *redis.Client
+	sub        *redis.PubSub
+	subscribed atomic.Bool
+	probes     *probes
 }
 
 func New(addr string) *Broker {
@@ -25,35 +74,87 @@ func New(addr string) *Broker {
 	})
 
 	if err := client.Ping(context.Background()).Err(); err != nil {
-		log.Fatalf("broker: failed to connect to Redis at %s: %v", addr, err)
+		slog.Error("broker: failed to connect to Redis", "addr", addr, "error", err)
+		os.Exit(1)
 	}
 
-	return &Broker{client: client}
+	return &Broker{client: client, probes: newProbes()}
+}
+
+// Client exposes the underlying Redis client for features that share the connection
+func (b *Broker) Client() *redis.Client {
+	return b.client
 }
 
 // Publish sends a message to the Redis channel
 func (b *Broker) Publish(ctx context.Context, userID int, payload []byte) error {
-	msg := Message{UserID: userID, Payload: payload}
+	return b.Send(ctx, Message{UserID: userID, Payload: payload})
+}
+
+// Send publishes an arbitrary message envelope to every node
+func (b *Broker) Send(ctx context.Context, msg Message) error {
+	ctx, span := tracing.Start(ctx, "Broker.Publish", trace.SpanKindProducer)
+	defer span.End()
+	span.SetAttributes(
+		attribute.String("messaging.system", "redis"),
+		attribute.String("messaging.destination.name", messageChannel),
+		attribute.Int("enduser.id", msg.UserID),
+	)
+
+	msg.SentAt = time.Now()
+	msg.Traceparent = tracing.Traceparent(ctx)
 	data, err := json.Marshal(msg)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return err
 	}
 
-	return b.client.Publish(ctx, messageChannel, data).Err()
+	err = b.client.Publish(ctx, messageChannel, data).Err()
+	publishDuration.Observe(time.Since(msg.SentAt).Seconds())
+	if err != nil {
+		publishErrors.Inc()
+		tracing.RecordError(span, err)
+	}
+	return err
 }
 
-// Subscribe listens for messages and calls handler for each one
-func (b *Broker) Subscribe(ctx context.Context, handler func(userID int, payload []byte)) {
-	sub := b.client.Subscribe(ctx, messageChannel)
+// Subscribe listens for messages and calls handler for each one. The context
+// passed to handler carries the publisher's trace context, if any.
+func (b *Broker) Subscribe(ctx context.Context, handler func(ctx context.Context, msg Message)) {
+	sub := b.client.Subscribe(ctx, messageChannel, b.probes.channel)
+	b.sub = sub
+	b.subscribed.Store(true)
 	ch := sub.Channel()
 
 	go func() {
+		defer b.subscribed.Store(false)
+
 		for msg := range ch {
+			if msg.Channel == b.probes.channel {
+				b.probes.ack(msg.Payload)
+				continue
+			}
+
 			var m Message
 			if err := json.Unmarshal([]byte(msg.Payload), &m); err != nil {
 				continue
 			}
-			handler(m.UserID, m.Payload)
+			// Messages from nodes running an older version have no timestamp
+			if !m.SentAt.IsZero() {
+				subscriptionLag.Observe(time.Since(m.SentAt).Seconds())
+			}
+
+			handler(tracing.ContextWithTraceparent(ctx, m.Traceparent), m)
 		}
 	}()
 }
+
+// Close stops the subscription and closes the Redis connection
+func (b *Broker) Close() error {
+	if b.sub != nil {
+		if err := b.sub.Close(); err != nil {
+			slog.Error("broker: failed to close subscription", "error", err)
+		}
+	}
+	return b.client.Close()
+}
@@ -0,0 +1,85 @@
+package broker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// errNotSubscribed is returned by CheckSubscription before Subscribe was called
+var errNotSubscribed = errors.New("not subscribed")
+
+// probes tracks the echo probes waiting to come back through the subscription
+type probes struct {
+	mu      sync.Mutex
+	channel string
+	waiting map[string]chan struct{}
+}
+
+func newProbes() *probes {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &probes{
+		// Each node has its own channel so probes do not reach the other nodes
+		channel: "chat:health:" + hex.EncodeToString(id),
+		waiting: make(map[string]chan struct{}),
+	}
+}
+
+func (p *probes) add() (string, chan struct{}) {
+	token := make([]byte, 8)
+	rand.Read(token)
+	id := hex.EncodeToString(token)
+	done := make(chan struct{})
+
+	p.mu.Lock()
+	p.waiting[id] = done
+	p.mu.Unlock()
+	return id, done
+}
+
+func (p *probes) remove(id string) {
+	p.mu.Lock()
+	delete(p.waiting, id)
+	p.mu.Unlock()
+}
+
+func (p *probes) ack(id string) {
+	p.mu.Lock()
+	if done, ok := p.waiting[id]; ok {
+		close(done)
+		delete(p.waiting, id)
+	}
+	p.mu.Unlock()
+}
+
+// Ping checks that Redis answers commands
+func (b *Broker) Ping(ctx context.Context) error {
+	return b.client.Ping(ctx).Err()
+}
+
+// CheckSubscription publishes a probe on this node's health channel and waits
+// for it to arrive through the subscription. It catches a subscription that
+// stopped receiving while commands still succeed.
+func (b *Broker) CheckSubscription(ctx context.Context) error {
+	if !b.subscribed.Load() {
+		return errNotSubscribed
+	}
+
+	id, done := b.probes.add()
+	defer b.probes.remove(id)
+
+	if err := b.client.Publish(ctx, b.probes.channel, id).Err(); err != nil {
+		return fmt.Errorf("failed to publish probe: %w", err)
+	}
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("probe not received: %w", ctx.Err())
+	}
+}
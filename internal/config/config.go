@@ -3,6 +3,10 @@ package config
 import (
 	"log"
 	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/joho/godotenv"
 )
@@ -11,8 +15,27 @@ type Config struct {
 	Port        string
 	Environment string
 	JWTSecret   string
+	AppBaseURL  string
 	Database    DatabaseConfig
 	Redis       RedisConfig
+	Mail        MailConfig
+	Storage     StorageConfig
+	WebSocket   WebSocketConfig
+	Shutdown    ShutdownConfig
+	RateLimit   RateLimitConfig
+	Moderation  ModerationConfig
+	Log         LogConfig
+	Tracing     TracingConfig
+
+	// TrustedProxies lists the addresses, as IPs or CIDR ranges separated by
+	// commas, whose X-Real-IP header is believed. Empty trusts nobody.
+	TrustedProxies string
+
+	// MigrateOnStart applies pending migrations before the server starts
+	MigrateOnStart bool
+
+	// DeletedMessagesPolicy is "keep" or "cascade", see service.DeletedMessagesKeep
+	DeletedMessagesPolicy string
 }
 
 type DatabaseConfig struct {
@@ -28,6 +51,88 @@ type RedisConfig struct {
 	Addr string
 }
 
+type StorageConfig struct {
+	UploadDir string
+	BaseURL   string
+	ExportDir string
+}
+
+type WebSocketConfig struct {
+	ReadBufferSize       int
+	WriteBufferSize      int
+	SendBufferSize       int
+	OverflowSize         int
+	MaxMessageSize       int64
+	WriteWait            time.Duration
+	PongWait             time.Duration
+	EnableCompression    bool
+	CompressionLevel     int
+	CompressionThreshold int
+}
+
+// RateLimitConfig holds quotas in ratelimit.ParseRule syntax, e.g. "60/m:20".
+// An empty value or "0" disables a quota.
+type RateLimitConfig struct {
+	API          string // per user on authenticated routes
+	Auth         string // per IP on public auth routes
+	Chat         string // per user, WebSocket chat frames and POST /api/messages
+	Typing       string
+	Presence     string
+	WSOther      string // other WebSocket frames
+	WSConnection string // all frames on one connection
+	WSStrikes    string // rejected frames tolerated before a connection is closed
+}
+
+type ModerationConfig struct {
+	MaxLength int
+	// WordListDir holds <locale>.txt word lists; default.txt applies to every locale
+	WordListDir    string
+	WordListAction string // "mask", "hold" or "reject"
+	MaxLinks       int
+	// MaxRepeatedChars holds messages with a longer run of one character
+	MaxRepeatedChars int
+	// RepeatLimit is how many times the same text may be sent within RepeatWindow
+	RepeatLimit  int
+	RepeatWindow time.Duration
+}
+
+type LogConfig struct {
+	Level  string // "debug", "info", "warn" or "error"
+	Format string // "json" or "text"
+}
+
+type TracingConfig struct {
+	Exporter     string // "otlp", "stdout" or empty to disable tracing
+	OTLPEndpoint string // base URL of the OTLP/HTTP collector
+	ServiceName  string
+	SampleRatio  float64 // fraction of new traces recorded
+}
+
+type ShutdownConfig struct {
+	// Timeout bounds draining the WebSocket clients
+	Timeout time.Duration
+	// FlushTimeout is how long each client's queued frames may take to be
+	// written before it is closed anyway
+	FlushTimeout time.Duration
+	// HTTPTimeout bounds in-flight HTTP requests once the clients are drained
+	HTTPTimeout time.Duration
+	// ReadinessDelay is how long the readiness probe fails before connections
+	// are drained, so the load balancer stops sending traffic first
+	ReadinessDelay time.Duration
+	// ReconnectDelay is the minimum delay suggested to clients in reconnect frames
+	ReconnectDelay time.Duration
+}
+
+type MailConfig struct {
+	Driver       string // "smtp" or "log"
+	From         string
+	SMTPHost     string
+	SMTPPort     string
+	SMTPUsername string
+	SMTPPassword string
+	LogPath      string
+}
+
 func Load() *Config {
 	if err := godotenv.Load(); err != nil {
 		log.Fatal("Error loading .env file")
@@ -37,6 +142,7 @@ func Load() *Config {
 		Port:        getEnv("PORT", "8080"),
 		Environment: getEnv("ENVIRONMENT", "development"),
 		JWTSecret:   getEnv("JWT_SECRET", "xZibit2000"),
+		AppBaseURL:  getEnv("APP_BASE_URL", "http://localhost:5173"),
 		Database: DatabaseConfig{
 			Host:     getEnv("DB_HOST", "localhost"),
 			Port:     getEnv("DB_PORT", "5432"),
@@ -48,6 +154,71 @@ func Load() *Config {
 		Redis: RedisConfig{
 			Addr: getEnv("REDIS_ADDR", "localhost:6379"),
 		},
+		Mail: MailConfig{
+			Driver:       getEnv("MAIL_DRIVER", "log"),
+			From:         getEnv("MAIL_FROM", "no-reply@schat.local"),
+			SMTPHost:     getEnv("SMTP_HOST", "localhost"),
+			SMTPPort:     getEnv("SMTP_PORT", "587"),
+			SMTPUsername: getEnv("SMTP_USERNAME", ""),
+			SMTPPassword: getEnv("SMTP_PASSWORD", ""),
+			LogPath:      getEnv("MAIL_LOG_PATH", ""),
+		},
+		Storage: StorageConfig{
+			UploadDir: getEnv("UPLOAD_DIR", "./uploads"),
+			BaseURL:   getEnv("UPLOAD_BASE_URL", "/uploads"),
+			ExportDir: getEnv("EXPORT_DIR", "./exports"),
+		},
+		WebSocket: WebSocketConfig{
+			ReadBufferSize:       getEnvInt("WS_READ_BUFFER_SIZE", 1024),
+			WriteBufferSize:      getEnvInt("WS_WRITE_BUFFER_SIZE", 1024),
+			SendBufferSize:       getEnvInt("WS_SEND_BUFFER_SIZE", 256),
+			OverflowSize:         getEnvInt("WS_OVERFLOW_SIZE", 1024),
+			MaxMessageSize:       int64(getEnvInt("WS_MAX_MESSAGE_SIZE", 16*1024)),
+			WriteWait:            getEnvDuration("WS_WRITE_WAIT", 10*time.Second),
+			PongWait:             getEnvDuration("WS_PONG_WAIT", 60*time.Second),
+			EnableCompression:    getEnvBool("WS_COMPRESSION", true),
+			CompressionLevel:     getEnvInt("WS_COMPRESSION_LEVEL", 1),
+			CompressionThreshold: getEnvInt("WS_COMPRESSION_THRESHOLD", 1024),
+		},
+		RateLimit: RateLimitConfig{
+			API:          getEnv("RATE_LIMIT_API", "300/m:100"),
+			Auth:         getEnv("RATE_LIMIT_AUTH", "30/m:10"),
+			Chat:         getEnv("RATE_LIMIT_CHAT", "60/m:20"),
+			Typing:       getEnv("RATE_LIMIT_TYPING", "60/m:20"),
+			Presence:     getEnv("RATE_LIMIT_PRESENCE", "30/m:10"),
+			WSOther:      getEnv("RATE_LIMIT_WS", "120/m:40"),
+			WSConnection: getEnv("RATE_LIMIT_WS_CONNECTION", "20/s:40"),
+			WSStrikes:    getEnv("RATE_LIMIT_WS_STRIKES", "10/m:10"),
+		},
+		Moderation: ModerationConfig{
+			MaxLength:        getEnvInt("MODERATION_MAX_LENGTH", 4000),
+			WordListDir:      getEnv("MODERATION_WORDLIST_DIR", ""),
+			WordListAction:   getEnv("MODERATION_WORDLIST_ACTION", "mask"),
+			MaxLinks:         getEnvInt("MODERATION_MAX_LINKS", 5),
+			MaxRepeatedChars: getEnvInt("MODERATION_MAX_REPEATED_CHARS", 50),
+			RepeatLimit:      getEnvInt("MODERATION_REPEAT_LIMIT", 5),
+			RepeatWindow:     getEnvDuration("MODERATION_REPEAT_WINDOW", time.Minute),
+		},
+		Log: LogConfig{
+			Level:  getEnv("LOG_LEVEL", "info"),
+			Format: getEnv("LOG_FORMAT", "json"),
+		},
+		Tracing: TracingConfig{
+			Exporter:     getEnv("TRACING_EXPORTER", ""),
+			OTLPEndpoint: getEnv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://localhost:4318"),
+			ServiceName:  getEnv("OTEL_SERVICE_NAME", "chat-backend"),
+			SampleRatio:  getEnvFloat("TRACING_SAMPLE_RATIO", 1),
+		},
+		Shutdown: ShutdownConfig{
+			Timeout:        getEnvDuration("SHUTDOWN_TIMEOUT", 20*time.Second),
+			FlushTimeout:   getEnvDuration("SHUTDOWN_FLUSH_TIMEOUT", 5*time.Second),
+			HTTPTimeout:    getEnvDuration("SHUTDOWN_HTTP_TIMEOUT", 10*time.Second),
+			ReadinessDelay: getEnvDuration("SHUTDOWN_READINESS_DELAY", 5*time.Second),
+			ReconnectDelay: getEnvDuration("SHUTDOWN_RECONNECT_DELAY", 2*time.Second),
+		},
+		TrustedProxies:        getEnv("TRUSTED_PROXIES", ""),
+		MigrateOnStart:        getEnvBool("MIGRATE_ON_START", false),
+		DeletedMessagesPolicy: getEnvOneOf("DELETED_MESSAGES_POLICY", "keep", "keep", "cascade"),
 	}
 }
 
@@ -57,3 +228,60 @@ func getEnv(key, fallback string) string {
 	}
 	return fallback
 }
+
+// getEnvOneOf is getEnv restricted to the allowed values
+func getEnvOneOf(key, fallback string, allowed ...string) string {
+	value := getEnv(key, fallback)
+	if !slices.Contains(allowed, value) {
+		log.Fatalf("Invalid %s: %q, expected one of %s", key, value, strings.Join(allowed, ", "))
+	}
+	return value
+}
+
+func getEnvInt(key string, fallback int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return n
+}
+
+func getEnvFloat(key string, fallback float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return f
+}
+
+func getEnvDuration(key string, fallback time.Duration) time.Duration {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return d
+}
+
+func getEnvBool(key string, fallback bool) bool {
+	value := os.Getenv(key)
+	if value == "" {
+		return fallback
+	}
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", key, err)
+	}
+	return b
+}
@@ -0,0 +1,41 @@
+package database
+
+import (
+	"database/sql"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// RegisterMetrics exposes the connection pool statistics of db
+func RegisterMetrics(db *sqlx.DB) {
+	stat := func(fn func(s sql.DBStats) float64) func() float64 {
+		return func() float64 { return fn(db.Stats()) }
+	}
+	gauge := func(name, help string, fn func(s sql.DBStats) float64) {
+		promauto.NewGaugeFunc(prometheus.GaugeOpts{Name: name, Help: help}, stat(fn))
+	}
+	counter := func(name, help string, fn func(s sql.DBStats) float64) {
+		promauto.NewCounterFunc(prometheus.CounterOpts{Name: name, Help: help}, stat(fn))
+	}
+
+	gauge("chat_db_max_open_connections", "Maximum number of open connections to the database.",
+		func(s sql.DBStats) float64 { return float64(s.MaxOpenConnections) })
+	gauge("chat_db_open_connections", "Established connections, in use and idle.",
+		func(s sql.DBStats) float64 { return float64(s.OpenConnections) })
+	gauge("chat_db_in_use_connections", "Connections currently in use.",
+		func(s sql.DBStats) float64 { return float64(s.InUse) })
+	gauge("chat_db_idle_connections", "Idle connections.",
+		func(s sql.DBStats) float64 { return float64(s.Idle) })
+	counter("chat_db_wait_count_total", "Connections waited for because the pool was exhausted.",
+		func(s sql.DBStats) float64 { return float64(s.WaitCount) })
+	counter("chat_db_wait_duration_seconds_total", "Time spent waiting for a connection.",
+		func(s sql.DBStats) float64 { return s.WaitDuration.Seconds() })
+	counter("chat_db_max_idle_closed_total", "Connections closed because of SetMaxIdleConns.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleClosed) })
+	counter("chat_db_max_idle_time_closed_total", "Connections closed because of SetConnMaxIdleTime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxIdleTimeClosed) })
+	counter("chat_db_max_lifetime_closed_total", "Connections closed because of SetConnMaxLifetime.",
+		func(s sql.DBStats) float64 { return float64(s.MaxLifetimeClosed) })
+}
@@ -0,0 +1,211 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+)
+
+// Page size of the admin user list
+const (
+	defaultUserPageSize = 50
+	maxUserPageSize     = 200
+)
+
+type AdminHandler struct {
+	adminService *service.AdminService
+	validator    *validator.Validate
+}
+
+func NewAdminHandler(adminService *service.AdminService) *AdminHandler {
+	return &AdminHandler{
+		adminService: adminService,
+		validator:    validator.New(),
+	}
+}
+
+// ListUsers lists users filtered by the q, role and status query parameters
+func (h *AdminHandler) ListUsers(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := models.UserFilter{
+		Query:  query.Get("q"),
+		Role:   models.Role(query.Get("role")),
+		Status: models.UserStatus(query.Get("status")),
+	}
+
+	if filter.Role != "" && !filter.Role.Valid() {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid role"})
+		return
+	}
+	switch filter.Status {
+	case "", models.UserStatusActive, models.UserStatusSuspended, models.UserStatusBanned, models.UserStatusDeleted:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid status"})
+		return
+	}
+
+	limit := defaultUserPageSize
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 && l <= maxUserPageSize {
+		limit = l
+	}
+	offset := 0
+	if o, err := strconv.Atoi(query.Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	users, err := h.adminService.ListUsers(r.Context(), filter, limit, offset)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list users"})
+		return
+	}
+
+	result := make([]adminUser, len(users))
+	for i, u := range users {
+		result[i] = newAdminUser(u)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+func (h *AdminHandler) SuspendUser(w http.ResponseWriter, r *http.Request) {
+	var req models.SuspendUserRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	h.apply(w, r, func(ctx context.Context, actorID, userID int) (*models.User, error) {
+		return h.adminService.Suspend(ctx, actorID, userID, time.Duration(req.Hours)*time.Hour, req.Reason)
+	})
+}
+
+func (h *AdminHandler) BanUser(w http.ResponseWriter, r *http.Request) {
+	var req models.BanUserRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	h.apply(w, r, func(ctx context.Context, actorID, userID int) (*models.User, error) {
+		return h.adminService.Ban(ctx, actorID, userID, req.Reason)
+	})
+}
+
+// ReinstateUser lifts a ban or suspension
+func (h *AdminHandler) ReinstateUser(w http.ResponseWriter, r *http.Request) {
+	h.apply(w, r, h.adminService.Reinstate)
+}
+
+func (h *AdminHandler) UpdateRole(w http.ResponseWriter, r *http.Request) {
+	var req models.UpdateRoleRequest
+	if !h.decode(w, r, &req) {
+		return
+	}
+
+	h.apply(w, r, func(ctx context.Context, actorID, userID int) (*models.User, error) {
+		return h.adminService.SetRole(ctx, actorID, userID, req.Role)
+	})
+}
+
+func (h *AdminHandler) Stats(w http.ResponseWriter, r *http.Request) {
+	stats, err := h.adminService.Stats(r.Context())
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get stats"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(stats)
+}
+
+// decode reads and validates the request body, writing the error response if it fails
+func (h *AdminHandler) decode(w http.ResponseWriter, r *http.Request, req any) bool {
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return false
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return false
+	}
+
+	return true
+}
+
+// apply runs an account action on the user in the URL and writes the updated user
+func (h *AdminHandler) apply(w http.ResponseWriter, r *http.Request, action func(ctx context.Context, actorID, userID int) (*models.User, error)) {
+	actorID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	userID, err := strconv.Atoi(chi.URLParam(r, "userID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid user ID"})
+		return
+	}
+
+	user, err := action(r.Context(), actorID, userID)
+	switch {
+	case errors.Is(err, service.ErrUserNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "not found"})
+		return
+	case errors.Is(err, service.ErrCannotModerateSelf), errors.Is(err, service.ErrInsufficientRole):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	case err != nil:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to update user"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(newAdminUser(*user))
+}
+
+// adminUser is a user with the account state only admins can see. The user is
+// nested because models.User has its own MarshalJSON.
+type adminUser struct {
+	User              models.User `json:"user"`
+	SuspendedUntil    *time.Time  `json:"suspended_until"`
+	BannedAt          *time.Time  `json:"banned_at"`
+	DeletedAt         *time.Time  `json:"deleted_at"`
+	RestrictionReason *string     `json:"restriction_reason"`
+}
+
+func newAdminUser(u models.User) adminUser {
+	a := adminUser{User: u}
+	if u.SuspendedUntil.Valid {
+		a.SuspendedUntil = &u.SuspendedUntil.Time
+	}
+	if u.BannedAt.Valid {
+		a.BannedAt = &u.BannedAt.Time
+	}
+	if u.DeletedAt.Valid {
+		a.DeletedAt = &u.DeletedAt.Time
+	}
+	if u.RestrictionReason.Valid {
+		a.RestrictionReason = &u.RestrictionReason.String
+	}
+	return a
+}
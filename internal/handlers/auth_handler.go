@@ -2,9 +2,13 @@ package handlers
 
 import (
 	"encoding/json"
-	"fmt"
+	"errors"
+	"log/slog"
+	"math"
 	"net/http"
+	"strconv"
 
+	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
 	"github.com/HaykAghajanyan/chat-backend/internal/service"
 	"github.com/go-playground/validator/v10"
@@ -24,6 +28,7 @@ func NewAuthHandler(authService *service.AuthService) *AuthHandler {
 
 type ErrorResponse struct {
 	Error string `json:"error"`
+	Code  string `json:"code,omitempty"`
 }
 
 func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
@@ -41,14 +46,14 @@ func (h *AuthHandler) Register(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := h.authService.Register(req)
+	response, err := h.authService.Register(r.Context(), req, clientInfo(r))
 	if err != nil {
 		if err == service.ErrUserExists {
 			w.WriteHeader(http.StatusConflict)
 			json.NewEncoder(w).Encode(ErrorResponse{Error: "User already exists"})
 			return
 		}
-		fmt.Println(err)
+		slog.ErrorContext(r.Context(), "failed to register user", "error", err)
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to register user"})
 		return
@@ -74,8 +79,18 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	response, err := h.authService.Login(req)
+	response, err := h.authService.Login(r.Context(), req, clientInfo(r))
 	if err != nil {
+		var throttled *service.LoginThrottledError
+		if errors.As(err, &throttled) {
+			writeLoginThrottled(w, throttled)
+			return
+		}
+		var suspended *service.AccountSuspendedError
+		if errors.As(err, &suspended) {
+			writeAccountSuspended(w, suspended)
+			return
+		}
 		if err == service.ErrInvalidCredentials {
 			w.WriteHeader(http.StatusUnauthorized)
 			json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid credentials"})
@@ -89,3 +104,146 @@ func (h *AuthHandler) Login(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(response)
 }
+
+func writeAccountSuspended(w http.ResponseWriter, err *service.AccountSuspendedError) {
+	code := "account_suspended"
+	if err.Banned() {
+		code = "account_banned"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusForbidden)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Code: code})
+}
+
+func (h *AuthHandler) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	var req models.VerifyEmailRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.authService.VerifyEmail(r.Context(), req.Token); err != nil {
+		if err == service.ErrInvalidToken {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid or expired token"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to verify email"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "verified"})
+}
+
+// ResendVerification sends another verification email to the current user
+func (h *AuthHandler) ResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	if err := h.authService.ResendVerification(r.Context(), userID); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to send verification email"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "sent"})
+}
+
+// ForgotPassword always answers 202 so the endpoint cannot be used to probe for accounts
+func (h *AuthHandler) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ForgotPasswordRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.authService.ForgotPassword(r.Context(), req.Email); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to process request"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{"status": "sent"})
+}
+
+func (h *AuthHandler) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	var req models.ResetPasswordRequest
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	if err := h.authService.ResetPassword(r.Context(), req.Token, req.Password); err != nil {
+		if err == service.ErrInvalidToken {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid or expired token"})
+			return
+		}
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Failed to reset password"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+func writeLoginThrottled(w http.ResponseWriter, err *service.LoginThrottledError) {
+	retryAfter := int(math.Ceil(err.RetryAfter.Seconds()))
+	w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	w.WriteHeader(http.StatusTooManyRequests)
+
+	if err.Locked {
+		json.NewEncoder(w).Encode(ErrorResponse{
+			Error: "Too many failed login attempts, account temporarily locked",
+			Code:  "login_locked",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: "Too many failed login attempts, slow down",
+		Code:  "login_throttled",
+	})
+}
+
+func clientInfo(r *http.Request) models.ClientInfo {
+	return models.ClientInfo{
+		UserAgent: r.UserAgent(),
+		IPAddress: middleware.ClientIP(r),
+	}
+}
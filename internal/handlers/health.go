@@ -1,28 +1,139 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
-	"log"
+	"log/slog"
 	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
+// checkTimeout bounds each component check of a probe
+const checkTimeout = 2 * time.Second
+
 type HealthResponse struct {
 	Message string `json:"message"`
 	Status  string `json:"status"`
 }
 
-func Health(w http.ResponseWriter, r *http.Request) {
+// HealthCheck is a component check run by the probes
+type HealthCheck struct {
+	Name string
+	// Check returns details to include in the report, or nil
+	Check func(ctx context.Context) (any, error)
+}
+
+// NewHealthCheck adapts a check that has no details to report
+func NewHealthCheck(name string, check func(ctx context.Context) error) HealthCheck {
+	return HealthCheck{
+		Name: name,
+		Check: func(ctx context.Context) (any, error) {
+			return nil, check(ctx)
+		},
+	}
+}
+
+type ComponentReport struct {
+	Status    string  `json:"status"` // "ok" or "error"
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+	Details   any     `json:"details,omitempty"`
+}
+
+type ProbeReport struct {
+	Status     string                     `json:"status"` // "ok", "error" or "draining"
+	Components map[string]ComponentReport `json:"components,omitempty"`
+}
+
+// Readiness serves the liveness and readiness probes. Liveness only covers
+// what a restart can fix, such as a stuck hub loop. Readiness also covers the
+// dependencies and starts failing as soon as shutdown begins, so the load
+// balancer stops routing here before connections are drained.
+type Readiness struct {
+	draining atomic.Bool
+	live     []HealthCheck
+	ready    []HealthCheck
+}
+
+func NewReadiness(live, ready []HealthCheck) *Readiness {
+	return &Readiness{live: live, ready: ready}
+}
+
+// SetDraining makes the readiness probe fail from now on
+func (rd *Readiness) SetDraining() {
+	rd.draining.Store(true)
+}
+
+// Live handles GET /livez
+func (rd *Readiness) Live(w http.ResponseWriter, r *http.Request) {
+	writeProbeReport(w, r, runChecks(r.Context(), rd.live))
+}
+
+// Ready handles GET /readyz
+func (rd *Readiness) Ready(w http.ResponseWriter, r *http.Request) {
+	if rd.draining.Load() {
+		writeProbeReport(w, r, ProbeReport{Status: "draining"})
+		return
+	}
+	writeProbeReport(w, r, runChecks(r.Context(), rd.ready))
+}
+
+// runChecks runs the checks concurrently, each with its own timeout
+func runChecks(ctx context.Context, checks []HealthCheck) ProbeReport {
+	report := ProbeReport{
+		Status:     "ok",
+		Components: make(map[string]ComponentReport, len(checks)),
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			defer cancel()
+
+			start := time.Now()
+			details, err := check.Check(checkCtx)
+			component := ComponentReport{
+				Status:    "ok",
+				LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+				Details:   details,
+			}
+			if err != nil {
+				component.Status = "error"
+				component.Error = err.Error()
+				slog.WarnContext(ctx, "health check failed", "component", check.Name, "error", err)
+			}
+
+			mu.Lock()
+			report.Components[check.Name] = component
+			if err != nil {
+				report.Status = "error"
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+func writeProbeReport(w http.ResponseWriter, r *http.Request, report ProbeReport) {
 	w.Header().Set("Content-Type", "application/json")
-	response := HealthResponse{
-		Message: "Chat service is running",
-		Status:  "ok",
+	w.Header().Set("Cache-Control", "no-store")
+	if report.Status != "ok" {
+		w.WriteHeader(http.StatusServiceUnavailable)
 	}
 
-	err := json.NewEncoder(w).Encode(response)
-	if err != nil {
-		log.Fatal(err)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		slog.ErrorContext(r.Context(), "failed to encode probe report", "error", err)
 	}
 }
 
@@ -30,24 +141,20 @@ func DatabaseHealthCheck(db *sqlx.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
 
-		if err := db.Ping(); err != nil {
+		response := HealthResponse{
+			Message: "Database is available",
+			Status:  "ok",
+		}
+		if err := db.PingContext(r.Context()); err != nil {
 			w.WriteHeader(http.StatusServiceUnavailable)
-			response := HealthResponse{
+			response = HealthResponse{
 				Message: "Database is unavailable",
 				Status:  "error",
 			}
-
-			if err := json.NewEncoder(w).Encode(response); err != nil {
-				log.Fatal(err)
-			}
 		}
 
-		response := HealthResponse{
-			Message: "Database is available",
-			Status:  "ok",
-		}
 		if err := json.NewEncoder(w).Encode(response); err != nil {
-			log.Fatal(err)
+			slog.ErrorContext(r.Context(), "failed to encode health response", "error", err)
 		}
 	}
 }
@@ -2,6 +2,9 @@ package handlers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"time"
@@ -9,19 +12,81 @@ import (
 	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
 	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/service"
 	"github.com/go-chi/chi/v5"
 )
 
+// Page size of the held message queue
+const (
+	defaultHeldPageSize = 50
+	maxHeldPageSize     = 200
+
+	maxReviewNoteLength = 1000
+)
+
 type MessageHandler struct {
-	messageRepo *repository.MessageRepository
-	userRepo    *repository.UserRepository
+	messageRepo    *repository.MessageRepository
+	userRepo       *repository.UserRepository
+	messageService *service.MessageService
 }
 
-func NewMessageHandler(messageRepo *repository.MessageRepository, userRepo *repository.UserRepository) *MessageHandler {
+func NewMessageHandler(messageRepo *repository.MessageRepository, userRepo *repository.UserRepository, messageService *service.MessageService) *MessageHandler {
 	return &MessageHandler{
-		messageRepo: messageRepo,
-		userRepo:    userRepo,
+		messageRepo:    messageRepo,
+		userRepo:       userRepo,
+		messageService: messageService,
+	}
+}
+
+// SendMessage sends a chat message without a websocket. It goes through the
+// same MessageService as the websocket chat frame.
+func (h *MessageHandler) SendMessage(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	var req models.SendMessageRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return
+	}
+
+	message, err := h.messageService.Send(r.Context(), userID, req)
+
+	var rejected *service.MessageRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: rejected.Reason, Code: "message_rejected"})
+		return
+	case errors.Is(err, service.ErrMissingRecipient), errors.Is(err, service.ErrEmptyMessage):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	case errors.Is(err, service.ErrUserNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "user not found"})
+		return
+	case err != nil:
+		slog.ErrorContext(r.Context(), "failed to send message", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to send message"})
+		return
+	}
+
+	// Held messages are accepted but not delivered until reviewed
+	status := http.StatusCreated
+	if message.ModerationStatus == models.ModerationHeld {
+		status = http.StatusAccepted
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(message)
 }
 
 // GetConversation returns messages between current user and another user
@@ -53,7 +118,7 @@ func (h *MessageHandler) GetConversation(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Verify other user exists
-	otherUser, err := h.userRepo.GetByID(otherUserID)
+	otherUser, err := h.userRepo.GetByID(r.Context(), otherUserID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get user"})
@@ -66,7 +131,7 @@ func (h *MessageHandler) GetConversation(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Get conversation
-	messages, err := h.messageRepo.GetConversation(userID, otherUserID, limit)
+	messages, err := h.messageRepo.GetConversation(r.Context(), userID, otherUserID, limit)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get conversation"})
@@ -86,7 +151,7 @@ func (h *MessageHandler) GetUnreadCount(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	count, err := h.messageRepo.GetUnreadCount(userID)
+	count, err := h.messageRepo.GetUnreadCount(r.Context(), userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get unread count"})
@@ -114,7 +179,7 @@ func (h *MessageHandler) MarkAsRead(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if err := h.messageRepo.MarkAsRead(messageID, userID); err != nil {
+	if err := h.messageRepo.MarkAsRead(r.Context(), messageID, userID); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to mark as read"})
 		return
@@ -132,7 +197,7 @@ func (h *MessageHandler) GetConversationList(w http.ResponseWriter, r *http.Requ
 		return
 	}
 
-	conversations, err := h.messageRepo.GetConversationList(userID)
+	conversations, err := h.messageRepo.GetConversationList(r.Context(), userID)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to get conversations"})
@@ -149,7 +214,7 @@ func (h *MessageHandler) GetConversationList(w http.ResponseWriter, r *http.Requ
 
 	result := make([]ConversationWithUser, 0, len(conversations))
 	for _, conv := range conversations {
-		user, err := h.userRepo.GetByID(conv.OtherUserID)
+		user, err := h.userRepo.GetByID(r.Context(), conv.OtherUserID)
 		if err != nil || user == nil {
 			continue
 		}
@@ -166,3 +231,104 @@ func (h *MessageHandler) GetConversationList(w http.ResponseWriter, r *http.Requ
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(result)
 }
+
+// ListHeldMessages returns the messages moderation held for review, oldest first
+func (h *MessageHandler) ListHeldMessages(w http.ResponseWriter, r *http.Request) {
+	limit := defaultHeldPageSize
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= maxHeldPageSize {
+		limit = l
+	}
+	offset := 0
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	messages, err := h.messageService.ListHeld(r.Context(), limit, offset)
+	if err != nil {
+		slog.ErrorContext(r.Context(), "failed to list held messages", "error", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list held messages"})
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(messages)
+}
+
+// ApproveHeldMessage delivers a held message to its recipient
+func (h *MessageHandler) ApproveHeldMessage(w http.ResponseWriter, r *http.Request) {
+	moderatorID, messageID, req, ok := h.parseReview(w, r)
+	if !ok {
+		return
+	}
+
+	message, err := h.messageService.ApproveHeld(r.Context(), moderatorID, messageID, req.Note)
+	if err != nil {
+		writeHeldMessageError(w, r, err, "failed to approve message")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(message)
+}
+
+// RejectHeldMessage deletes a held message without delivering it
+func (h *MessageHandler) RejectHeldMessage(w http.ResponseWriter, r *http.Request) {
+	moderatorID, messageID, req, ok := h.parseReview(w, r)
+	if !ok {
+		return
+	}
+
+	if err := h.messageService.RejectHeld(r.Context(), moderatorID, messageID, req.Note); err != nil {
+		writeHeldMessageError(w, r, err, "failed to reject message")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "success"})
+}
+
+// parseReview reads the moderator, the message ID and the optional body of a
+// review action. It writes the error response and returns false on failure.
+func (h *MessageHandler) parseReview(w http.ResponseWriter, r *http.Request) (int, int, models.ReviewHeldMessageRequest, bool) {
+	var req models.ReviewHeldMessageRequest
+
+	moderatorID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return 0, 0, req, false
+	}
+
+	messageID, err := strconv.Atoi(chi.URLParam(r, "messageID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid message ID"})
+		return 0, 0, req, false
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid request body"})
+		return 0, 0, req, false
+	}
+	if len(req.Note) > maxReviewNoteLength {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "note is too long"})
+		return 0, 0, req, false
+	}
+
+	return moderatorID, messageID, req, true
+}
+
+func writeHeldMessageError(w http.ResponseWriter, r *http.Request, err error, fallback string) {
+	if errors.Is(err, service.ErrHeldMessageNotFound) {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Code: "held_message_not_found"})
+		return
+	}
+
+	slog.ErrorContext(r.Context(), fallback, "error", err)
+	w.WriteHeader(http.StatusInternalServerError)
+	json.NewEncoder(w).Encode(ErrorResponse{Error: fallback})
+}
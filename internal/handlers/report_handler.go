@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/service"
+	"github.com/go-chi/chi/v5"
+	"github.com/go-playground/validator/v10"
+)
+
+// Page size of the admin report queue
+const (
+	defaultReportPageSize = 50
+	maxReportPageSize     = 200
+)
+
+type ReportHandler struct {
+	reportService *service.ReportService
+	validator     *validator.Validate
+}
+
+func NewReportHandler(reportService *service.ReportService) *ReportHandler {
+	return &ReportHandler{
+		reportService: reportService,
+		validator:     validator.New(),
+	}
+}
+
+// CreateReport files a report against a message or a user
+func (h *ReportHandler) CreateReport(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	var req models.CreateReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := h.reportService.Create(r.Context(), userID, req)
+	if err != nil {
+		writeReportServiceError(w, err, "failed to create report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(report)
+}
+
+// ListReports returns the admin queue, filtered by the status query parameter
+func (h *ReportHandler) ListReports(w http.ResponseWriter, r *http.Request) {
+	status := models.ReportStatus(r.URL.Query().Get("status"))
+	switch status {
+	case "", models.ReportStatusOpen, models.ReportStatusClaimed, models.ReportStatusResolved:
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid status"})
+		return
+	}
+
+	limit := defaultReportPageSize
+	if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && l > 0 && l <= maxReportPageSize {
+		limit = l
+	}
+	offset := 0
+	if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+
+	reports, err := h.reportService.List(r.Context(), status, limit, offset)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to list reports"})
+		return
+	}
+	if reports == nil {
+		reports = []models.Report{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+func (h *ReportHandler) GetReport(w http.ResponseWriter, r *http.Request) {
+	reportID, err := strconv.Atoi(chi.URLParam(r, "reportID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid report ID"})
+		return
+	}
+
+	report, err := h.reportService.Get(r.Context(), reportID)
+	if err != nil {
+		writeReportServiceError(w, err, "failed to get report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// ClaimReport assigns the report to the current admin
+func (h *ReportHandler) ClaimReport(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	reportID, err := strconv.Atoi(chi.URLParam(r, "reportID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid report ID"})
+		return
+	}
+
+	report, err := h.reportService.Claim(r.Context(), adminID, reportID)
+	if err != nil {
+		writeReportServiceError(w, err, "failed to claim report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// ResolveReport acts on the report (dismiss, warn, suspend or delete_content)
+// and closes it
+func (h *ReportHandler) ResolveReport(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+
+	reportID, err := strconv.Atoi(chi.URLParam(r, "reportID"))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "invalid report ID"})
+		return
+	}
+
+	var req models.ResolveReportRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "Invalid request body"})
+		return
+	}
+
+	if err := h.validator.Struct(req); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	report, err := h.reportService.Resolve(r.Context(), adminID, reportID, req)
+	if err != nil {
+		writeReportServiceError(w, err, "failed to resolve report")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+func writeReportServiceError(w http.ResponseWriter, err error, fallback string) {
+	switch {
+	case errors.Is(err, service.ErrInvalidReportTarget), errors.Is(err, service.ErrCannotReportSelf),
+		errors.Is(err, service.ErrInvalidReportAction):
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+	case errors.Is(err, service.ErrMessageNotFound), errors.Is(err, service.ErrUserNotFound),
+		errors.Is(err, service.ErrReportNotFound):
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+	case errors.Is(err, service.ErrDuplicateReport):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "you already reported this", Code: "duplicate_report"})
+	case errors.Is(err, service.ErrInsufficientRole), errors.Is(err, service.ErrCannotModerateSelf):
+		w.WriteHeader(http.StatusForbidden)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+	case errors.Is(err, service.ErrReportConflict):
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error(), Code: "report_conflict"})
+	default:
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: fallback})
+	}
+}
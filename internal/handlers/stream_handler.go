@@ -0,0 +1,387 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	ws "github.com/HaykAghajanyan/chat-backend/internal/websocket"
+)
+
+const (
+	// How long a poll request waits for frames before returning an empty batch
+	pollTimeout = 25 * time.Second
+
+	// Poll sessions that are not polled for this long are dropped
+	pollSessionTTL = time.Minute
+
+	// How often expired poll sessions are looked for
+	pollReapPeriod = 15 * time.Second
+
+	// Delay EventSource waits before reconnecting, in milliseconds
+	sseRetryMS = 2000
+)
+
+// StreamHandler serves the fallback transports for clients that cannot use
+// websockets: Server-Sent Events and long-polling. Both receive the same frames
+// as websocket clients through the hub; messages are sent with POST /api/messages.
+// Since these clients cannot send presence_subscribe frames, both endpoints
+// take the users to watch as a comma-separated presence query parameter.
+//
+// Poll sessions are kept in memory on the node that created them. With several
+// nodes, the load balancer must route a client's polls to the same node, as
+// nginx.conf does with ip_hash; a poll reaching another node gets
+// poll_session_expired and has to start a new session.
+type StreamHandler struct {
+	hub         *ws.Hub
+	messageRepo *repository.MessageRepository
+	config      ws.Config
+
+	mu       sync.Mutex
+	sessions map[string]*pollSession
+}
+
+// pollSession is a hub client kept alive between poll requests. The last batch
+// stays unacknowledged until the next poll confirms it with ack, so a response
+// lost in transit is sent again.
+type pollSession struct {
+	id     string
+	client *ws.Client
+
+	// mu allows one poll at a time and guards the fields below
+	mu       sync.Mutex
+	seq      int
+	unacked  [][]byte
+	lastPoll atomic.Int64
+}
+
+type PollResponse struct {
+	SessionID string            `json:"session_id"`
+	Seq       int               `json:"seq"`
+	Frames    []json.RawMessage `json:"frames"`
+}
+
+func NewStreamHandler(hub *ws.Hub, messageRepo *repository.MessageRepository, config ws.Config) *StreamHandler {
+	h := &StreamHandler{
+		hub:         hub,
+		messageRepo: messageRepo,
+		config:      config.WithDefaults(),
+		sessions:    make(map[string]*pollSession),
+	}
+	go h.reapPollSessions()
+	return h
+}
+
+// Events streams frames as Server-Sent Events, one JSON frame per event
+func (h *StreamHandler) Events(w http.ResponseWriter, r *http.Request) {
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+	if h.hub.Draining() {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "server is shutting down"})
+		return
+	}
+	watch, err := parsePresenceQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	// Stops nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetryMS); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		slog.ErrorContext(r.Context(), "SSE: streaming not supported", "error", err)
+		return
+	}
+
+	client := ws.NewStreamClient(userID, h.config, models.PresenceStatus(r.URL.Query().Get("status")))
+	client.ConnID = newConnID()
+	client.Ctx = connContext(r.Context(), userID, client.ConnID)
+	client.SendHello()
+	h.hub.Register <- client
+	defer func() {
+		h.hub.Unregister <- client
+	}()
+	h.subscribePresence(r.Context(), client, watch)
+
+	for {
+		// Wake up periodically to send a heartbeat so proxies keep the stream open
+		ctx, cancel := context.WithTimeout(r.Context(), h.config.PongWait*9/10)
+		frames, err := client.Receive(ctx)
+		cancel()
+
+		rc.SetWriteDeadline(time.Now().Add(h.config.WriteWait))
+		switch {
+		case errors.Is(err, ws.ErrClientClosed):
+			data, _ := json.Marshal(map[string]string{"reason": client.CloseReason()})
+			fmt.Fprintf(w, "event: close\ndata: %s\n\n", data)
+			rc.Flush()
+			return
+		case r.Context().Err() != nil:
+			return
+		case errors.Is(err, context.DeadlineExceeded):
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+		default:
+			for _, frame := range frames {
+				if _, err := fmt.Fprintf(w, "data: %s\n\n", frame); err != nil {
+					return
+				}
+			}
+		}
+
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// Poll returns the next batch of frames, waiting up to pollTimeout. A request
+// without a session starts a new one and returns its hello frame; later
+// requests pass session and the seq of the last batch received as ack.
+func (h *StreamHandler) Poll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	userID, ok := middleware.GetUserIDFromContext(r.Context())
+	if !ok {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "unauthorized"})
+		return
+	}
+	watch, err := parsePresenceQuery(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: err.Error()})
+		return
+	}
+
+	sessionID := r.URL.Query().Get("session")
+	if sessionID == "" {
+		h.startPollSession(w, r, userID, watch)
+		return
+	}
+
+	h.mu.Lock()
+	session, ok := h.sessions[sessionID]
+	h.mu.Unlock()
+	if !ok || session.client.UserID != userID {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "poll session expired", Code: "poll_session_expired"})
+		return
+	}
+
+	if !session.mu.TryLock() {
+		w.WriteHeader(http.StatusConflict)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "a poll is already in progress", Code: "poll_in_progress"})
+		return
+	}
+	defer func() {
+		session.lastPoll.Store(time.Now().UnixNano())
+		session.mu.Unlock()
+	}()
+
+	// A poll with presence replaces the users watched by the session
+	h.subscribePresence(r.Context(), session.client, watch)
+
+	// Resend the last batch unless the client confirmed it
+	if len(session.unacked) > 0 {
+		if ack, err := strconv.Atoi(r.URL.Query().Get("ack")); err != nil || ack != session.seq {
+			json.NewEncoder(w).Encode(newPollResponse(session))
+			return
+		}
+		session.unacked = nil
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), pollTimeout)
+	defer cancel()
+
+	frames, err := session.client.Receive(ctx)
+	if errors.Is(err, ws.ErrClientClosed) {
+		h.endPollSession(session)
+		w.WriteHeader(http.StatusGone)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: session.client.CloseReason(), Code: "poll_session_closed"})
+		return
+	}
+	if len(frames) > 0 {
+		session.seq++
+		session.unacked = frames
+	}
+
+	json.NewEncoder(w).Encode(newPollResponse(session))
+}
+
+func (h *StreamHandler) startPollSession(w http.ResponseWriter, r *http.Request, userID int, watch []int) {
+	if h.hub.Draining() {
+		w.Header().Set("Retry-After", "1")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "server is shutting down"})
+		return
+	}
+
+	id, err := newPollSessionID()
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		json.NewEncoder(w).Encode(ErrorResponse{Error: "failed to start poll session"})
+		return
+	}
+
+	session := &pollSession{
+		id:     id,
+		client: ws.NewStreamClient(userID, h.config, models.PresenceStatus(r.URL.Query().Get("status"))),
+	}
+	// The session outlives this request; its context is set once and never
+	// replaced since hub goroutines read it concurrently
+	session.client.ConnID = newConnID()
+	session.client.Ctx = connContext(r.Context(), userID, session.client.ConnID)
+	session.lastPoll.Store(time.Now().UnixNano())
+	session.client.SendHello()
+
+	h.mu.Lock()
+	h.sessions[id] = session
+	h.mu.Unlock()
+	h.hub.Register <- session.client
+	h.subscribePresence(r.Context(), session.client, watch)
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	frames, _ := session.client.Receive(r.Context())
+	if len(frames) > 0 {
+		session.seq++
+		session.unacked = frames
+	}
+	json.NewEncoder(w).Encode(newPollResponse(session))
+}
+
+// subscribePresence subscribes a registered client to the presence of the
+// requested users it has a conversation with, as presence_subscribe does for
+// websocket clients. Other IDs are dropped silently.
+func (h *StreamHandler) subscribePresence(ctx context.Context, client *ws.Client, userIDs []int) {
+	if userIDs == nil {
+		return
+	}
+
+	contacts, err := h.messageRepo.FilterContacts(ctx, client.UserID, userIDs)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load presence contacts", "error", err)
+		return
+	}
+	// The hub ignores subscriptions of clients it has not registered yet, and
+	// Ping returns once the loop has handled the registration
+	if err := h.hub.Ping(ctx); err != nil {
+		return
+	}
+	h.hub.SubscribePresence(client, contacts)
+}
+
+// parsePresenceQuery returns the user IDs of the presence query parameter,
+// nil if it is absent and empty if it is set to nothing to stop watching
+func parsePresenceQuery(r *http.Request) ([]int, error) {
+	if !r.URL.Query().Has("presence") {
+		return nil, nil
+	}
+
+	userIDs := []int{}
+	for _, field := range strings.Split(r.URL.Query().Get("presence"), ",") {
+		if field == "" {
+			continue
+		}
+		id, err := strconv.Atoi(field)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("invalid user ID %q in presence", field)
+		}
+		userIDs = append(userIDs, id)
+	}
+	if len(userIDs) > ws.MaxPresenceSubscriptions {
+		return nil, fmt.Errorf("at most %d users can be subscribed to", ws.MaxPresenceSubscriptions)
+	}
+	return userIDs, nil
+}
+
+// endPollSession forgets the session and unregisters its client
+func (h *StreamHandler) endPollSession(session *pollSession) {
+	h.mu.Lock()
+	_, ok := h.sessions[session.id]
+	delete(h.sessions, session.id)
+	h.mu.Unlock()
+
+	if ok {
+		h.hub.Unregister <- session.client
+	}
+}
+
+// reapPollSessions ends sessions whose client stopped polling or was closed by the server
+func (h *StreamHandler) reapPollSessions() {
+	ticker := time.NewTicker(pollReapPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		expiredBefore := time.Now().Add(-pollSessionTTL).UnixNano()
+
+		h.mu.Lock()
+		var expired []*pollSession
+		for _, s := range h.sessions {
+			select {
+			case <-s.client.Done():
+				expired = append(expired, s)
+				continue
+			default:
+			}
+			if s.lastPoll.Load() < expiredBefore && s.mu.TryLock() {
+				expired = append(expired, s)
+				s.mu.Unlock()
+			}
+		}
+		h.mu.Unlock()
+
+		for _, s := range expired {
+			h.endPollSession(s)
+		}
+	}
+}
+
+func newPollResponse(session *pollSession) PollResponse {
+	frames := make([]json.RawMessage, len(session.unacked))
+	for i, f := range session.unacked {
+		frames[i] = f
+	}
+	return PollResponse{SessionID: session.id, Seq: session.seq, Frames: frames}
+}
+
+func newPollSessionID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	broker "github.com/HaykAghajanyan/chat-backend/internal/brocker"
+	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	ws "github.com/HaykAghajanyan/chat-backend/internal/websocket"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jmoiron/sqlx"
+)
+
+// newTestStreamHandler serves the fallback transports from a hub running
+// against an in-memory Redis and a mocked database
+func newTestStreamHandler(t *testing.T) (*StreamHandler, *ws.Hub, sqlmock.Sqlmock) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	b := broker.New(mr.Addr())
+	t.Cleanup(func() { b.Close() })
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	hub := ws.NewHub(b, repository.NewUserRepository(sqlxDB), repository.NewBlockRepository(sqlxDB))
+	go hub.Run()
+
+	// Frames published before the subscription is up would be lost
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		err := b.CheckSubscription(ctx)
+		cancel()
+		if err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("broker not subscribed: %v", err)
+		}
+	}
+
+	return NewStreamHandler(hub, repository.NewMessageRepository(sqlxDB), ws.Config{}), hub, mock
+}
+
+// poll sends a poll request as userID and decodes the response
+func poll(t *testing.T, h *StreamHandler, userID int, query string) (int, PollResponse) {
+	t.Helper()
+
+	req := httptest.NewRequest(http.MethodGet, "/api/events/poll?"+query, nil)
+	req = req.WithContext(context.WithValue(req.Context(), middleware.UserIDKey, userID))
+	rec := httptest.NewRecorder()
+	h.Poll(rec, req)
+
+	var resp PollResponse
+	if rec.Code == http.StatusOK {
+		if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, resp
+}
+
+// frameTypes returns the types of the frames in a poll response
+func frameTypes(t *testing.T, resp PollResponse) []models.WSMessageType {
+	t.Helper()
+
+	types := make([]models.WSMessageType, len(resp.Frames))
+	for i, f := range resp.Frames {
+		var msg models.WSOutgoingMessage
+		if err := json.Unmarshal(f, &msg); err != nil {
+			t.Fatal(err)
+		}
+		types[i] = msg.Type
+	}
+	return types
+}
+
+func TestPollSession(t *testing.T) {
+	h, hub, _ := newTestStreamHandler(t)
+
+	code, first := poll(t, h, 1, "")
+	if code != http.StatusOK || first.SessionID == "" {
+		t.Fatalf("starting a session = %d %+v", code, first)
+	}
+	if types := frameTypes(t, first); len(types) != 1 || types[0] != models.WSMessageTypeHello {
+		t.Fatalf("first batch = %v, want the hello frame", types)
+	}
+
+	hub.SendToUser(context.Background(), 1, []byte(`{"type":"chat","message_id":5}`))
+	session := "session=" + first.SessionID
+	_, second := poll(t, h, 1, session+"&ack=1")
+	if second.Seq != 2 || len(second.Frames) != 1 || !strings.Contains(string(second.Frames[0]), `"message_id":5`) {
+		t.Fatalf("second batch = %+v, want message 5", second)
+	}
+
+	// Without an ack for it, the batch is sent again
+	if _, again := poll(t, h, 1, session+"&ack=1"); again.Seq != 2 || len(again.Frames) != 1 {
+		t.Fatalf("unacknowledged batch = %+v, want it resent", again)
+	}
+
+	if code, _ := poll(t, h, 2, session+"&ack=2"); code != http.StatusNotFound {
+		t.Fatalf("another user's session = %d, want 404", code)
+	}
+	if code, _ := poll(t, h, 1, "session=unknown"); code != http.StatusNotFound {
+		t.Fatalf("unknown session = %d, want 404", code)
+	}
+}
+
+func TestPollPresence(t *testing.T) {
+	h, hub, mock := newTestStreamHandler(t)
+
+	if code, _ := poll(t, h, 1, "presence=2,x"); code != http.StatusBadRequest {
+		t.Fatalf("invalid presence = %d, want 400", code)
+	}
+
+	// Only contacts are watched; user 3 is dropped
+	mock.ExpectQuery("SELECT DISTINCT c.other_id").
+		WithArgs(1, sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"other_id"}).AddRow(2))
+
+	_, first := poll(t, h, 1, "presence=2,3")
+	types := frameTypes(t, first)
+	if len(types) != 2 || types[0] != models.WSMessageTypeHello || types[1] != models.WSMessageTypePresence {
+		t.Fatalf("first batch = %v, want hello and the presence snapshot", types)
+	}
+	var snapshot models.WSOutgoingMessage
+	json.Unmarshal(first.Frames[1], &snapshot)
+	if _, ok := snapshot.Statuses[3]; len(snapshot.Statuses) != 1 || ok {
+		t.Fatalf("snapshot = %v, want only the contact", snapshot.Statuses)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+
+	hub.Register <- ws.NewStreamClient(2, ws.Config{}, models.PresenceOnline)
+	_, update := poll(t, h, 1, "session="+first.SessionID+"&ack=1")
+	if types := frameTypes(t, update); len(types) != 1 || types[0] != models.WSMessageTypeOnline {
+		t.Fatalf("batch after the contact connected = %v, want online", types)
+	}
+}
+
+func TestEventsStream(t *testing.T) {
+	h, hub, _ := newTestStreamHandler(t)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.Events(w, r.WithContext(context.WithValue(r.Context(), middleware.UserIDKey, 1)))
+	}))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/events?presence=bad")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("invalid presence = %d, want 400", resp.StatusCode)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/api/events", nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	lines := bufio.NewScanner(resp.Body)
+	nextData := func() string {
+		t.Helper()
+		for lines.Scan() {
+			if data, ok := strings.CutPrefix(lines.Text(), "data: "); ok {
+				return data
+			}
+		}
+		t.Fatalf("stream ended: %v", lines.Err())
+		return ""
+	}
+
+	if data := nextData(); !strings.Contains(data, `"type":"hello"`) {
+		t.Fatalf("first event = %s, want the hello frame", data)
+	}
+	hub.SendToUser(context.Background(), 1, []byte(`{"type":"chat","message_id":9}`))
+	if data := nextData(); data != `{"type":"chat","message_id":9}` {
+		t.Fatalf("event = %s, want message 9", data)
+	}
+}
@@ -1,42 +1,89 @@
 package handlers
 
 import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/HaykAghajanyan/chat-backend/internal/logging"
 	"github.com/HaykAghajanyan/chat-backend/internal/middleware"
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/ratelimit"
 	"github.com/HaykAghajanyan/chat-backend/internal/repository"
 	"github.com/HaykAghajanyan/chat-backend/internal/service"
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
 	ws "github.com/HaykAghajanyan/chat-backend/internal/websocket"
 	"github.com/gorilla/websocket"
 )
 
 type WebSocketHandler struct {
-	hub         *ws.Hub
-	messageRepo *repository.MessageRepository
-	authService *service.AuthService
-	upgrader    websocket.Upgrader
+	hub            *ws.Hub
+	messageRepo    *repository.MessageRepository
+	messageService *service.MessageService
+	authService    *service.AuthService
+	config         ws.Config
+	upgrader       websocket.Upgrader
+
+	limiter     ratelimit.Limiter
+	connLimiter *ratelimit.Local
+	limits      WSRateLimits
 }
 
-func NewWebSocketHandler(hub *ws.Hub, messageRepo *repository.MessageRepository, authService *service.AuthService) *WebSocketHandler {
+// WSRateLimits are the quotas applied to incoming frames
+type WSRateLimits struct {
+	// Per user across all nodes, by frame type. The chat bucket is shared
+	// with POST /api/messages.
+	Chat     ratelimit.Rule
+	Typing   ratelimit.Rule
+	Presence ratelimit.Rule
+	Other    ratelimit.Rule
+
+	// Connection limits all frames on a single connection
+	Connection ratelimit.Rule
+	// Strikes is how many rejected frames a connection may send before it is
+	// closed as abusive
+	Strikes ratelimit.Rule
+}
+
+func NewWebSocketHandler(
+	hub *ws.Hub,
+	messageRepo *repository.MessageRepository,
+	messageService *service.MessageService,
+	authService *service.AuthService,
+	config ws.Config,
+	limiter ratelimit.Limiter,
+	limits WSRateLimits,
+) *WebSocketHandler {
+	config = config.WithDefaults()
 	return &WebSocketHandler{
-		hub:         hub,
-		messageRepo: messageRepo,
-		authService: authService,
-		upgrader: websocket.Upgrader{
-			ReadBufferSize:  1024,
-			WriteBufferSize: 1024,
-			CheckOrigin: func(r *http.Request) bool {
-				return true // Allow all origins in development
-			},
-		},
+		hub:            hub,
+		messageRepo:    messageRepo,
+		messageService: messageService,
+		authService:    authService,
+		config:         config,
+		upgrader:       config.Upgrader(),
+		limiter:        limiter,
+		connLimiter:    ratelimit.NewLocal(),
+		limits:         limits,
 	}
 }
 
 func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Request) {
+	// Clients are being moved off this node; they retry against another one
+	if h.hub.Draining() {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
 	// Try to get user ID from context first (from middleware)
 	userID, ok := middleware.GetUserIDFromContext(r.Context())
 
@@ -49,28 +96,61 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 		}
 
 		// Validate token
-		var err error
-		userID, err = h.authService.ValidateToken(token)
+		claims, err := h.authService.ValidateToken(r.Context(), token)
 		if err != nil {
 			http.Error(w, "Unauthorized: invalid token", http.StatusUnauthorized)
 			return
 		}
+		userID = claims.UserID
+	}
+
+	// Sessions of suspended and banned users are revoked, but a token validated
+	// through the middleware just before that must not open a connection either
+	if err := h.authService.CheckAccount(r.Context(), userID); err != nil {
+		var suspended *service.AccountSuspendedError
+		switch {
+		case errors.As(err, &suspended):
+			writeAccountSuspended(w, suspended)
+		case errors.Is(err, service.ErrInvalidCredentials):
+			http.Error(w, "Unauthorized: unknown user", http.StatusUnauthorized)
+		default:
+			http.Error(w, "Failed to load account", http.StatusInternalServerError)
+		}
+		return
 	}
 
 	// Upgrade HTTP connection to WebSocket
 	conn, err := h.upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Printf("WebSocket upgrade error: %v", err)
+		slog.WarnContext(r.Context(), "websocket upgrade failed", "error", err)
 		return
 	}
+	if h.config.EnableCompression {
+		conn.SetCompressionLevel(h.config.CompressionLevel)
+	}
 
-	// Create client
+	// Create client. The initial status can be chosen with ?status= so that
+	// invisible users never appear online.
+	connID := newConnID()
 	client := &ws.Client{
 		UserID: userID,
 		Conn:   &ws.Connection{Ws: conn},
-		Send:   make(chan []byte, 256),
+		Send:   make(chan *ws.Frame, h.config.SendBufferSize),
+		Status: models.PresenceStatus(r.URL.Query().Get("status")),
+
+		Protocol: conn.Subprotocol(),
+		Config:   h.config,
+
+		// The connection outlives the request, but keeps its log attributes
+		ConnID: connID,
+		Ctx:    connContext(r.Context(), userID, connID),
 	}
 
+	slog.InfoContext(client.Context(), "websocket connected", "protocol", client.Protocol)
+
+	// The hello frame must be the first frame the client receives
+	client.SendHello()
+
 	// Register client with hub
 	h.hub.Register <- client
 
@@ -79,89 +159,173 @@ func (h *WebSocketHandler) HandleWebSocket(w http.ResponseWriter, r *http.Reques
 	go client.ReadPump(h.hub, h.handleMessage)
 }
 
-func (h *WebSocketHandler) handleMessage(client *ws.Client, wsMsg *models.WSMessage) {
+func (h *WebSocketHandler) handleMessage(ctx context.Context, client *ws.Client, wsMsg *models.WSMessage) error {
+	if err := h.checkRateLimit(ctx, client, wsMsg.Type); err != nil {
+		return err
+	}
+
 	switch wsMsg.Type {
 	case models.WSMessageTypeChat:
-		h.handleChatMessage(client, wsMsg)
-	case models.WSMessageTypeTyping:
-		h.handleTypingMessage(client, wsMsg)
+		return h.handleChatMessage(ctx, client, wsMsg)
+	case models.WSMessageTypeTyping, models.WSMessageTypeTypingStart:
+		if wsMsg.Recipient <= 0 || wsMsg.Recipient == client.UserID {
+			return ws.NewProtocolError(models.WSErrorInvalidPayload, "invalid recipient")
+		}
+		h.hub.StartTyping(ctx, client.UserID, wsMsg.Recipient)
+	case models.WSMessageTypeTypingStop:
+		h.hub.StopTyping(client.UserID, wsMsg.Recipient)
 	case models.WSMessageTypeRead:
-		h.handleReadMessage(client, wsMsg)
+		return h.handleReadMessage(ctx, client, wsMsg)
+	case models.WSMessageTypePresenceSet:
+		return h.handlePresenceSetMessage(client, wsMsg)
+	case models.WSMessageTypePresenceSubscribe:
+		return h.handlePresenceSubscribeMessage(ctx, client, wsMsg)
 	default:
-		log.Printf("Unknown message type: %s", wsMsg.Type)
+		return ws.NewProtocolError(models.WSErrorUnknownType, fmt.Sprintf("unknown message type %q", wsMsg.Type))
 	}
+	return nil
 }
 
-func (h *WebSocketHandler) handleChatMessage(client *ws.Client, wsMsg *models.WSMessage) {
-	// Save message to database
-	message := &models.Message{
-		SenderID:    client.UserID,
+// checkRateLimit applies the connection quota and the per-user quota of the
+// frame type. Every rejected frame is a strike against the connection, and a
+// connection out of strikes is closed.
+func (h *WebSocketHandler) checkRateLimit(ctx context.Context, client *ws.Client, msgType models.WSMessageType) error {
+	connKey := "ws:conn:" + client.ConnID
+
+	res, _ := h.connLimiter.Allow(ctx, connKey, h.limits.Connection)
+	if res.Allowed {
+		name, rule := "ws", h.limits.Other
+		switch msgType {
+		case models.WSMessageTypeChat:
+			name, rule = "chat", h.limits.Chat
+		case models.WSMessageTypeTyping, models.WSMessageTypeTypingStart, models.WSMessageTypeTypingStop:
+			name, rule = "typing", h.limits.Typing
+		case models.WSMessageTypePresenceSet, models.WSMessageTypePresenceSubscribe:
+			name, rule = "presence", h.limits.Presence
+		}
+
+		key := name + ":user:" + strconv.Itoa(client.UserID)
+		var err error
+		res, err = h.limiter.Allow(ctx, key, rule)
+		if err != nil {
+			slog.ErrorContext(ctx, "rate limit: failed to check", "key", key, "error", err)
+		}
+		if res.Allowed {
+			return nil
+		}
+	}
+
+	if strike, _ := h.connLimiter.Allow(ctx, connKey+":strikes", h.limits.Strikes); !strike.Allowed {
+		slog.WarnContext(ctx, "closing connection: rate limit repeatedly exceeded")
+		go client.Close(websocket.ClosePolicyViolation, "rate limit exceeded")
+	}
+
+	return &ws.ProtocolError{
+		Code:       models.WSErrorRateLimited,
+		Message:    fmt.Sprintf("too many %s frames", msgType),
+		RetryAfter: res.RetryAfter,
+	}
+}
+
+func (h *WebSocketHandler) handleChatMessage(ctx context.Context, client *ws.Client, wsMsg *models.WSMessage) error {
+	message, err := h.messageService.Send(ctx, client.UserID, models.SendMessageRequest{
 		RecipientID: wsMsg.Recipient,
 		Content:     wsMsg.Content,
-		IsRead:      false,
+		Locale:      wsMsg.Locale,
+	})
+
+	var rejected *service.MessageRejectedError
+	switch {
+	case errors.As(err, &rejected):
+		return ws.NewProtocolError(models.WSErrorMessageRejected, rejected.Reason)
+	case errors.Is(err, service.ErrMissingRecipient), errors.Is(err, service.ErrEmptyMessage),
+		errors.Is(err, service.ErrUserNotFound):
+		return ws.NewProtocolError(models.WSErrorInvalidPayload, err.Error())
+	case err != nil:
+		return fmt.Errorf("sending message: %w", err)
 	}
 
-	if err := h.messageRepo.Create(message); err != nil {
-		log.Printf("Error saving message: %v", err)
-		return
+	// The message went through, but the sender is told what moderation did to it
+	switch message.ModerationStatus {
+	case models.ModerationHeld:
+		return ws.NewProtocolError(models.WSErrorMessageHeld, message.ModerationReason.String)
+	case models.ModerationMasked:
+		return ws.NewProtocolError(models.WSErrorMessageMasked, message.ModerationReason.String)
 	}
+	return nil
+}
 
-	// Send to recipient if online
-	outgoing := models.WSOutgoingMessage{
-		Type:      models.WSMessageTypeChat,
-		Message:   message,
-		SenderID:  client.UserID,
-		Timestamp: time.Now(),
+func (h *WebSocketHandler) handleReadMessage(ctx context.Context, client *ws.Client, wsMsg *models.WSMessage) error {
+	if wsMsg.Recipient <= 0 {
+		return ws.NewProtocolError(models.WSErrorInvalidPayload, "missing recipient")
 	}
 
-	data, err := json.Marshal(outgoing)
+	// Mark messages from wsMsg.Recipient to client.UserID as read
+	err := h.messageRepo.MarkAsRead(ctx, wsMsg.Recipient, client.UserID)
+	if errors.Is(err, sql.ErrNoRows) {
+		// Nothing was unread
+		return nil
+	}
 	if err != nil {
-		log.Printf("Error marshaling message: %v", err)
-		return
+		return fmt.Errorf("marking messages as read: %w", err)
 	}
 
-	h.hub.SendToUser(wsMsg.Recipient, data)
-
-	// Send confirmation back to sender
-	h.hub.SendToUser(client.UserID, data)
-}
-
-func (h *WebSocketHandler) handleTypingMessage(client *ws.Client, wsMsg *models.WSMessage) {
-	// Forward typing indicator to recipient
+	// Notify the original sender that their messages were read
 	outgoing := models.WSOutgoingMessage{
-		Type:      models.WSMessageTypeTyping,
+		Type:      models.WSMessageTypeRead,
 		SenderID:  client.UserID,
 		Timestamp: time.Now(),
 	}
 
 	data, err := json.Marshal(outgoing)
 	if err != nil {
-		log.Printf("Error marshaling typing message: %v", err)
-		return
+		return fmt.Errorf("marshaling read message: %w", err)
 	}
 
-	h.hub.SendToUser(wsMsg.Recipient, data)
+	h.hub.SendToUser(ctx, wsMsg.Recipient, data)
+	return nil
 }
 
-func (h *WebSocketHandler) handleReadMessage(client *ws.Client, wsMsg *models.WSMessage) {
-	// Mark messages from wsMsg.Recipient to client.UserID as read
-	if err := h.messageRepo.MarkAsRead(wsMsg.Recipient, client.UserID); err != nil {
-		log.Printf("Error marking message as read: %v", err)
-		return
+func (h *WebSocketHandler) handlePresenceSetMessage(client *ws.Client, wsMsg *models.WSMessage) error {
+	if !wsMsg.Status.IsSettable() {
+		return ws.NewProtocolError(models.WSErrorInvalidPayload, fmt.Sprintf("invalid presence status %q", wsMsg.Status))
 	}
 
-	// Notify the original sender that their messages were read
-	outgoing := models.WSOutgoingMessage{
-		Type:      models.WSMessageTypeRead,
-		SenderID:  client.UserID,
-		Timestamp: time.Now(),
+	h.hub.SetStatus(client, wsMsg.Status)
+	return nil
+}
+
+// handlePresenceSubscribeMessage subscribes the client to the presence of the
+// requested users it has a conversation with. Other IDs, including users on
+// either side of a block, are dropped silently so the reply does not reveal
+// who exists or who blocked whom.
+func (h *WebSocketHandler) handlePresenceSubscribeMessage(ctx context.Context, client *ws.Client, wsMsg *models.WSMessage) error {
+	if len(wsMsg.UserIDs) > ws.MaxPresenceSubscriptions {
+		return ws.NewProtocolError(models.WSErrorInvalidPayload,
+			fmt.Sprintf("at most %d users can be subscribed to", ws.MaxPresenceSubscriptions))
 	}
 
-	data, err := json.Marshal(outgoing)
+	contacts, err := h.messageRepo.FilterContacts(ctx, client.UserID, wsMsg.UserIDs)
 	if err != nil {
-		log.Printf("Error marshaling read message: %v", err)
-		return
+		slog.ErrorContext(ctx, "failed to load presence contacts", "error", err)
+		return ws.NewProtocolError(models.WSErrorInternal, "failed to subscribe to presence")
 	}
 
-	h.hub.SendToUser(wsMsg.Recipient, data)
+	h.hub.SubscribePresence(client, contacts)
+	return nil
+}
+
+// connContext derives the logging context of a connection from its upgrade
+// request. It is not cancelled when the request returns, and frames start
+// their own traces rather than joining the upgrade request's.
+func connContext(ctx context.Context, userID int, connID string) context.Context {
+	ctx = tracing.Detach(context.WithoutCancel(ctx))
+	ctx = logging.WithUserID(ctx, userID)
+	return logging.With(ctx, "conn_id", connID)
+}
+
+func newConnID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
 }
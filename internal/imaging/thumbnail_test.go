@@ -0,0 +1,45 @@
+package imaging
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestThumbnail(t *testing.T) {
+	// A 300x100 image: a transparent middle square between two red ones, so
+	// the center crop is fully transparent
+	src := image.NewNRGBA(image.Rect(0, 0, 300, 100))
+	red := color.NRGBA{R: 255, A: 255}
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 300; x++ {
+			if x < 100 || x >= 200 {
+				src.SetNRGBA(x, y, red)
+			}
+		}
+	}
+
+	thumb := Thumbnail(src, 32, color.White)
+	if got := thumb.Bounds(); got != image.Rect(0, 0, 32, 32) {
+		t.Fatalf("bounds = %v, want 32x32", got)
+	}
+	for _, p := range []image.Point{{0, 0}, {16, 16}, {31, 31}} {
+		if got := thumb.RGBAAt(p.X, p.Y); got != (color.RGBA{255, 255, 255, 255}) {
+			t.Errorf("pixel %v = %v, want the white background", p, got)
+		}
+	}
+}
+
+func TestThumbnailUpscales(t *testing.T) {
+	src := image.NewRGBA(image.Rect(10, 10, 14, 12))
+	for y := 10; y < 12; y++ {
+		for x := 10; x < 14; x++ {
+			src.SetRGBA(x, y, color.RGBA{B: 200, A: 255})
+		}
+	}
+
+	thumb := Thumbnail(src, 16, color.White)
+	if got := thumb.RGBAAt(8, 8); got != (color.RGBA{B: 200, A: 255}) {
+		t.Fatalf("center pixel = %v, want the source color", got)
+	}
+}
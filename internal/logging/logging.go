@@ -0,0 +1,122 @@
+// Package logging configures the process-wide slog logger and carries
+// request-scoped attributes such as the request, user and connection IDs in
+// the context, so every log call made with that context includes them.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"slices"
+	"strings"
+	"sync/atomic"
+)
+
+// Setup installs a JSON or text logger writing to w as the slog default. The
+// standard library logger is redirected to it as well.
+func Setup(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid log level %q", level)
+	}
+
+	opts := &slog.HandlerOptions{Level: lvl}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		handler = slog.NewJSONHandler(w, opts)
+	case "text":
+		handler = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("invalid log format %q, expected json or text", format)
+	}
+
+	// slog takes over the output of the log package; its own timestamp is redundant
+	log.SetFlags(0)
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	return nil
+}
+
+type attrsKey struct{}
+type requestKey struct{}
+
+// With returns a context whose log records carry the given attributes. An
+// attribute replaces one with the same key already in the context.
+func With(ctx context.Context, args ...any) context.Context {
+	r := slog.Record{}
+	r.Add(args...)
+	added := make([]slog.Attr, 0, r.NumAttrs())
+	r.Attrs(func(a slog.Attr) bool {
+		added = append(added, a)
+		return true
+	})
+
+	parent, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	attrs := make([]slog.Attr, 0, len(parent)+len(added))
+	for _, a := range parent {
+		if !slices.ContainsFunc(added, func(b slog.Attr) bool { return b.Key == a.Key }) {
+			attrs = append(attrs, a)
+		}
+	}
+	attrs = append(attrs, added...)
+	return context.WithValue(ctx, attrsKey{}, attrs)
+}
+
+// request is shared by every context derived from one HTTP request, so values
+// set deep in the handler chain are visible to the access log
+type request struct {
+	id     string
+	userID atomic.Int64
+}
+
+// WithRequestID starts the logging scope of an HTTP request
+func WithRequestID(ctx context.Context, id string) context.Context {
+	ctx = context.WithValue(ctx, requestKey{}, &request{id: id})
+	return With(ctx, "request_id", id)
+}
+
+// RequestID returns the ID of the request the context belongs to, if any
+func RequestID(ctx context.Context) string {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return req.id
+	}
+	return ""
+}
+
+// WithUserID tags log records with the authenticated user
+func WithUserID(ctx context.Context, userID int) context.Context {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		req.userID.Store(int64(userID))
+	}
+	return With(ctx, "user_id", userID)
+}
+
+// RequestUserID returns the user authenticated during the request, or 0
+func RequestUserID(ctx context.Context) int {
+	if req, ok := ctx.Value(requestKey{}).(*request); ok {
+		return int(req.userID.Load())
+	}
+	return 0
+}
+
+// contextHandler adds the attributes stored in the context to each record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, r slog.Record) error {
+	if attrs, ok := ctx.Value(attrsKey{}).([]slog.Attr); ok {
+		r.AddAttrs(attrs...)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,45 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// LogMailer writes messages to a file (or the standard logger when no path is set)
+// instead of delivering them. Intended for development and tests.
+type LogMailer struct {
+	path string
+	mu   sync.Mutex
+}
+
+func NewLogMailer(path string) *LogMailer {
+	return &LogMailer{path: path}
+}
+
+func (m *LogMailer) Send(ctx context.Context, msg Message) error {
+	entry := fmt.Sprintf(
+		"=== %s ===\nTo: %s\nSubject: %s\n\n%s\n",
+		time.Now().Format(time.RFC3339), msg.To, msg.Subject, msg.TextBody,
+	)
+
+	if m.path == "" {
+		slog.InfoContext(ctx, "mailer: message not delivered", "to", msg.To, "subject", msg.Subject, "body", msg.TextBody)
+		return nil
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("mailer: failed to open mail log: %w", err)
+	}
+	defer f.Close()
+
+	_, err = f.WriteString(entry)
+	return err
+}
@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"fmt"
+	htmltemplate "html/template"
+	texttemplate "text/template"
+)
+
+//go:embed templates/*
+var templateFS embed.FS
+
+var (
+	htmlTemplates = htmltemplate.Must(htmltemplate.ParseFS(templateFS, "templates/*.html"))
+	textTemplates = texttemplate.Must(texttemplate.ParseFS(templateFS, "templates/*.txt"))
+)
+
+type Message struct {
+	To       string
+	Subject  string
+	TextBody string
+	HTMLBody string
+}
+
+// Mailer delivers a composed message to its recipient
+type Mailer interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Compose renders the named template pair (<name>.txt and <name>.html) into a message.
+// The text template must define a "subject" block.
+func Compose(to, name string, data any) (Message, error) {
+	var subject, text, html bytes.Buffer
+
+	if err := textTemplates.ExecuteTemplate(&subject, "subject_"+name, data); err != nil {
+		return Message{}, fmt.Errorf("mailer: failed to render subject for %s: %w", name, err)
+	}
+	if err := textTemplates.ExecuteTemplate(&text, name+".txt", data); err != nil {
+		return Message{}, fmt.Errorf("mailer: failed to render text body for %s: %w", name, err)
+	}
+	if err := htmlTemplates.ExecuteTemplate(&html, name+".html", data); err != nil {
+		return Message{}, fmt.Errorf("mailer: failed to render html body for %s: %w", name, err)
+	}
+
+	return Message{
+		To:       to,
+		Subject:  subject.String(),
+		TextBody: text.String(),
+		HTMLBody: html.String(),
+	}, nil
+}
@@ -5,12 +5,18 @@ import (
 	"net/http"
 	"strings"
 
+	"github.com/HaykAghajanyan/chat-backend/internal/logging"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
 	"github.com/HaykAghajanyan/chat-backend/internal/service"
 )
 
 type contextKey string
 
-const UserIDKey contextKey = "user_id"
+const (
+	UserIDKey    contextKey = "user_id"
+	SessionIDKey contextKey = "session_id"
+	RoleKey      contextKey = "role"
+)
 
 type AuthMiddleware struct {
 	authService *service.AuthService
@@ -38,19 +44,62 @@ func (m *AuthMiddleware) RequireAuth(next http.Handler) http.Handler {
 
 		tokenString := parts[1]
 
-		userId, err := m.authService.ValidateToken(tokenString)
+		claims, err := m.authService.ValidateToken(r.Context(), tokenString)
 
 		if err != nil {
 			http.Error(w, `{"error":"invalid token"}`, http.StatusUnauthorized)
 			return
 		}
 
-		ctx := context.WithValue(r.Context(), UserIDKey, userId)
+		ctx := logging.WithUserID(r.Context(), claims.UserID)
+		ctx = context.WithValue(ctx, UserIDKey, claims.UserID)
+		ctx = context.WithValue(ctx, SessionIDKey, claims.SessionID)
+		ctx = context.WithValue(ctx, RoleKey, claims.Role)
 		next.ServeHTTP(w, r.WithContext(ctx))
 	})
 }
 
+// RequireStreamAuth is RequireAuth for endpoints opened by EventSource, which
+// cannot set headers: the token may also be passed in the token query parameter.
+func (m *AuthMiddleware) RequireStreamAuth(next http.Handler) http.Handler {
+	requireAuth := m.RequireAuth(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") == "" {
+			if token := r.URL.Query().Get("token"); token != "" {
+				r = r.Clone(r.Context())
+				r.Header.Set("Authorization", "Bearer "+token)
+			}
+		}
+		requireAuth.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets through users whose role includes the given one. It
+// must run after RequireAuth.
+func RequireRole(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			userRole, ok := GetRoleFromContext(r.Context())
+			if !ok || !userRole.Includes(role) {
+				http.Error(w, `{"error":"forbidden"}`, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
 func GetUserIDFromContext(ctx context.Context) (int, bool) {
 	userID, ok := ctx.Value(UserIDKey).(int)
 	return userID, ok
 }
+
+func GetRoleFromContext(ctx context.Context) (models.Role, bool) {
+	role, ok := ctx.Value(RoleKey).(models.Role)
+	return role, ok
+}
+
+func GetSessionIDFromContext(ctx context.Context) (string, bool) {
+	sessionID, ok := ctx.Value(SessionIDKey).(string)
+	return sessionID, ok
+}
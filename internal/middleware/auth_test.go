@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+)
+
+func TestRequireRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		userRole any
+		required models.Role
+		want     int
+	}{
+		{"user on moderator route", models.RoleUser, models.RoleModerator, http.StatusForbidden},
+		{"moderator on moderator route", models.RoleModerator, models.RoleModerator, http.StatusOK},
+		{"admin on moderator route", models.RoleAdmin, models.RoleModerator, http.StatusOK},
+		{"moderator on admin route", models.RoleModerator, models.RoleAdmin, http.StatusForbidden},
+		{"admin on admin route", models.RoleAdmin, models.RoleAdmin, http.StatusOK},
+		{"unknown role", models.Role("owner"), models.RoleUser, http.StatusForbidden},
+		// A token issued before roles existed carries none
+		{"no role", nil, models.RoleUser, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := RequireRole(tt.required)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+			r := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+			if tt.userRole != nil {
+				r = r.WithContext(context.WithValue(r.Context(), RoleKey, tt.userRole))
+			}
+			rec := httptest.NewRecorder()
+			handler.ServeHTTP(rec, r)
+
+			if rec.Code != tt.want {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want)
+			}
+		})
+	}
+}
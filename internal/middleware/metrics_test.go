@@ -0,0 +1,61 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+)
+
+// requestCount returns the number of requests recorded for a label set
+func requestCount(t *testing.T, method, route, status string) uint64 {
+	t.Helper()
+
+	var m dto.Metric
+	h := httpRequestDuration.WithLabelValues(method, route, status).(prometheus.Histogram)
+	if err := h.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetHistogram().GetSampleCount()
+}
+
+func TestMetrics(t *testing.T) {
+	r := chi.NewRouter()
+	r.Use(Metrics)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})
+	r.Get("/ok", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	})
+
+	tests := []struct {
+		path   string
+		route  string
+		status string
+	}{
+		{"/users/1", "/users/{id}", "201"},
+		{"/users/2", "/users/{id}", "201"},
+		{"/ok", "/ok", "200"},
+		{"/missing", "unmatched", "404"},
+	}
+
+	before := make(map[int]uint64)
+	for i, tt := range tests {
+		before[i] = requestCount(t, http.MethodGet, tt.route, tt.status)
+	}
+	for _, tt := range tests {
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, tt.path, nil))
+	}
+
+	// Both /users requests land in the same series
+	want := map[string]uint64{"/users/{id}": 2, "/ok": 1, "unmatched": 1}
+	for i, tt := range tests {
+		if got := requestCount(t, http.MethodGet, tt.route, tt.status) - before[i]; got != want[tt.route] {
+			t.Errorf("%s: %d requests recorded under %q, want %d", tt.path, got, tt.route, want[tt.route])
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/ratelimit"
+)
+
+type RateLimiter struct {
+	limiter ratelimit.Limiter
+}
+
+func NewRateLimiter(limiter ratelimit.Limiter) *RateLimiter {
+	return &RateLimiter{limiter: limiter}
+}
+
+// Limit applies rule to each authenticated user, or to each client IP on
+// public routes. Requests over the limit get 429 with a Retry-After header.
+// The name separates the buckets of different rules.
+func (m *RateLimiter) Limit(name string, rule ratelimit.Rule) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key := name + ":ip:" + ClientIP(r)
+			if userID, ok := GetUserIDFromContext(r.Context()); ok {
+				key = name + ":user:" + strconv.Itoa(userID)
+			}
+
+			res, err := m.limiter.Allow(r.Context(), key, rule)
+			if err != nil {
+				slog.ErrorContext(r.Context(), "rate limit: failed to check", "key", key, "error", err)
+			}
+			if !res.Allowed {
+				seconds := int(math.Ceil(res.RetryAfter.Seconds()))
+				w.Header().Set("Retry-After", strconv.Itoa(seconds))
+				w.Header().Set("Content-Type", "application/json")
+				w.WriteHeader(http.StatusTooManyRequests)
+				fmt.Fprintf(w, `{"error":"rate limit exceeded, retry after %ds","code":"rate_limited"}`+"\n", seconds)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIP returns the client address without the port. Behind a trusted
+// proxy it is the address the proxy reported, see RealIP.
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	trusted, err := ParseTrustedProxies("10.0.0.0/8, 192.168.1.10")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		peer    string
+		headers map[string]string
+		want    string
+	}{
+		{"direct client", "203.0.113.7:5000", nil, "203.0.113.7"},
+		{"untrusted peer sets X-Real-IP", "203.0.113.7:5000", map[string]string{"X-Real-IP": "1.2.3.4"}, "203.0.113.7"},
+		{"trusted proxy", "10.1.2.3:5000", map[string]string{"X-Real-IP": "198.51.100.9"}, "198.51.100.9"},
+		{"trusted single address", "192.168.1.10:5000", map[string]string{"X-Real-IP": "198.51.100.9"}, "198.51.100.9"},
+		{"other address in /24", "192.168.1.11:5000", map[string]string{"X-Real-IP": "198.51.100.9"}, "192.168.1.11"},
+		{"True-Client-IP ignored", "10.1.2.3:5000", map[string]string{"True-Client-IP": "1.2.3.4", "X-Real-IP": "198.51.100.9"}, "198.51.100.9"},
+		{"X-Forwarded-For ignored", "10.1.2.3:5000", map[string]string{"X-Forwarded-For": "1.2.3.4"}, "10.1.2.3"},
+		{"invalid X-Real-IP", "10.1.2.3:5000", map[string]string{"X-Real-IP": "not-an-ip"}, "10.1.2.3"},
+		{"IPv6 client", "10.1.2.3:5000", map[string]string{"X-Real-IP": "2001:db8::1"}, "2001:db8::1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got string
+			handler := RealIP(trusted)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = ClientIP(r)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.RemoteAddr = tt.peer
+			for k, v := range tt.headers {
+				r.Header.Set(k, v)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got != tt.want {
+				t.Errorf("ClientIP = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesInvalid(t *testing.T) {
+	for _, s := range []string{"nope", "10.0.0.0/33", "10.0.0.1,bad"} {
+		if _, err := ParseTrustedProxies(s); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) succeeded", s)
+		}
+	}
+}
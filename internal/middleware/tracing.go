@@ -0,0 +1,58 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/logging"
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	chimiddleware "github.com/go-chi/chi/v5/middleware"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing records a server span for every request, continuing the trace of a
+// caller that sent a traceparent header. The span is named after the chi
+// route pattern once routing is done. Log records made with the request
+// context carry the trace ID.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+
+		ctx, span := tracing.Start(ctx, r.Method, trace.SpanKindServer)
+		if !span.IsRecording() {
+			next.ServeHTTP(w, r.WithContext(ctx))
+			return
+		}
+		ctx = logging.With(ctx, "trace_id", tracing.TraceID(span))
+		ww := chimiddleware.NewWrapResponseWriter(w, r.ProtoMajor)
+
+		defer func() {
+			status := ww.Status()
+			if status == 0 {
+				status = http.StatusOK
+			}
+
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = rctx.RoutePattern()
+			}
+
+			span.SetName(r.Method + " " + route)
+			span.SetAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.Int("http.response.status_code", status),
+			)
+			if status >= 500 {
+				tracing.Fail(span, http.StatusText(status))
+			}
+			span.End()
+		}()
+
+		next.ServeHTTP(ww, r.WithContext(ctx))
+	})
+}
@@ -0,0 +1,78 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
+	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := tracing.Setup(exporter, "chat-test", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer shutdown(context.Background())
+
+	var handlerSpan trace.SpanContext
+	r := chi.NewRouter()
+	r.Use(Tracing)
+	r.Get("/users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		handlerSpan = trace.SpanContextFromContext(r.Context())
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/users/42", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	r.ServeHTTP(httptest.NewRecorder(), req)
+
+	if err := otel.GetTracerProvider().(*sdktrace.TracerProvider).ForceFlush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	spans := exporter.GetSpans()
+	if len(spans) != 1 {
+		t.Fatalf("exported %d spans, want 1", len(spans))
+	}
+	span := spans[0]
+
+	if span.Name != "GET /users/{id}" {
+		t.Errorf("name = %q, want the route pattern", span.Name)
+	}
+	if got := span.SpanContext.TraceID().String(); got != "0af7651916cd43dd8448eb211c80319c" {
+		t.Errorf("trace ID = %s, want the caller's", got)
+	}
+	if got := span.Parent.SpanID().String(); got != "b7ad6b7169203331" {
+		t.Errorf("parent = %s, want the caller's span", got)
+	}
+	if handlerSpan.SpanID() != span.SpanContext.SpanID() {
+		t.Errorf("handler context does not carry the server span")
+	}
+	if span.Status.Code != codes.Error {
+		t.Errorf("status = %+v, want an error for a 503", span.Status)
+	}
+
+	want := map[attribute.Key]attribute.Value{
+		"http.route":                attribute.StringValue("/users/{id}"),
+		"url.path":                  attribute.StringValue("/users/42"),
+		"http.response.status_code": attribute.IntValue(http.StatusServiceUnavailable),
+	}
+	for _, kv := range span.Attributes {
+		if v, ok := want[kv.Key]; ok && v != kv.Value {
+			t.Errorf("%s = %v, want %v", kv.Key, kv.Value.Emit(), v.Emit())
+		}
+		delete(want, kv.Key)
+	}
+	for key := range want {
+		t.Errorf("attribute %s missing", key)
+	}
+}
@@ -0,0 +1,320 @@
+// Package migrate applies versioned SQL migrations and records them in the
+// schema_migrations table. Runs are serialised with a Postgres advisory lock so
+// several server nodes can start at the same time.
+//
+// A database set up before schema_migrations existed has every migration
+// applied once more; the scripts are idempotent so this only records them.
+package migrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// Arbitrary key of the advisory lock held while migrating
+const lockKey = 7_243_112_901
+
+var (
+	// ErrChecksumMismatch means an applied migration was edited afterwards
+	ErrChecksumMismatch = errors.New("migration changed after it was applied")
+	ErrNoDownMigration  = errors.New("migration has no down script")
+	// ErrPending means the database is behind the migrations of this binary
+	ErrPending = errors.New("database has pending migrations")
+)
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // empty if the migration cannot be reverted
+}
+
+// Checksum identifies the up script; editing an applied migration changes it
+func (m Migration) Checksum() string {
+	sum := sha256.Sum256([]byte(m.Up))
+	return hex.EncodeToString(sum[:])
+}
+
+func (m Migration) String() string {
+	return fmt.Sprintf("%03d_%s", m.Version, m.Name)
+}
+
+// Status is a migration and whether it has been applied
+type Status struct {
+	Migration
+	AppliedAt *time.Time
+	// Modified is set when the applied checksum differs from the current script
+	Modified bool
+}
+
+type Migrator struct {
+	db         *sqlx.DB
+	migrations []Migration
+}
+
+// New loads NNN_name.sql and NNN_name.down.sql files from the root of fsys
+func New(db *sqlx.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Up applies every pending migration in version order, each in its own
+// transaction, and returns the ones applied
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, mig := range m.migrations {
+			if checksum, ok := versions[mig.Version]; ok {
+				if checksum != mig.Checksum() {
+					return fmt.Errorf("%s: %w", mig, ErrChecksumMismatch)
+				}
+				continue
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Up); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx,
+					`INSERT INTO schema_migrations (version, name, checksum) VALUES ($1, $2, $3)`,
+					mig.Version, mig.Name, mig.Checksum())
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("applying %s: %w", mig, err)
+			}
+			applied = append(applied, mig)
+		}
+		return nil
+	})
+
+	return applied, err
+}
+
+// Down reverts the last steps applied migrations, newest first, and returns the
+// ones reverted
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+
+	err := m.locked(ctx, func(conn *sql.Conn) error {
+		versions, err := appliedVersions(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			mig := m.migrations[i]
+			if _, ok := versions[mig.Version]; !ok {
+				continue
+			}
+			if mig.Down == "" {
+				return fmt.Errorf("%s: %w", mig, ErrNoDownMigration)
+			}
+
+			err := inTx(ctx, conn, func(tx *sql.Tx) error {
+				if _, err := tx.ExecContext(ctx, mig.Down); err != nil {
+					return err
+				}
+				_, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations WHERE version = $1`, mig.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("reverting %s: %w", mig, err)
+			}
+			reverted = append(reverted, mig)
+		}
+		return nil
+	})
+
+	return reverted, err
+}
+
+// Status lists every known migration with the time it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	if _, err := m.db.ExecContext(ctx, createTableQuery); err != nil {
+		return nil, fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	var rows []struct {
+		Version   int       `db:"version"`
+		Checksum  string    `db:"checksum"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := m.db.SelectContext(ctx, &rows, `SELECT version, checksum, applied_at FROM schema_migrations`); err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	statuses := make([]Status, len(m.migrations))
+	for i, mig := range m.migrations {
+		statuses[i].Migration = mig
+		for _, row := range rows {
+			if row.Version == mig.Version {
+				appliedAt := row.AppliedAt
+				statuses[i].AppliedAt = &appliedAt
+				statuses[i].Modified = row.Checksum != mig.Checksum()
+			}
+		}
+	}
+	return statuses, nil
+}
+
+// SchemaVersion compares the database with the migrations of this binary
+type SchemaVersion struct {
+	Current int `json:"current"` // highest applied version
+	Latest  int `json:"latest"`  // highest known version
+	Pending int `json:"pending"` // known migrations not applied yet
+}
+
+// Version reads the applied migrations. Unlike Status it never writes, so it
+// is cheap enough for health checks. A database without schema_migrations is
+// an error.
+func (m *Migrator) Version(ctx context.Context) (SchemaVersion, error) {
+	var applied []int
+	if err := m.db.SelectContext(ctx, &applied, `SELECT version FROM schema_migrations`); err != nil {
+		return SchemaVersion{}, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+
+	var v SchemaVersion
+	for _, version := range applied {
+		v.Current = max(v.Current, version)
+	}
+	for _, mig := range m.migrations {
+		v.Latest = max(v.Latest, mig.Version)
+		if !slices.Contains(applied, mig.Version) {
+			v.Pending++
+		}
+	}
+	return v, nil
+}
+
+const createTableQuery = `
+CREATE TABLE IF NOT EXISTS schema_migrations
+(
+    version    INTEGER PRIMARY KEY,
+    name       VARCHAR(255) NOT NULL,
+    checksum   VARCHAR(64)  NOT NULL,
+    applied_at TIMESTAMP    NOT NULL DEFAULT CURRENT_TIMESTAMP
+)`
+
+// locked runs fn on a single connection holding the migration advisory lock.
+// Session-level advisory locks belong to a connection, so the pool cannot be used.
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, lockKey); err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %w", err)
+	}
+	defer conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, lockKey)
+
+	if _, err := conn.ExecContext(ctx, createTableQuery); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	return fn(conn)
+}
+
+func appliedVersions(ctx context.Context, conn *sql.Conn) (map[int]string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, checksum FROM schema_migrations`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema_migrations: %w", err)
+	}
+	defer rows.Close()
+
+	versions := make(map[int]string)
+	for rows.Next() {
+		var version int
+		var checksum string
+		if err := rows.Scan(&version, &checksum); err != nil {
+			return nil, err
+		}
+		versions[version] = checksum
+	}
+	return versions, rows.Err()
+}
+
+func inTx(ctx context.Context, conn *sql.Conn, fn func(tx *sql.Tx) error) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// load reads the migrations in fsys, sorted by version
+func load(fsys fs.FS) ([]Migration, error) {
+	files, err := fs.Glob(fsys, "*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, file := range files {
+		base, down := strings.CutSuffix(strings.TrimSuffix(file, ".sql"), ".down")
+
+		prefix, name, ok := strings.Cut(base, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: file name must start with a version, e.g. 001_name.sql", file)
+		}
+
+		data, err := fs.ReadFile(fsys, file)
+		if err != nil {
+			return nil, err
+		}
+
+		mig, ok := byVersion[version]
+		if !ok {
+			mig = &Migration{Version: version, Name: name}
+			byVersion[version] = mig
+		}
+		if mig.Name != name {
+			return nil, fmt.Errorf("migration %s: version %d is also used by %s", file, version, mig.Name)
+		}
+		if down {
+			mig.Down = string(data)
+		} else {
+			mig.Up = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, mig := range byVersion {
+		if mig.Up == "" {
+			return nil, fmt.Errorf("migration %s has a down script but no up script", mig)
+		}
+		migrations = append(migrations, *mig)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
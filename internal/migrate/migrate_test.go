@@ -0,0 +1,208 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+var testFiles = fstest.MapFS{
+	"001_users.sql":      {Data: []byte("CREATE TABLE users (id INT);")},
+	"001_users.down.sql": {Data: []byte("DROP TABLE users;")},
+	"002_index.sql":      {Data: []byte("CREATE INDEX idx_users ON users (id);")},
+}
+
+func newMockMigrator(t *testing.T, fsys fstest.MapFS) (*Migrator, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	m, err := New(sqlx.NewDb(db, "postgres"), fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, mock
+}
+
+// expectLocked expects the lock, the schema_migrations setup and the applied
+// versions query of a run
+func expectLocked(mock sqlmock.Sqlmock, applied *sqlmock.Rows) {
+	mock.ExpectExec("SELECT pg_advisory_lock").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS schema_migrations").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery("SELECT version, checksum FROM schema_migrations").WillReturnRows(applied)
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec("SELECT pg_advisory_unlock").WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func checkExpectations(t *testing.T, mock sqlmock.Sqlmock) {
+	t.Helper()
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	migrations, err := load(testFiles)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(migrations) != 2 {
+		t.Fatalf("loaded %d migrations, want 2", len(migrations))
+	}
+	if migrations[0].String() != "001_users" || migrations[0].Down != "DROP TABLE users;" {
+		t.Errorf("first migration = %+v", migrations[0])
+	}
+	if migrations[1].String() != "002_index" || migrations[1].Down != "" {
+		t.Errorf("second migration = %+v", migrations[1])
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		files fstest.MapFS
+		want  string
+	}{
+		{"no version", fstest.MapFS{"users.sql": {}}, "must start with a version"},
+		{"zero version", fstest.MapFS{"000_users.sql": {}}, "must start with a version"},
+		{"down without up", fstest.MapFS{"001_users.down.sql": {Data: []byte("DROP TABLE users;")}}, "no up script"},
+		{"duplicate version", fstest.MapFS{
+			"001_users.sql":    {Data: []byte("SELECT 1;")},
+			"001_messages.sql": {Data: []byte("SELECT 2;")},
+		}, "also used by"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := load(tt.files)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("load = %v, want an error containing %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestUpAppliesPending(t *testing.T) {
+	m, mock := newMockMigrator(t, testFiles)
+	users, index := m.migrations[0], m.migrations[1]
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum"}).AddRow(1, users.Checksum()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(index.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("INSERT INTO schema_migrations").
+		WithArgs(2, "index", index.Checksum()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	applied, err := m.Up(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != 1 || applied[0].Version != 2 {
+		t.Fatalf("applied = %v, want 002_index", applied)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestUpChecksumMismatch(t *testing.T) {
+	m, mock := newMockMigrator(t, testFiles)
+
+	// 001 was applied from a script that has been edited since; nothing runs
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum"}).AddRow(1, "0123abcd"))
+	expectUnlock(mock)
+
+	applied, err := m.Up(context.Background())
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("Up = %v, want ErrChecksumMismatch", err)
+	}
+	if len(applied) != 0 {
+		t.Fatalf("applied = %v, want none", applied)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestUpRollsBackFailedMigration(t *testing.T) {
+	m, mock := newMockMigrator(t, testFiles)
+	users := m.migrations[0]
+
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum"}))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(users.Up)).WillReturnError(errors.New("syntax error"))
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	_, err := m.Up(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "applying 001_users") {
+		t.Fatalf("Up = %v, want the failed migration", err)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestDownWithoutScript(t *testing.T) {
+	m, mock := newMockMigrator(t, testFiles)
+	users, index := m.migrations[0], m.migrations[1]
+
+	// 002 is the newest applied migration and cannot be reverted, so 001 is
+	// left alone as well
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum"}).
+		AddRow(1, users.Checksum()).
+		AddRow(2, index.Checksum()))
+	expectUnlock(mock)
+
+	reverted, err := m.Down(context.Background(), 2)
+	if !errors.Is(err, ErrNoDownMigration) {
+		t.Fatalf("Down = %v, want ErrNoDownMigration", err)
+	}
+	if len(reverted) != 0 {
+		t.Fatalf("reverted = %v, want none", reverted)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestDownRevertsApplied(t *testing.T) {
+	m, mock := newMockMigrator(t, testFiles)
+	users := m.migrations[0]
+
+	// Only 001 is applied, so 002 is skipped even though it has no down script
+	expectLocked(mock, sqlmock.NewRows([]string{"version", "checksum"}).AddRow(1, users.Checksum()))
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(users.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("DELETE FROM schema_migrations").WithArgs(1).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	expectUnlock(mock)
+
+	reverted, err := m.Down(context.Background(), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reverted) != 1 || reverted[0].Version != 1 {
+		t.Fatalf("reverted = %v, want 001_users", reverted)
+	}
+	checkExpectations(t, mock)
+}
+
+func TestVersion(t *testing.T) {
+	m, mock := newMockMigrator(t, testFiles)
+	mock.ExpectQuery("SELECT version FROM schema_migrations").
+		WillReturnRows(sqlmock.NewRows([]string{"version"}).AddRow(1))
+
+	v, err := m.Version(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != (SchemaVersion{Current: 1, Latest: 2, Pending: 1}) {
+		t.Fatalf("Version = %+v", v)
+	}
+}
@@ -0,0 +1,50 @@
+package models
+
+// UserStatus filters the admin user list by account state
+type UserStatus string
+
+const (
+	UserStatusActive    UserStatus = "active"
+	UserStatusSuspended UserStatus = "suspended"
+	UserStatusBanned    UserStatus = "banned"
+	UserStatusDeleted   UserStatus = "deleted"
+)
+
+// UserFilter narrows the admin user list. Zero values match every user.
+type UserFilter struct {
+	Query  string // matched against username and email
+	Role   Role
+	Status UserStatus
+}
+
+type SuspendUserRequest struct {
+	Hours  int    `json:"hours" validate:"required,min=1,max=8760"`
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+type BanUserRequest struct {
+	Reason string `json:"reason" validate:"max=1000"`
+}
+
+type UpdateRoleRequest struct {
+	Role Role `json:"role" validate:"required,oneof=user moderator admin"`
+}
+
+// PlatformStats is the admin dashboard summary. Connection figures only cover
+// the node that served the request.
+type PlatformStats struct {
+	Users          int `db:"users" json:"users"`
+	ActiveUsers    int `db:"active_users" json:"active_users"`
+	SuspendedUsers int `db:"suspended_users" json:"suspended_users"`
+	BannedUsers    int `db:"banned_users" json:"banned_users"`
+	DeletedUsers   int `db:"deleted_users" json:"deleted_users"`
+	NewUsers24h    int `db:"new_users_24h" json:"new_users_24h"`
+
+	Messages       int `db:"messages" json:"messages"`
+	Messages24h    int `db:"messages_24h" json:"messages_24h"`
+	HeldMessages   int `db:"held_messages" json:"held_messages"`
+	OpenReports    int `db:"open_reports" json:"open_reports"`
+	ClaimedReports int `db:"claimed_reports" json:"claimed_reports"`
+
+	ConnectedUsers int `db:"-" json:"connected_users"`
+}
@@ -0,0 +1,46 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type AuditAction string
+
+const (
+	AuditActionLoginLockout AuditAction = "login_lockout"
+
+	// Admin actions on abuse reports
+	AuditActionReportClaimed  AuditAction = "report_claimed"
+	AuditActionReportResolved AuditAction = "report_resolved"
+	AuditActionUserWarned     AuditAction = "user_warned"
+	AuditActionUserSuspended  AuditAction = "user_suspended"
+	AuditActionMessageDeleted AuditAction = "message_deleted"
+
+	// Moderator actions on held messages
+	AuditActionMessageApproved AuditAction = "message_approved"
+	AuditActionMessageRejected AuditAction = "message_rejected"
+
+	// Admin actions on accounts
+	AuditActionUserReinstated  AuditAction = "user_reinstated"
+	AuditActionUserBanned      AuditAction = "user_banned"
+	AuditActionUserRoleChanged AuditAction = "user_role_changed"
+
+	// Operational actions run with chatctl
+	AuditActionUserCreated     AuditAction = "user_created"
+	AuditActionPasswordReset   AuditAction = "password_reset"
+	AuditActionSessionsRevoked AuditAction = "sessions_revoked"
+	AuditActionUserPurged      AuditAction = "user_purged"
+)
+
+type AuditEntry struct {
+	ID         int             `db:"id" json:"id"`
+	ActorID    sql.NullInt64   `db:"actor_id" json:"-"`
+	Action     AuditAction     `db:"action" json:"action"`
+	TargetType sql.NullString  `db:"target_type" json:"-"`
+	TargetID   sql.NullInt64   `db:"target_id" json:"-"`
+	IPAddress  sql.NullString  `db:"ip_address" json:"-"`
+	Metadata   json.RawMessage `db:"metadata" json:"metadata"`
+	CreatedAt  time.Time       `db:"created_at" json:"created_at"`
+}
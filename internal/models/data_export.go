@@ -0,0 +1,60 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type DataExportStatus string
+
+const (
+	DataExportStatusPending    DataExportStatus = "pending"
+	DataExportStatusProcessing DataExportStatus = "processing"
+	DataExportStatusReady      DataExportStatus = "ready"
+	DataExportStatusFailed     DataExportStatus = "failed"
+	DataExportStatusExpired    DataExportStatus = "expired"
+)
+
+type DataExport struct {
+	ID          int              `db:"id" json:"id"`
+	UserID      int              `db:"user_id" json:"-"`
+	Status      DataExportStatus `db:"status" json:"status"`
+	StorageKey  sql.NullString   `db:"storage_key" json:"-"`
+	Error       sql.NullString   `db:"error" json:"-"`
+	CreatedAt   time.Time        `db:"created_at" json:"created_at"`
+	CompletedAt sql.NullTime     `db:"completed_at" json:"-"`
+	ExpiresAt   sql.NullTime     `db:"expires_at" json:"-"`
+	StartedAt   sql.NullTime     `db:"started_at" json:"-"`
+	Attempts    int              `db:"attempts" json:"-"`
+}
+
+func (e DataExport) MarshalJSON() ([]byte, error) {
+	type Alias DataExport
+
+	var completedAt, expiresAt *time.Time
+	if e.CompletedAt.Valid {
+		completedAt = &e.CompletedAt.Time
+	}
+	if e.ExpiresAt.Valid {
+		expiresAt = &e.ExpiresAt.Time
+	}
+
+	var downloadURL string
+	if e.Status == DataExportStatusReady {
+		downloadURL = fmt.Sprintf("/api/users/me/exports/%d/download", e.ID)
+	}
+
+	return json.Marshal(&struct {
+		Alias
+		CompletedAt *time.Time `json:"completed_at"`
+		ExpiresAt   *time.Time `json:"expires_at"`
+		DownloadURL string     `json:"download_url,omitempty"`
+	}{
+		Alias:       Alias(e),
+		CompletedAt: completedAt,
+		ExpiresAt:   expiresAt,
+		DownloadURL: downloadURL,
+	})
+}
@@ -1,6 +1,7 @@
 package models
 
 import (
+	"database/sql"
 	"time"
 )
 
@@ -11,34 +12,138 @@ type Message struct {
 	Content     string    `db:"content" json:"content"`
 	IsRead      bool      `db:"is_read" json:"is_read"`
 	CreatedAt   time.Time `db:"created_at" json:"created_at"`
+
+	ModerationStatus ModerationStatus `db:"moderation_status" json:"moderation_status"`
+	ModerationReason sql.NullString   `db:"moderation_reason" json:"-"`
+}
+
+// ModerationStatus is the outcome of moderating a stored message. Rejected
+// messages are never stored.
+type ModerationStatus string
+
+const (
+	ModerationApproved ModerationStatus = "approved"
+	ModerationMasked   ModerationStatus = "masked"
+	ModerationHeld     ModerationStatus = "held"
+)
+
+// HeldMessage is a message in the moderator review queue
+type HeldMessage struct {
+	Message
+	Reason string `json:"moderation_reason"`
+}
+
+// ReviewHeldMessageRequest is the optional body of the approve and reject
+// actions on a held message
+type ReviewHeldMessageRequest struct {
+	Note string `json:"note"`
+}
+
+// SendMessageRequest is the body of POST /api/messages, the REST equivalent of
+// a chat frame for clients on the fallback transports
+type SendMessageRequest struct {
+	RecipientID int    `json:"recipient_id"`
+	Content     string `json:"content"`
+	// Locale selects the moderation word lists, e.g. "en"
+	Locale string `json:"locale,omitempty"`
 }
 
 type WSMessageType string
 
 const (
 	WSMessageTypeChat     WSMessageType = "chat"
-	WSMessageTypeTyping   WSMessageType = "typing"
+	WSMessageTypeTyping   WSMessageType = "typing" // legacy alias of typing_start
 	WSMessageTypeRead     WSMessageType = "read"
 	WSMessageTypeOnline   WSMessageType = "online"
 	WSMessageTypeOffline  WSMessageType = "offline"
 	WSMessageTypePresence WSMessageType = "presence"
+
+	WSMessageTypeTypingStart WSMessageType = "typing_start"
+	WSMessageTypeTypingStop  WSMessageType = "typing_stop"
+
+	// WSMessageTypePresenceSet is sent by a client to change its own presence status
+	WSMessageTypePresenceSet WSMessageType = "presence_set"
+	// WSMessageTypePresenceSubscribe is sent by a client with the full list of
+	// users whose presence it wants to receive
+	WSMessageTypePresenceSubscribe WSMessageType = "presence_subscribe"
+
+	// WSMessageTypeHello is the first frame on a versioned connection
+	WSMessageTypeHello WSMessageType = "hello"
+	// WSMessageTypeError reports a frame the server could not process
+	WSMessageTypeError WSMessageType = "error"
+
+	// WSMessageTypeReconnect asks the client to reconnect after RetryAfterMS
+	// because this server is shutting down
+	WSMessageTypeReconnect WSMessageType = "reconnect"
+
+	WSMessageTypeProfileUpdated WSMessageType = "profile_updated"
+	WSMessageTypeExportReady    WSMessageType = "export_ready"
+
+	// WSMessageTypeWarning tells a user an admin warned them about a report
+	WSMessageTypeWarning WSMessageType = "warning"
+
+	// WSMessageTypeMessageRejected tells the sender a moderator rejected one of
+	// their held messages. Approved messages are sent again as chat frames.
+	WSMessageTypeMessageRejected WSMessageType = "message_rejected"
+)
+
+// WSErrorCode is the machine readable reason carried by an error frame
+type WSErrorCode string
+
+const (
+	WSErrorBadJSON        WSErrorCode = "bad_json"
+	WSErrorBadFrame       WSErrorCode = "bad_frame"
+	WSErrorUnknownType    WSErrorCode = "unknown_type"
+	WSErrorInvalidPayload WSErrorCode = "invalid_payload"
+	WSErrorRateLimited    WSErrorCode = "rate_limited"
+
+	// Moderation verdicts for a chat frame. A masked message was delivered
+	// with blocked words replaced; a held one waits for review.
+	WSErrorMessageRejected WSErrorCode = "message_rejected"
+	WSErrorMessageHeld     WSErrorCode = "message_held"
+	WSErrorMessageMasked   WSErrorCode = "message_masked"
+	WSErrorInternal        WSErrorCode = "internal_error"
 )
 
 type WSMessage struct {
-	Type      WSMessageType `json:"type"`
-	Content   string        `json:"content,omitempty"`
-	Recipient int           `json:"recipient,omitempty"`
-	MessageID int           `json:"message_id,omitempty"`
-	Timestamp time.Time     `json:"timestamp"`
+	Type      WSMessageType  `json:"type"`
+	RequestID string         `json:"request_id,omitempty"`
+	Content   string         `json:"content,omitempty"`
+	Recipient int            `json:"recipient,omitempty"`
+	MessageID int            `json:"message_id,omitempty"`
+	Status    PresenceStatus `json:"status,omitempty"`
+	UserIDs   []int          `json:"user_ids,omitempty"`
+	Locale    string         `json:"locale,omitempty"`
+	Timestamp time.Time      `json:"timestamp"`
 }
 
 type WSOutgoingMessage struct {
-	Type        WSMessageType `json:"type"`
-	Message     *Message      `json:"message,omitempty"`
-	MessageID   int           `json:"message_id,omitempty"`
-	SenderID    int           `json:"sender_id,omitempty"`
-	OnlineUsers []int         `json:"online_users,omitempty"`
-	Timestamp   time.Time     `json:"timestamp"`
+	Type        WSMessageType          `json:"type"`
+	Message     *Message               `json:"message,omitempty"`
+	MessageID   int                    `json:"message_id,omitempty"`
+	SenderID    int                    `json:"sender_id,omitempty"`
+	RecipientID int                    `json:"recipient_id,omitempty"`
+	Status      PresenceStatus         `json:"status,omitempty"`
+	OnlineUsers []int                  `json:"online_users,omitempty"`
+	Statuses    map[int]PresenceStatus `json:"statuses,omitempty"`
+	User        *User                  `json:"user,omitempty"`
+	Export      *DataExport            `json:"export,omitempty"`
+	Reason      string                 `json:"reason,omitempty"`
+
+	// Set on hello frames
+	Protocol      string   `json:"protocol,omitempty"`
+	ServerVersion string   `json:"server_version,omitempty"`
+	Capabilities  []string `json:"capabilities,omitempty"`
+
+	// Set on error frames
+	RequestID string      `json:"request_id,omitempty"`
+	Code      WSErrorCode `json:"code,omitempty"`
+	Error     string      `json:"error,omitempty"`
+
+	// Set on reconnect frames and rate_limited error frames
+	RetryAfterMS int64 `json:"retry_after_ms,omitempty"`
+
+	Timestamp time.Time `json:"timestamp"`
 }
 
 type ConversationPreview struct {
@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+type PresenceStatus string
+
+const (
+	PresenceOnline  PresenceStatus = "online"
+	PresenceAway    PresenceStatus = "away"
+	PresenceBusy    PresenceStatus = "busy"
+	PresenceOffline PresenceStatus = "offline"
+
+	// PresenceInvisible is connected but shown as offline to everyone else
+	PresenceInvisible PresenceStatus = "invisible"
+)
+
+// IsSettable reports whether a client may choose this status for itself
+func (s PresenceStatus) IsSettable() bool {
+	switch s {
+	case PresenceOnline, PresenceAway, PresenceBusy, PresenceInvisible:
+		return true
+	}
+	return false
+}
+
+// Who may see a user's last-seen timestamp
+const (
+	LastSeenEveryone = "everyone"
+	LastSeenContacts = "contacts"
+	LastSeenNobody   = "nobody"
+)
+
+type PresenceInfo struct {
+	UserID     int            `json:"user_id"`
+	Status     PresenceStatus `json:"status"`
+	LastSeenAt *time.Time     `json:"last_seen_at,omitempty"`
+}
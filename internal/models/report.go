@@ -0,0 +1,117 @@
+package models
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+type ReportTargetType string
+
+const (
+	ReportTargetMessage ReportTargetType = "message"
+	ReportTargetUser    ReportTargetType = "user"
+)
+
+type ReportStatus string
+
+const (
+	// ReportStatusOpen is waiting for an admin to claim it
+	ReportStatusOpen     ReportStatus = "open"
+	ReportStatusClaimed  ReportStatus = "claimed"
+	ReportStatusResolved ReportStatus = "resolved"
+)
+
+// ReportAction is what the admin did when resolving a report
+type ReportAction string
+
+const (
+	ReportActionDismiss ReportAction = "dismiss"
+	ReportActionWarn    ReportAction = "warn"
+	ReportActionSuspend ReportAction = "suspend"
+	// ReportActionDeleteContent deletes the reported message
+	ReportActionDeleteContent ReportAction = "delete_content"
+)
+
+type Report struct {
+	ID             int              `db:"id" json:"id"`
+	ReporterID     sql.NullInt64    `db:"reporter_id" json:"-"`
+	TargetType     ReportTargetType `db:"target_type" json:"target_type"`
+	ReportedUserID int              `db:"reported_user_id" json:"reported_user_id"`
+	MessageID      sql.NullInt64    `db:"message_id" json:"-"`
+	MessageContent sql.NullString   `db:"message_content" json:"-"`
+	Reason         string           `db:"reason" json:"reason"`
+	Details        sql.NullString   `db:"details" json:"-"`
+	Status         ReportStatus     `db:"status" json:"status"`
+	AssigneeID     sql.NullInt64    `db:"assignee_id" json:"-"`
+	Action         sql.NullString   `db:"action" json:"-"`
+	ResolutionNote sql.NullString   `db:"resolution_note" json:"-"`
+	CreatedAt      time.Time        `db:"created_at" json:"created_at"`
+	ClaimedAt      sql.NullTime     `db:"claimed_at" json:"-"`
+	ResolvedAt     sql.NullTime     `db:"resolved_at" json:"-"`
+}
+
+func (r Report) MarshalJSON() ([]byte, error) {
+	type Alias Report
+
+	return json.Marshal(&struct {
+		Alias
+		ReporterID     *int64     `json:"reporter_id"`
+		MessageID      *int64     `json:"message_id,omitempty"`
+		MessageContent *string    `json:"message_content,omitempty"`
+		Details        *string    `json:"details"`
+		AssigneeID     *int64     `json:"assignee_id"`
+		Action         *string    `json:"action"`
+		ResolutionNote *string    `json:"resolution_note"`
+		ClaimedAt      *time.Time `json:"claimed_at"`
+		ResolvedAt     *time.Time `json:"resolved_at"`
+	}{
+		Alias:          Alias(r),
+		ReporterID:     nullInt64Ptr(r.ReporterID),
+		MessageID:      nullInt64Ptr(r.MessageID),
+		MessageContent: nullStringPtr(r.MessageContent),
+		Details:        nullStringPtr(r.Details),
+		AssigneeID:     nullInt64Ptr(r.AssigneeID),
+		Action:         nullStringPtr(r.Action),
+		ResolutionNote: nullStringPtr(r.ResolutionNote),
+		ClaimedAt:      nullTimePtr(r.ClaimedAt),
+		ResolvedAt:     nullTimePtr(r.ResolvedAt),
+	})
+}
+
+// CreateReportRequest reports either a message or a user: exactly one of
+// MessageID and UserID is set
+type CreateReportRequest struct {
+	MessageID *int   `json:"message_id"`
+	UserID    *int   `json:"user_id"`
+	Reason    string `json:"reason" validate:"required,oneof=spam harassment hate sexual violence impersonation other"`
+	Details   string `json:"details" validate:"max=1000"`
+}
+
+type ResolveReportRequest struct {
+	Action ReportAction `json:"action" validate:"required,oneof=dismiss warn suspend delete_content"`
+	Note   string       `json:"note" validate:"max=1000"`
+	// SuspendHours is the length of a suspension, required for the suspend action
+	SuspendHours int `json:"suspend_hours" validate:"omitempty,min=1,max=8760"`
+}
+
+func nullInt64Ptr(v sql.NullInt64) *int64 {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Int64
+}
+
+func nullStringPtr(v sql.NullString) *string {
+	if !v.Valid {
+		return nil
+	}
+	return &v.String
+}
+
+func nullTimePtr(v sql.NullTime) *time.Time {
+	if !v.Valid {
+		return nil
+	}
+	return &v.Time
+}
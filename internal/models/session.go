@@ -0,0 +1,27 @@
+package models
+
+import (
+	"database/sql"
+	"time"
+)
+
+type Session struct {
+	ID        string         `db:"id" json:"id"`
+	UserID    int            `db:"user_id" json:"user_id"`
+	UserAgent sql.NullString `db:"user_agent" json:"-"`
+	IPAddress sql.NullString `db:"ip_address" json:"-"`
+	CreatedAt time.Time      `db:"created_at" json:"created_at"`
+	ExpiresAt time.Time      `db:"expires_at" json:"expires_at"`
+	RevokedAt sql.NullTime   `db:"revoked_at" json:"-"`
+}
+
+// IsActive reports whether the session can still be used to authenticate
+func (s *Session) IsActive() bool {
+	return !s.RevokedAt.Valid && time.Now().Before(s.ExpiresAt)
+}
+
+// ClientInfo describes the client a session is issued to
+type ClientInfo struct {
+	UserAgent string
+	IPAddress string
+}
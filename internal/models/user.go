@@ -3,18 +3,63 @@ package models
 import (
 	"database/sql"
 	"encoding/json"
+	"strings"
 	"time"
 )
 
+// Role grants access to the moderation and admin APIs. Each role includes the
+// permissions of the ones before it.
+type Role string
+
+const (
+	RoleUser      Role = "user"
+	RoleModerator Role = "moderator"
+	RoleAdmin     Role = "admin"
+)
+
+var roleRanks = map[Role]int{RoleUser: 1, RoleModerator: 2, RoleAdmin: 3}
+
+// Valid reports whether r is a known role
+func (r Role) Valid() bool {
+	return roleRanks[r] > 0
+}
+
+// Includes reports whether r has at least the permissions of other
+func (r Role) Includes(other Role) bool {
+	return r.Valid() && roleRanks[r] >= roleRanks[other]
+}
+
 type User struct {
-	ID           int            `db:"id" json:"id"`
-	Username     string         `db:"username" json:"username"`
-	Email        string         `db:"email" json:"email"`
-	PasswordHash string         `db:"password_hash" json:"-"`
-	DisplayName  sql.NullString `db:"display_name" json:"-"`
-	AvatarURL    sql.NullString `db:"avatar_url" json:"-"`
-	CreatedAt    time.Time      `db:"created_at" json:"created_at"`
-	UpdatedAt    time.Time      `db:"updated_at" json:"updated_at"`
+	ID              int            `db:"id" json:"id"`
+	Username        string         `db:"username" json:"username"`
+	Email           string         `db:"email" json:"email"`
+	PasswordHash    string         `db:"password_hash" json:"-"`
+	DisplayName     sql.NullString `db:"display_name" json:"-"`
+	AvatarURL       sql.NullString `db:"avatar_url" json:"-"`
+	EmailVerifiedAt sql.NullTime   `db:"email_verified_at" json:"-"`
+	Bio             sql.NullString `db:"bio" json:"-"`
+	StatusText      sql.NullString `db:"status_text" json:"-"`
+	DeletedAt       sql.NullTime   `db:"deleted_at" json:"-"`
+	LastSeenAt      sql.NullTime   `db:"last_seen_at" json:"-"`
+	SuspendedUntil  sql.NullTime   `db:"suspended_until" json:"-"`
+	BannedAt        sql.NullTime   `db:"banned_at" json:"-"`
+
+	// RestrictionReason explains the current suspension or ban
+	RestrictionReason  sql.NullString `db:"restriction_reason" json:"-"`
+	Role               Role           `db:"role" json:"role"`
+	LastSeenVisibility string         `db:"last_seen_visibility" json:"last_seen_visibility"`
+	CreatedAt          time.Time      `db:"created_at" json:"created_at"`
+	UpdatedAt          time.Time      `db:"updated_at" json:"updated_at"`
+}
+
+// IsSuspended reports whether the user is temporarily barred from logging in
+func (u *User) IsSuspended() bool {
+	return u.SuspendedUntil.Valid && time.Now().Before(u.SuspendedUntil.Time)
+}
+
+// IsBanned reports whether the user is permanently barred from logging in
+func (u *User) IsBanned() bool {
+	return u.BannedAt.Valid
 }
 
 func (u User) MarshalJSON() ([]byte, error) {
@@ -30,14 +75,30 @@ func (u User) MarshalJSON() ([]byte, error) {
 		avatarURL = &u.AvatarURL.String
 	}
 
+	var bio *string
+	if u.Bio.Valid {
+		bio = &u.Bio.String
+	}
+
+	var statusText *string
+	if u.StatusText.Valid {
+		statusText = &u.StatusText.String
+	}
+
 	return json.Marshal(&struct {
 		*Alias
-		DisplayName *string `json:"display_name"`
-		AvatarURL   *string `json:"avatar_url"`
+		DisplayName   *string `json:"display_name"`
+		AvatarURL     *string `json:"avatar_url"`
+		Bio           *string `json:"bio"`
+		StatusText    *string `json:"status_text"`
+		EmailVerified bool    `json:"email_verified"`
 	}{
-		Alias:       (*Alias)(&u),
-		DisplayName: displayName,
-		AvatarURL:   avatarURL,
+		Alias:         (*Alias)(&u),
+		DisplayName:   displayName,
+		AvatarURL:     avatarURL,
+		Bio:           bio,
+		StatusText:    statusText,
+		EmailVerified: u.EmailVerifiedAt.Valid,
 	})
 }
 
@@ -57,3 +118,46 @@ type AuthResponse struct {
 	Token string `json:"token"`
 	User  User   `json:"user"`
 }
+
+// UpdateProfileRequest is a partial update: nil fields are left unchanged and an
+// empty string clears an optional field. Call TrimSpace before validating.
+type UpdateProfileRequest struct {
+	Username    *string `json:"username" validate:"omitnil,min=2,max=50"`
+	DisplayName *string `json:"display_name" validate:"omitempty,max=100"`
+	Bio         *string `json:"bio" validate:"omitempty,max=500"`
+	StatusText  *string `json:"status_text" validate:"omitempty,max=140"`
+
+	LastSeenVisibility *string `json:"last_seen_visibility" validate:"omitempty,oneof=everyone contacts nobody"`
+}
+
+// TrimSpace trims the text fields in place so that lengths are validated on
+// what is stored and a blank username is rejected rather than accepted as empty
+func (r *UpdateProfileRequest) TrimSpace() {
+	for _, field := range []*string{r.Username, r.DisplayName, r.Bio, r.StatusText} {
+		if field != nil {
+			*field = strings.TrimSpace(*field)
+		}
+	}
+}
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" validate:"required"`
+	NewPassword     string `json:"new_password" validate:"required,min=6"`
+}
+
+type DeleteAccountRequest struct {
+	Password string `json:"password" validate:"required"`
+}
+
+type VerifyEmailRequest struct {
+	Token string `json:"token" validate:"required"`
+}
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" validate:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" validate:"required"`
+	Password string `json:"password" validate:"required,min=6"`
+}
@@ -0,0 +1,56 @@
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"unicode/utf8"
+)
+
+// MaxLength rejects messages longer than Max characters
+type MaxLength struct {
+	Max int
+}
+
+func (m MaxLength) Moderate(_ context.Context, msg Message) (Verdict, error) {
+	if m.Max > 0 && utf8.RuneCountInString(msg.Content) > m.Max {
+		return Verdict{Action: Reject, Reason: fmt.Sprintf("message is longer than %d characters", m.Max)}, nil
+	}
+	return Verdict{Action: Allow}, nil
+}
+
+var linkPattern = regexp.MustCompile(`(?i)\b(?:https?://|www\.)\S+`)
+
+// Spam holds messages that look like spam: too many links, or a single
+// character repeated over and over
+type Spam struct {
+	MaxLinks         int
+	MaxRepeatedChars int
+}
+
+func (s Spam) Moderate(_ context.Context, msg Message) (Verdict, error) {
+	if s.MaxLinks > 0 && len(linkPattern.FindAllStringIndex(msg.Content, s.MaxLinks+1)) > s.MaxLinks {
+		return Verdict{Action: Hold, Reason: fmt.Sprintf("more than %d links", s.MaxLinks)}, nil
+	}
+
+	if s.MaxRepeatedChars > 0 && longestRun(msg.Content) > s.MaxRepeatedChars {
+		return Verdict{Action: Hold, Reason: "repeated characters"}, nil
+	}
+
+	return Verdict{Action: Allow}, nil
+}
+
+// longestRun returns the length of the longest run of one repeated rune
+func longestRun(s string) int {
+	longest, run := 0, 0
+	var prev rune = -1
+	for _, r := range s {
+		if r == prev {
+			run++
+		} else {
+			prev, run = r, 1
+		}
+		longest = max(longest, run)
+	}
+	return longest
+}
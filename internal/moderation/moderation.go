@@ -0,0 +1,100 @@
+// Package moderation checks chat messages before they are stored. Moderators
+// are combined in a Chain; each one can allow, mask, hold or reject a message.
+package moderation
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Action is what happens to a message, ordered by severity
+type Action int
+
+const (
+	// Allow delivers the message unchanged
+	Allow Action = iota
+	// Mask delivers the message with the offending parts replaced
+	Mask
+	// Hold stores the message for review without delivering it
+	Hold
+	// Reject refuses the message; it is not stored
+	Reject
+)
+
+func (a Action) String() string {
+	switch a {
+	case Mask:
+		return "mask"
+	case Hold:
+		return "hold"
+	case Reject:
+		return "reject"
+	}
+	return "allow"
+}
+
+// ParseAction parses the name returned by Action.String
+func ParseAction(s string) (Action, error) {
+	for _, a := range []Action{Allow, Mask, Hold, Reject} {
+		if a.String() == s {
+			return a, nil
+		}
+	}
+	return Allow, fmt.Errorf("unknown moderation action %q", s)
+}
+
+// Message is the message being moderated
+type Message struct {
+	SenderID    int
+	RecipientID int
+	Content     string
+	// Locale selects locale-specific word lists, e.g. "en" or "pt-BR". It may be empty.
+	Locale string
+}
+
+// Verdict is the decision of a moderator
+type Verdict struct {
+	Action Action
+	// Content is the masked content for Mask verdicts
+	Content string
+	// Reason is shown to the sender
+	Reason string
+}
+
+type Moderator interface {
+	Moderate(ctx context.Context, msg Message) (Verdict, error)
+}
+
+// Chain runs moderators in order. Masks are applied before the next moderator
+// runs, a rejection stops the chain, and the most severe verdict wins. A
+// moderator that fails is skipped so an outage never blocks chat.
+type Chain []Moderator
+
+func (c Chain) Moderate(ctx context.Context, msg Message) (Verdict, error) {
+	result := Verdict{Action: Allow, Content: msg.Content}
+
+	for _, m := range c {
+		v, err := m.Moderate(ctx, msg)
+		if err != nil {
+			slog.ErrorContext(ctx, "moderation: moderator failed", "moderator", fmt.Sprintf("%T", m), "error", err)
+			continue
+		}
+
+		switch v.Action {
+		case Reject:
+			v.Content = msg.Content
+			return v, nil
+		case Mask:
+			msg.Content = v.Content
+			result.Content = v.Content
+		}
+
+		if v.Action > result.Action {
+			result.Action = v.Action
+			result.Reason = v.Reason
+		}
+	}
+
+	return result, nil
+}
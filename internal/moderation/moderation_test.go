@@ -0,0 +1,178 @@
+package moderation
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// fixed returns the same verdict for every message and records what it saw
+type fixed struct {
+	verdict Verdict
+	err     error
+	seen    *string
+}
+
+func (f fixed) Moderate(_ context.Context, msg Message) (Verdict, error) {
+	if f.seen != nil {
+		*f.seen = msg.Content
+	}
+	return f.verdict, f.err
+}
+
+func TestChainVerdicts(t *testing.T) {
+	allow := fixed{verdict: Verdict{Action: Allow}}
+	mask := fixed{verdict: Verdict{Action: Mask, Content: "h***o", Reason: "blocked words"}}
+	hold := fixed{verdict: Verdict{Action: Hold, Reason: "spam"}}
+	reject := fixed{verdict: Verdict{Action: Reject, Reason: "too long"}}
+	failing := fixed{verdict: Verdict{Action: Reject}, err: errors.New("down")}
+
+	tests := []struct {
+		name  string
+		chain Chain
+		want  Verdict
+	}{
+		{"empty", Chain{}, Verdict{Action: Allow, Content: "hello"}},
+		{"allow", Chain{allow, allow}, Verdict{Action: Allow, Content: "hello"}},
+		{"mask", Chain{allow, mask}, Verdict{Action: Mask, Content: "h***o", Reason: "blocked words"}},
+		{"hold beats mask", Chain{mask, hold}, Verdict{Action: Hold, Content: "h***o", Reason: "spam"}},
+		{"mask after hold keeps the hold", Chain{hold, mask}, Verdict{Action: Hold, Content: "h***o", Reason: "spam"}},
+		{"reject stops the chain", Chain{mask, reject, hold}, Verdict{Action: Reject, Content: "h***o", Reason: "too long"}},
+		{"failing moderator is skipped", Chain{failing, allow}, Verdict{Action: Allow, Content: "hello"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.chain.Moderate(context.Background(), Message{Content: "hello"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("Moderate = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestChainMasksBeforeNextModerator(t *testing.T) {
+	var seen string
+	chain := Chain{
+		fixed{verdict: Verdict{Action: Mask, Content: "h***o"}},
+		fixed{verdict: Verdict{Action: Allow}, seen: &seen},
+	}
+	chain.Moderate(context.Background(), Message{Content: "hello"})
+	if seen != "h***o" {
+		t.Fatalf("next moderator saw %q, want the masked content", seen)
+	}
+}
+
+func TestChainStopsAtReject(t *testing.T) {
+	var seen string
+	chain := Chain{
+		fixed{verdict: Verdict{Action: Reject}},
+		fixed{verdict: Verdict{Action: Allow}, seen: &seen},
+	}
+	chain.Moderate(context.Background(), Message{Content: "hello"})
+	if seen != "" {
+		t.Fatal("moderator after a rejection ran")
+	}
+}
+
+func TestParseAction(t *testing.T) {
+	for _, a := range []Action{Allow, Mask, Hold, Reject} {
+		got, err := ParseAction(a.String())
+		if err != nil || got != a {
+			t.Errorf("ParseAction(%q) = %v, %v", a.String(), got, err)
+		}
+	}
+	if _, err := ParseAction("ban"); err == nil {
+		t.Error("ParseAction(\"ban\") succeeded")
+	}
+}
+
+func TestWordList(t *testing.T) {
+	w := NewWordList(map[string][]string{
+		DefaultLocale: {"darn"},
+		"pt":          {"Caramba"},
+	}, Mask)
+
+	tests := []struct {
+		content, locale string
+		want            Verdict
+	}{
+		{"hello there", "", Verdict{Action: Allow}},
+		{"well DARN it", "", Verdict{Action: Mask, Content: "well **** it", Reason: "blocked words"}},
+		{"darnit is one word", "", Verdict{Action: Allow}},
+		{"caramba", "en", Verdict{Action: Allow}},
+		{"caramba!", "pt-BR", Verdict{Action: Mask, Content: "*******!", Reason: "blocked words"}},
+		{"darn, caramba", "pt", Verdict{Action: Mask, Content: "****, *******", Reason: "blocked words"}},
+	}
+	for _, tt := range tests {
+		got, _ := w.Moderate(context.Background(), Message{Content: tt.content, Locale: tt.locale})
+		if got != tt.want {
+			t.Errorf("Moderate(%q, %q) = %+v, want %+v", tt.content, tt.locale, got, tt.want)
+		}
+	}
+}
+
+func TestMaxLength(t *testing.T) {
+	m := MaxLength{Max: 5}
+	if v, _ := m.Moderate(context.Background(), Message{Content: "héllo"}); v.Action != Allow {
+		t.Errorf("5 characters = %v, want allow", v.Action)
+	}
+	if v, _ := m.Moderate(context.Background(), Message{Content: "héllo!"}); v.Action != Reject {
+		t.Errorf("6 characters = %v, want reject", v.Action)
+	}
+}
+
+func TestSpam(t *testing.T) {
+	s := Spam{MaxLinks: 2, MaxRepeatedChars: 4}
+	tests := []struct {
+		content string
+		want    Action
+	}{
+		{"see https://a.example and www.b.example", Allow},
+		{"https://a.example http://b.example www.c.example", Hold},
+		{"nooo", Allow},
+		{"nooooo", Hold},
+	}
+	for _, tt := range tests {
+		if v, _ := s.Moderate(context.Background(), Message{Content: tt.content}); v.Action != tt.want {
+			t.Errorf("Moderate(%q) = %v, want %v", tt.content, v.Action, tt.want)
+		}
+	}
+}
+
+func TestRepeat(t *testing.T) {
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	r := NewRepeat(client, 2, time.Minute)
+	ctx := context.Background()
+	send := func(senderID int, content string) Action {
+		v, err := r.Moderate(ctx, Message{SenderID: senderID, Content: content})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v.Action
+	}
+
+	if send(1, "buy now") != Allow || send(1, "Buy   NOW") != Allow {
+		t.Fatal("messages within the limit rejected")
+	}
+	if send(1, "buy now") != Reject {
+		t.Fatal("repeat over the limit allowed")
+	}
+	if send(2, "buy now") != Allow {
+		t.Fatal("another sender's repeats counted")
+	}
+
+	mr.FastForward(time.Minute)
+	if send(1, "buy now") != Allow {
+		t.Fatal("repeat allowed again after the window")
+	}
+}
@@ -0,0 +1,52 @@
+package moderation
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Repeat rejects a message once the sender has sent the same text more than
+// Limit times within Window, to any recipient. Counts live in Redis so they
+// apply across nodes.
+type Repeat struct {
+	redis  *redis.Client
+	limit  int
+	window time.Duration
+}
+
+func NewRepeat(client *redis.Client, limit int, window time.Duration) *Repeat {
+	return &Repeat{redis: client, limit: limit, window: window}
+}
+
+func (r *Repeat) Moderate(ctx context.Context, msg Message) (Verdict, error) {
+	if r.limit <= 0 {
+		return Verdict{Action: Allow}, nil
+	}
+
+	key := repeatKey(msg.SenderID, msg.Content)
+	pipe := r.redis.TxPipeline()
+	count := pipe.Incr(ctx, key)
+	pipe.ExpireNX(ctx, key, r.window)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return Verdict{}, fmt.Errorf("counting repeats: %w", err)
+	}
+
+	if count.Val() > int64(r.limit) {
+		return Verdict{Action: Reject, Reason: "the same message was sent too many times"}, nil
+	}
+	return Verdict{Action: Allow}, nil
+}
+
+// repeatKey identifies a message text regardless of case and spacing
+func repeatKey(senderID int, content string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(content)), " ")
+	sum := sha256.Sum256([]byte(normalized))
+	return "moderation:repeat:" + strconv.Itoa(senderID) + ":" + hex.EncodeToString(sum[:12])
+}
@@ -0,0 +1,136 @@
+package moderation
+
+import (
+	"bufio"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+)
+
+// DefaultLocale is the list applied to every message whatever its locale
+const DefaultLocale = "default"
+
+// WordList applies Action to messages containing listed words. Words are
+// matched case-insensitively as whole words. Lists are keyed by lower-case
+// locale; a message in "pt-br" is checked against "pt-br", "pt" and
+// DefaultLocale.
+type WordList struct {
+	action Action
+	lists  map[string]map[string]struct{}
+}
+
+func NewWordList(lists map[string][]string, action Action) *WordList {
+	w := &WordList{
+		action: action,
+		lists:  make(map[string]map[string]struct{}, len(lists)),
+	}
+	for locale, words := range lists {
+		set := make(map[string]struct{}, len(words))
+		for _, word := range words {
+			set[strings.ToLower(word)] = struct{}{}
+		}
+		w.lists[strings.ToLower(locale)] = set
+	}
+	return w
+}
+
+// LoadWordLists reads one list per <locale>.txt file in dir, one word per
+// line. Blank lines and lines starting with # are ignored.
+func LoadWordLists(dir string) (map[string][]string, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	lists := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		words, err := readWords(path)
+		if err != nil {
+			return nil, err
+		}
+		locale := strings.TrimSuffix(filepath.Base(path), ".txt")
+		lists[locale] = words
+	}
+	return lists, nil
+}
+
+func readWords(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		words = append(words, line)
+	}
+	return words, scanner.Err()
+}
+
+func (w *WordList) Moderate(_ context.Context, msg Message) (Verdict, error) {
+	lists := w.listsFor(msg.Locale)
+	if len(lists) == 0 {
+		return Verdict{Action: Allow}, nil
+	}
+
+	content := []rune(msg.Content)
+	found := false
+	for start := 0; start < len(content); {
+		if !isWordRune(content[start]) {
+			start++
+			continue
+		}
+		end := start
+		for end < len(content) && isWordRune(content[end]) {
+			end++
+		}
+
+		word := strings.ToLower(string(content[start:end]))
+		for _, list := range lists {
+			if _, ok := list[word]; ok {
+				found = true
+				for i := start; i < end; i++ {
+					content[i] = '*'
+				}
+				break
+			}
+		}
+		start = end
+	}
+
+	if !found {
+		return Verdict{Action: Allow}, nil
+	}
+	return Verdict{Action: w.action, Content: string(content), Reason: "blocked words"}, nil
+}
+
+func (w *WordList) listsFor(locale string) []map[string]struct{} {
+	locale = strings.ToLower(locale)
+	candidates := []string{DefaultLocale}
+	if locale != "" {
+		candidates = append(candidates, locale)
+		if lang, _, ok := strings.Cut(locale, "-"); ok {
+			candidates = append(candidates, lang)
+		}
+	}
+
+	var lists []map[string]struct{}
+	for _, c := range candidates {
+		if list, ok := w.lists[c]; ok {
+			lists = append(lists, list)
+		}
+	}
+	return lists
+}
+
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '\''
+}
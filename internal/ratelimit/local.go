@@ -0,0 +1,85 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// How often idle buckets are dropped from a Local limiter
+const localSweepPeriod = time.Minute
+
+// Local keeps buckets in process memory. Use it for limits that only make
+// sense on one node, such as per connection.
+type Local struct {
+	mu        sync.Mutex
+	buckets   map[string]*localBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type localBucket struct {
+	tokens float64
+	last   time.Time
+	rule   Rule
+}
+
+func NewLocal() *Local {
+	return &Local{
+		buckets:   make(map[string]*localBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+func (l *Local) Allow(_ context.Context, key string, rule Rule) (Result, error) {
+	if rule.Disabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	now := l.now()
+	rate := rule.perMillisecond()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.sweepLocked(now)
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &localBucket{tokens: float64(rule.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.rule = rule
+	b.tokens = math.Min(float64(rule.Burst), b.tokens+millis(now.Sub(b.last))*rate)
+	b.last = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return Result{Allowed: true}, nil
+	}
+
+	wait := math.Ceil((1 - b.tokens) / rate)
+	return Result{Allowed: false, RetryAfter: time.Duration(wait) * time.Millisecond}, nil
+}
+
+// sweepLocked drops buckets that have refilled completely, which behave
+// exactly like a missing bucket. Must be called with l.mu held.
+func (l *Local) sweepLocked(now time.Time) {
+	if now.Sub(l.lastSweep) < localSweepPeriod {
+		return
+	}
+	l.lastSweep = now
+
+	for key, b := range l.buckets {
+		refilled := b.tokens + millis(now.Sub(b.last))*b.rule.perMillisecond()
+		if refilled >= float64(b.rule.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
@@ -0,0 +1,94 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func newTestLocal() (*Local, *time.Time) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	l := NewLocal()
+	l.lastSweep = now
+	l.now = func() time.Time { return now }
+	return l, &now
+}
+
+func TestLocalBurstAndRefill(t *testing.T) {
+	l, now := newTestLocal()
+	ctx := context.Background()
+	rule := Rule{Limit: 60, Period: time.Minute, Burst: 3}
+
+	for i := 0; i < rule.Burst; i++ {
+		if res, _ := l.Allow(ctx, "k", rule); !res.Allowed {
+			t.Fatalf("event %d of the burst denied", i+1)
+		}
+	}
+
+	res, _ := l.Allow(ctx, "k", rule)
+	if res.Allowed {
+		t.Fatal("event over the burst allowed")
+	}
+	if res.RetryAfter != time.Second {
+		t.Fatalf("RetryAfter = %s, want 1s", res.RetryAfter)
+	}
+
+	// Other keys have their own bucket
+	if res, _ := l.Allow(ctx, "other", rule); !res.Allowed {
+		t.Fatal("other key denied")
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	res, _ = l.Allow(ctx, "k", rule)
+	if res.Allowed || res.RetryAfter != 500*time.Millisecond {
+		t.Fatalf("half a token later = %+v, want denied with 500ms", res)
+	}
+
+	*now = now.Add(500 * time.Millisecond)
+	if res, _ := l.Allow(ctx, "k", rule); !res.Allowed {
+		t.Fatal("event after refill denied")
+	}
+
+	// The bucket never holds more than the burst
+	*now = now.Add(time.Hour)
+	for i := 0; i < rule.Burst; i++ {
+		if res, _ := l.Allow(ctx, "k", rule); !res.Allowed {
+			t.Fatalf("event %d after idling denied", i+1)
+		}
+	}
+	if res, _ := l.Allow(ctx, "k", rule); res.Allowed {
+		t.Fatal("idle bucket refilled past the burst")
+	}
+}
+
+func TestLocalDisabledRule(t *testing.T) {
+	l, _ := newTestLocal()
+	for i := 0; i < 100; i++ {
+		if res, _ := l.Allow(context.Background(), "k", Rule{}); !res.Allowed {
+			t.Fatal("disabled rule denied an event")
+		}
+	}
+	if len(l.buckets) != 0 {
+		t.Fatalf("disabled rule created %d buckets", len(l.buckets))
+	}
+}
+
+func TestLocalSweep(t *testing.T) {
+	l, now := newTestLocal()
+	ctx := context.Background()
+	slow := Rule{Limit: 1, Period: time.Hour, Burst: 1}
+	fast := Rule{Limit: 10, Period: time.Second, Burst: 1}
+
+	l.Allow(ctx, "slow", slow)
+	l.Allow(ctx, "fast", fast)
+
+	*now = now.Add(localSweepPeriod)
+	l.Allow(ctx, "new", fast)
+
+	if _, ok := l.buckets["fast"]; ok {
+		t.Error("refilled bucket was not swept")
+	}
+	if _, ok := l.buckets["slow"]; !ok {
+		t.Error("bucket still refilling was swept")
+	}
+}
@@ -0,0 +1,96 @@
+// Package ratelimit implements token-bucket rate limiting, either in process
+// memory or in Redis for limits shared by every node.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Rule allows Limit events per Period, with bursts of up to Burst events.
+// A zero Rule allows everything.
+type Rule struct {
+	Limit  int
+	Period time.Duration
+	Burst  int
+}
+
+// ParseRule parses "<limit>/<period>" with an optional ":<burst>", e.g.
+// "60/m:20". Periods are s, m or h, or any time.ParseDuration string. The
+// burst defaults to the limit. An empty string or "0" disables the rule.
+func ParseRule(s string) (Rule, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || s == "0" {
+		return Rule{}, nil
+	}
+
+	spec, burstStr, hasBurst := strings.Cut(s, ":")
+	limitStr, periodStr, ok := strings.Cut(spec, "/")
+	if !ok {
+		return Rule{}, fmt.Errorf("invalid rate limit %q: expected <limit>/<period>", s)
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 0 {
+		return Rule{}, fmt.Errorf("invalid rate limit %q: bad limit", s)
+	}
+
+	var period time.Duration
+	switch periodStr {
+	case "s":
+		period = time.Second
+	case "m":
+		period = time.Minute
+	case "h":
+		period = time.Hour
+	default:
+		period, err = time.ParseDuration(periodStr)
+		if err != nil || period <= 0 {
+			return Rule{}, fmt.Errorf("invalid rate limit %q: bad period", s)
+		}
+	}
+
+	burst := limit
+	if hasBurst {
+		burst, err = strconv.Atoi(burstStr)
+		if err != nil || burst < 1 {
+			return Rule{}, fmt.Errorf("invalid rate limit %q: bad burst", s)
+		}
+	}
+
+	return Rule{Limit: limit, Period: period, Burst: burst}, nil
+}
+
+// Disabled reports whether the rule allows everything
+func (r Rule) Disabled() bool {
+	return r.Limit <= 0 || r.Period <= 0
+}
+
+// perMillisecond is the refill rate in tokens per millisecond
+func (r Rule) perMillisecond() float64 {
+	return float64(r.Limit) / (float64(r.Period) / float64(time.Millisecond))
+}
+
+func (r Rule) String() string {
+	if r.Disabled() {
+		return "unlimited"
+	}
+	return fmt.Sprintf("%d/%s:%d", r.Limit, r.Period, r.Burst)
+}
+
+// Result is the outcome of a single Allow call
+type Result struct {
+	Allowed bool
+	// RetryAfter is how long until the next event would be allowed. Only set
+	// when Allowed is false.
+	RetryAfter time.Duration
+}
+
+// Limiter takes one token from the bucket identified by key. Implementations
+// return Allowed together with any error so callers can fail open.
+type Limiter interface {
+	Allow(ctx context.Context, key string, rule Rule) (Result, error)
+}
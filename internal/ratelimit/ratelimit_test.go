@@ -0,0 +1,48 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRule(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    Rule
+		wantErr bool
+	}{
+		{in: "", want: Rule{}},
+		{in: "0", want: Rule{}},
+		{in: "60/m", want: Rule{Limit: 60, Period: time.Minute, Burst: 60}},
+		{in: " 60/m:20 ", want: Rule{Limit: 60, Period: time.Minute, Burst: 20}},
+		{in: "5/s", want: Rule{Limit: 5, Period: time.Second, Burst: 5}},
+		{in: "100/h:1", want: Rule{Limit: 100, Period: time.Hour, Burst: 1}},
+		{in: "10/30s", want: Rule{Limit: 10, Period: 30 * time.Second, Burst: 10}},
+		{in: "60", wantErr: true},
+		{in: "x/m", wantErr: true},
+		{in: "-1/m", wantErr: true},
+		{in: "60/fortnight", wantErr: true},
+		{in: "60/-1s", wantErr: true},
+		{in: "60/m:0", wantErr: true},
+		{in: "60/m:x", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := ParseRule(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseRule(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("ParseRule(%q) = %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestRuleDisabled(t *testing.T) {
+	if !(Rule{}).Disabled() {
+		t.Error("zero Rule is not disabled")
+	}
+	if (Rule{Limit: 1, Period: time.Second, Burst: 1}).Disabled() {
+		t.Error("1/s is disabled")
+	}
+}
@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// tokenBucketScript refills and takes a token atomically. The Redis clock is
+// used so nodes with skewed clocks share the same bucket correctly.
+//
+// KEYS[1] bucket key
+// ARGV[1] refill rate in tokens per millisecond
+// ARGV[2] burst
+// Returns {allowed, retry after in milliseconds}.
+var tokenBucketScript = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+
+local data = redis.call('HMGET', KEYS[1], 'tokens', 'ts')
+local tokens = tonumber(data[1])
+local ts = tonumber(data[2])
+if tokens == nil or ts == nil then
+	tokens = burst
+	ts = now
+end
+
+tokens = math.min(burst, tokens + math.max(0, now - ts) * rate)
+
+local allowed = 0
+local retry = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry = math.ceil((1 - tokens) / rate)
+end
+
+redis.call('HSET', KEYS[1], 'tokens', tostring(tokens), 'ts', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate) + 1000)
+return {allowed, retry}
+`)
+
+// Redis keeps buckets in Redis so a limit applies across all nodes
+type Redis struct {
+	client *redis.Client
+}
+
+func NewRedis(client *redis.Client) *Redis {
+	return &Redis{client: client}
+}
+
+func redisKey(key string) string {
+	return "ratelimit:" + key
+}
+
+// Allow fails open: if Redis is unavailable the event is allowed and the error
+// is returned for logging
+func (l *Redis) Allow(ctx context.Context, key string, rule Rule) (Result, error) {
+	if rule.Disabled() {
+		return Result{Allowed: true}, nil
+	}
+
+	res, err := tokenBucketScript.Run(ctx, l.client, []string{redisKey(key)}, rule.perMillisecond(), rule.Burst).Int64Slice()
+	if err != nil {
+		return Result{Allowed: true}, err
+	}
+	if len(res) != 2 || res[0] == 1 {
+		return Result{Allowed: true}, nil
+	}
+
+	retry := time.Duration(math.Max(float64(res[1]), 1)) * time.Millisecond
+	return Result{Allowed: false, RetryAfter: retry}, nil
+}
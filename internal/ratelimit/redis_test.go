@@ -0,0 +1,88 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+func newTestRedis(t *testing.T) (*Redis, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	mr.SetTime(time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC))
+
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return NewRedis(client), mr
+}
+
+func TestRedisBurstAndRefill(t *testing.T) {
+	l, mr := newTestRedis(t)
+	ctx := context.Background()
+	rule := Rule{Limit: 60, Period: time.Minute, Burst: 3}
+
+	for i := 0; i < rule.Burst; i++ {
+		res, err := l.Allow(ctx, "k", rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !res.Allowed {
+			t.Fatalf("event %d of the burst denied", i+1)
+		}
+	}
+
+	res, err := l.Allow(ctx, "k", rule)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Allowed || res.RetryAfter != time.Second {
+		t.Fatalf("event over the burst = %+v, want denied with 1s", res)
+	}
+
+	if res, _ := l.Allow(ctx, "other", rule); !res.Allowed {
+		t.Fatal("other key denied")
+	}
+
+	mr.SetTime(time.Date(2026, 1, 1, 12, 0, 1, 0, time.UTC))
+	if res, _ := l.Allow(ctx, "k", rule); !res.Allowed {
+		t.Fatal("event after refill denied")
+	}
+	if res, _ := l.Allow(ctx, "k", rule); res.Allowed {
+		t.Fatal("refilled more than one token in a second")
+	}
+}
+
+func TestRedisBucketExpires(t *testing.T) {
+	l, mr := newTestRedis(t)
+	ctx := context.Background()
+	rule := Rule{Limit: 10, Period: time.Second, Burst: 5}
+
+	l.Allow(ctx, "k", rule)
+	ttl := mr.TTL(redisKey("k"))
+	// The bucket lives until it would be full again, plus a margin
+	if ttl <= 0 || ttl > 1500*time.Millisecond {
+		t.Fatalf("TTL = %s, want the refill time plus 1s", ttl)
+	}
+
+	mr.FastForward(ttl)
+	if mr.Exists(redisKey("k")) {
+		t.Fatal("bucket did not expire")
+	}
+}
+
+func TestRedisFailsOpen(t *testing.T) {
+	l, mr := newTestRedis(t)
+	mr.Close()
+
+	res, err := l.Allow(context.Background(), "k", Rule{Limit: 1, Period: time.Hour, Burst: 1})
+	if err == nil {
+		t.Fatal("Allow with Redis down returned no error")
+	}
+	if !res.Allowed {
+		t.Fatal("Allow with Redis down denied the event")
+	}
+}
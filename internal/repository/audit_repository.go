@@ -0,0 +1,45 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type AuditRepository struct {
+	db *sqlx.DB
+}
+
+func NewAuditRepository(db *sqlx.DB) *AuditRepository {
+	return &AuditRepository{db: db}
+}
+
+func (r *AuditRepository) Create(ctx context.Context, entry *models.AuditEntry) error {
+	query := `
+INSERT INTO audit_log (actor_id, action, target_type, target_id, ip_address, metadata)
+VALUES ($1, $2, $3, $4, $5, $6)
+RETURNING id, created_at
+`
+	metadata := entry.Metadata
+	if len(metadata) == 0 {
+		metadata = []byte("{}")
+	}
+
+	err := r.db.QueryRowContext(ctx,
+		query,
+		entry.ActorID,
+		entry.Action,
+		entry.TargetType,
+		entry.TargetID,
+		entry.IPAddress,
+		[]byte(metadata),
+	).Scan(&entry.ID, &entry.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create audit entry: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,71 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type BlockRepository struct {
+	db *sqlx.DB
+}
+
+func NewBlockRepository(db *sqlx.DB) *BlockRepository {
+	return &BlockRepository{db: db}
+}
+
+// Block records that blockerID blocked blockedID. Blocking twice is a no-op.
+func (r *BlockRepository) Block(ctx context.Context, blockerID, blockedID int) error {
+	query := `
+INSERT INTO user_blocks (blocker_id, blocked_id)
+VALUES ($1, $2)
+ON CONFLICT DO NOTHING`
+
+	if _, err := r.db.ExecContext(ctx, query, blockerID, blockedID); err != nil {
+		return fmt.Errorf("failed to block user: %w", err)
+	}
+
+	return nil
+}
+
+func (r *BlockRepository) Unblock(ctx context.Context, blockerID, blockedID int) error {
+	query := `DELETE FROM user_blocks WHERE blocker_id = $1 AND blocked_id = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, blockerID, blockedID); err != nil {
+		return fmt.Errorf("failed to unblock user: %w", err)
+	}
+
+	return nil
+}
+
+// IsBlocked reports whether blockerID has blocked blockedID
+func (r *BlockRepository) IsBlocked(ctx context.Context, blockerID, blockedID int) (bool, error) {
+	query := `
+SELECT EXISTS (
+    SELECT 1 FROM user_blocks
+    WHERE blocker_id = $1 AND blocked_id = $2
+)`
+
+	var blocked bool
+	if err := r.db.GetContext(ctx, &blocked, query, blockerID, blockedID); err != nil {
+		return false, fmt.Errorf("failed to check block: %w", err)
+	}
+
+	return blocked, nil
+}
+
+// GetBlockedIDs returns the IDs of the users blockerID has blocked
+func (r *BlockRepository) GetBlockedIDs(ctx context.Context, blockerID int) ([]int, error) {
+	query := `
+SELECT blocked_id FROM user_blocks
+WHERE blocker_id = $1
+ORDER BY created_at DESC`
+
+	ids := []int{}
+	if err := r.db.SelectContext(ctx, &ids, query, blockerID); err != nil {
+		return nil, fmt.Errorf("failed to get blocked users: %w", err)
+	}
+
+	return ids, nil
+}
@@ -0,0 +1,205 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+// ErrExportActive means the user already has a pending or running export
+var ErrExportActive = errors.New("an export is already in progress")
+
+type ExportRepository struct {
+	db *sqlx.DB
+}
+
+func NewExportRepository(db *sqlx.DB) *ExportRepository {
+	return &ExportRepository{db: db}
+}
+
+// Create inserts a pending export. It returns ErrExportActive if the user
+// already has one, which the partial unique index enforces even when two
+// requests race.
+func (r *ExportRepository) Create(ctx context.Context, export *models.DataExport) error {
+	query := `
+INSERT INTO data_exports (user_id, status)
+VALUES ($1, $2)
+RETURNING id, created_at
+`
+	err := r.db.QueryRowContext(ctx, query, export.UserID, export.Status).Scan(&export.ID, &export.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrExportActive
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create export: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ExportRepository) GetByID(ctx context.Context, id int) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	query := `SELECT * FROM data_exports WHERE id = $1`
+
+	err := r.db.GetContext(ctx, export, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get export by id: %w", err)
+	}
+
+	return export, nil
+}
+
+func (r *ExportRepository) ListByUser(ctx context.Context, userID int) ([]models.DataExport, error) {
+	query := `
+SELECT * FROM data_exports
+WHERE user_id = $1
+ORDER BY created_at DESC`
+
+	var exports []models.DataExport
+	if err := r.db.SelectContext(ctx, &exports, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to list exports: %w", err)
+	}
+
+	return exports, nil
+}
+
+// HasActive reports whether the user already has a pending or running export
+func (r *ExportRepository) HasActive(ctx context.Context, userID int) (bool, error) {
+	query := `
+SELECT EXISTS (
+    SELECT 1 FROM data_exports
+    WHERE user_id = $1 AND status IN ('pending', 'processing')
+)`
+
+	var active bool
+	if err := r.db.GetContext(ctx, &active, query, userID); err != nil {
+		return false, fmt.Errorf("failed to check active exports: %w", err)
+	}
+
+	return active, nil
+}
+
+// GetPendingIDs returns the IDs of exports waiting for a worker
+func (r *ExportRepository) GetPendingIDs(ctx context.Context) ([]int, error) {
+	query := `SELECT id FROM data_exports WHERE status = 'pending' ORDER BY created_at`
+
+	var ids []int
+	if err := r.db.SelectContext(ctx, &ids, query); err != nil {
+		return nil, fmt.Errorf("failed to get pending exports: %w", err)
+	}
+
+	return ids, nil
+}
+
+// Claim atomically moves a pending export to processing and counts the
+// attempt. It returns nil when another worker (possibly on another node)
+// already claimed it.
+func (r *ExportRepository) Claim(ctx context.Context, id int) (*models.DataExport, error) {
+	export := &models.DataExport{}
+	query := `
+UPDATE data_exports
+SET status = 'processing', started_at = CURRENT_TIMESTAMP, attempts = attempts + 1
+WHERE id = $1 AND status = 'pending'
+RETURNING *`
+
+	err := r.db.GetContext(ctx, export, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim export: %w", err)
+	}
+
+	return export, nil
+}
+
+// MarkReady completes the attempt the export was claimed for. It fails with
+// sql.ErrNoRows if the job was reclaimed meanwhile.
+func (r *ExportRepository) MarkReady(ctx context.Context, export *models.DataExport, storageKey string, expiresAt time.Time) error {
+	query := `
+UPDATE data_exports
+SET status = 'ready', storage_key = $1, completed_at = CURRENT_TIMESTAMP, expires_at = $2
+WHERE id = $3 AND status = 'processing' AND attempts = $4
+RETURNING status, storage_key, completed_at, expires_at`
+
+	err := r.db.QueryRowContext(ctx, query, storageKey, expiresAt, export.ID, export.Attempts).
+		Scan(&export.Status, &export.StorageKey, &export.CompletedAt, &export.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to mark export ready: %w", err)
+	}
+
+	return nil
+}
+
+// MarkFailed fails the attempt the export was claimed for. A job that was
+// reclaimed meanwhile is left alone.
+func (r *ExportRepository) MarkFailed(ctx context.Context, export *models.DataExport, reason string) error {
+	query := `
+UPDATE data_exports
+SET status = 'failed', error = $1, completed_at = CURRENT_TIMESTAMP
+WHERE id = $2 AND status = 'processing' AND attempts = $3`
+
+	if _, err := r.db.ExecContext(ctx, query, reason, export.ID, export.Attempts); err != nil {
+		return fmt.Errorf("failed to mark export failed: %w", err)
+	}
+
+	return nil
+}
+
+// ReclaimStale handles exports that have been processing for longer than
+// timeout, which means the node building them died. They go back to pending,
+// or fail once they used up maxAttempts. It returns how many were reclaimed
+// and how many failed.
+func (r *ExportRepository) ReclaimStale(ctx context.Context, timeout time.Duration, maxAttempts int) (int, int, error) {
+	query := `
+UPDATE data_exports
+SET status = CASE WHEN attempts >= $2 THEN 'failed' ELSE 'pending' END,
+    error = CASE WHEN attempts >= $2 THEN 'export timed out' ELSE error END,
+    completed_at = CASE WHEN attempts >= $2 THEN CURRENT_TIMESTAMP ELSE completed_at END
+WHERE status = 'processing'
+  AND (started_at IS NULL OR started_at < CURRENT_TIMESTAMP - $1 * INTERVAL '1 millisecond')
+RETURNING status`
+
+	var statuses []models.DataExportStatus
+	if err := r.db.SelectContext(ctx, &statuses, query, timeout.Milliseconds(), maxAttempts); err != nil {
+		return 0, 0, fmt.Errorf("failed to reclaim stale exports: %w", err)
+	}
+
+	var reclaimed, failed int
+	for _, status := range statuses {
+		if status == models.DataExportStatusFailed {
+			failed++
+		} else {
+			reclaimed++
+		}
+	}
+	return reclaimed, failed, nil
+}
+
+// ExpireDue marks ready exports past their expiry as expired and returns them so
+// their files can be removed
+func (r *ExportRepository) ExpireDue(ctx context.Context) ([]models.DataExport, error) {
+	query := `
+UPDATE data_exports
+SET status = 'expired'
+WHERE status = 'ready' AND expires_at < CURRENT_TIMESTAMP
+RETURNING *`
+
+	var exports []models.DataExport
+	if err := r.db.SelectContext(ctx, &exports, query); err != nil {
+		return nil, fmt.Errorf("failed to expire exports: %w", err)
+	}
+
+	return exports, nil
+}
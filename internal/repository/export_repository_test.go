@@ -0,0 +1,56 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+func newMockExportRepository(t *testing.T) (*ExportRepository, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return NewExportRepository(sqlx.NewDb(db, "postgres")), mock
+}
+
+func TestExportCreateActive(t *testing.T) {
+	repo, mock := newMockExportRepository(t)
+	mock.ExpectQuery("INSERT INTO data_exports").
+		WillReturnError(&pq.Error{Code: "23505", Constraint: "idx_data_exports_one_active"})
+
+	err := repo.Create(context.Background(), &models.DataExport{UserID: 1, Status: models.DataExportStatusPending})
+	if !errors.Is(err, ErrExportActive) {
+		t.Fatalf("Create = %v, want ErrExportActive", err)
+	}
+}
+
+func TestExportReclaimStale(t *testing.T) {
+	repo, mock := newMockExportRepository(t)
+	mock.ExpectQuery("UPDATE data_exports").
+		WithArgs(int64(15*60*1000), 3).
+		WillReturnRows(sqlmock.NewRows([]string{"status"}).
+			AddRow("pending").
+			AddRow("failed").
+			AddRow("pending"))
+
+	reclaimed, failed, err := repo.ReclaimStale(context.Background(), 15*time.Minute, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reclaimed != 2 || failed != 1 {
+		t.Fatalf("ReclaimStale = %d reclaimed, %d failed, want 2 and 1", reclaimed, failed)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
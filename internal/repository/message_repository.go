@@ -1,53 +1,109 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
 type MessageRepository struct {
-	db *sqlx.DB
+	db DBTX
 }
 
 func NewMessageRepository(db *sqlx.DB) *MessageRepository {
 	return &MessageRepository{db: db}
 }
 
-func (r *MessageRepository) Create(message *models.Message) error {
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *MessageRepository) WithTx(tx *sqlx.Tx) *MessageRepository {
+	return &MessageRepository{db: tx}
+}
+
+func (r *MessageRepository) Create(ctx context.Context, message *models.Message) error {
 	query := `
-INSERT INTO messages (sender_id, recipient_id, content, is_read)
-VALUES ($1, $2, $3, $4)
+INSERT INTO messages (sender_id, recipient_id, content, is_read, moderation_status, moderation_reason)
+VALUES ($1, $2, $3, $4, $5, $6)
 RETURNING id, created_at
 `
-	err := r.db.QueryRow(
+	ctx, span := startQuerySpan(ctx, "MessageRepository.Create", query)
+	defer span.End()
+
+	if message.ModerationStatus == "" {
+		message.ModerationStatus = models.ModerationApproved
+	}
+
+	err := r.db.QueryRowContext(ctx,
 		query,
 		message.SenderID,
 		message.RecipientID,
 		message.Content,
 		message.IsRead,
+		message.ModerationStatus,
+		message.ModerationReason,
 	).Scan(&message.ID, &message.CreatedAt)
 
 	if err != nil {
+		tracing.RecordError(span, err)
 		return fmt.Errorf("failed to create message: %w", err)
 	}
 
 	return nil
 }
 
-func (r *MessageRepository) GetConversation(userID1, userID2 int, limit int) ([]models.Message, error) {
+func (r *MessageRepository) GetByID(ctx context.Context, id int) (*models.Message, error) {
+	message := &models.Message{}
+	query := `SELECT * FROM messages WHERE id = $1`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.GetByID", query)
+	defer span.End()
+
+	err := r.db.GetContext(ctx, message, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to get message: %w", err)
+	}
+
+	return message, nil
+}
+
+// Delete removes a single message. Deleting a missing message is a no-op.
+func (r *MessageRepository) Delete(ctx context.Context, id int) error {
+	query := `DELETE FROM messages WHERE id = $1`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.Delete", query)
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
+
+	return nil
+}
+
+// GetConversation returns the latest messages between two users as seen by
+// userID1: held messages are only visible to their sender
+func (r *MessageRepository) GetConversation(ctx context.Context, userID1, userID2 int, limit int) ([]models.Message, error) {
 	query := `
 SELECT * FROM messages
-WHERE (sender_id = $1 AND recipient_id = $2)
-OR (sender_id = $2 AND recipient_id = $1)
+WHERE ((sender_id = $1 AND recipient_id = $2)
+OR (sender_id = $2 AND recipient_id = $1 AND moderation_status <> 'held'))
 ORDER BY created_at DESC
 LIMIT $3`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.GetConversation", query)
+	defer span.End()
 
 	var messages []models.Message
-	err := r.db.Select(&messages, query, userID1, userID2, limit)
+	err := r.db.SelectContext(ctx, &messages, query, userID1, userID2, limit)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("failed to get messages: %w", err)
 	}
 
@@ -59,19 +115,23 @@ LIMIT $3`
 	return messages, nil
 }
 
-func (r *MessageRepository) MarkAsRead(userID1, userID2 int) error {
+func (r *MessageRepository) MarkAsRead(ctx context.Context, userID1, userID2 int) error {
 	query := `
 UPDATE messages 
 SET is_read = true
-WHERE sender_id = $1 AND recipient_id = $2`
+WHERE sender_id = $1 AND recipient_id = $2 AND moderation_status <> 'held'`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.MarkAsRead", query)
+	defer span.End()
 
-	result, err := r.db.Exec(query, userID1, userID2)
+	result, err := r.db.ExecContext(ctx, query, userID1, userID2)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return fmt.Errorf("failed to mark as read: %w", err)
 	}
 
 	rows, err := result.RowsAffected()
 	if err != nil {
+		tracing.RecordError(span, err)
 		return fmt.Errorf("failed to get rows affected: %w", err)
 	}
 	if rows == 0 {
@@ -81,21 +141,24 @@ WHERE sender_id = $1 AND recipient_id = $2`
 	return nil
 }
 
-func (r *MessageRepository) GetUnreadCount(userID int) (int, error) {
+func (r *MessageRepository) GetUnreadCount(ctx context.Context, userID int) (int, error) {
 	query := `
 SELECT COUNT(*) FROM messages
-WHERE recipient_id = $1 AND is_read = false`
+WHERE recipient_id = $1 AND is_read = false AND moderation_status <> 'held'`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.GetUnreadCount", query)
+	defer span.End()
 
 	var count int
-	err := r.db.Get(&count, query, userID)
+	err := r.db.GetContext(ctx, &count, query, userID)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return 0, fmt.Errorf("failed to get message unread count: %w", err)
 	}
 
 	return count, nil
 }
 
-func (r *MessageRepository) GetConversationList(userID int) ([]models.ConversationPreview, error) {
+func (r *MessageRepository) GetConversationList(ctx context.Context, userID int) ([]models.ConversationPreview, error) {
 	query := `
         WITH ranked_messages AS (
             SELECT 
@@ -115,7 +178,7 @@ func (r *MessageRepository) GetConversationList(userID int) ([]models.Conversati
                     ORDER BY created_at DESC
                 ) as rn
             FROM messages
-            WHERE sender_id = $1 OR recipient_id = $1
+            WHERE sender_id = $1 OR (recipient_id = $1 AND moderation_status <> 'held')
         )
         SELECT 
             other_user_id,
@@ -127,12 +190,188 @@ func (r *MessageRepository) GetConversationList(userID int) ([]models.Conversati
         WHERE rn = 1
         ORDER BY created_at DESC
     `
+	ctx, span := startQuerySpan(ctx, "MessageRepository.GetConversationList", query)
+	defer span.End()
 
 	var conversations []models.ConversationPreview
-	err := r.db.Select(&conversations, query, userID)
+	err := r.db.SelectContext(ctx, &conversations, query, userID)
 	if err != nil {
+		tracing.RecordError(span, err)
 		return nil, fmt.Errorf("failed to get conversation list: %w", err)
 	}
 
 	return conversations, nil
 }
+
+// GetContactIDs returns the IDs of every user the given user has exchanged
+// messages with. Held messages only count for their sender.
+func (r *MessageRepository) GetContactIDs(ctx context.Context, userID int) ([]int, error) {
+	query := `
+SELECT DISTINCT CASE WHEN sender_id = $1 THEN recipient_id ELSE sender_id END
+FROM messages
+WHERE sender_id = $1 OR (recipient_id = $1 AND moderation_status <> 'held')`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.GetContactIDs", query)
+	defer span.End()
+
+	var ids []int
+	err := r.db.SelectContext(ctx, &ids, query, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to get contacts: %w", err)
+	}
+
+	return ids, nil
+}
+
+// FilterContacts returns the IDs among candidates that the user has exchanged
+// messages with, leaving out users on either side of a block. Held messages
+// only count for their sender.
+func (r *MessageRepository) FilterContacts(ctx context.Context, userID int, candidates []int) ([]int, error) {
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	query := `
+SELECT DISTINCT c.other_id FROM (
+    SELECT CASE WHEN sender_id = $1 THEN recipient_id ELSE sender_id END AS other_id
+    FROM messages
+    WHERE (sender_id = $1 AND recipient_id = ANY($2))
+    OR (recipient_id = $1 AND sender_id = ANY($2) AND moderation_status <> 'held')
+) c
+WHERE NOT EXISTS (
+    SELECT 1 FROM user_blocks b
+    WHERE (b.blocker_id = $1 AND b.blocked_id = c.other_id)
+    OR (b.blocker_id = c.other_id AND b.blocked_id = $1)
+)`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.FilterContacts", query)
+	defer span.End()
+
+	var ids []int
+	if err := r.db.SelectContext(ctx, &ids, query, userID, pq.Array(candidates)); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to filter contacts: %w", err)
+	}
+
+	return ids, nil
+}
+
+// DeleteAllForUser removes every message the user sent or received
+func (r *MessageRepository) DeleteAllForUser(ctx context.Context, userID int) error {
+	query := `DELETE FROM messages WHERE sender_id = $1 OR recipient_id = $1`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.DeleteAllForUser", query)
+	defer span.End()
+
+	if _, err := r.db.ExecContext(ctx, query, userID); err != nil {
+		tracing.RecordError(span, err)
+		return fmt.Errorf("failed to delete messages: %w", err)
+	}
+
+	return nil
+}
+
+// GetAllForUser returns every message the user sent or received, oldest first.
+// Held messages are only returned to their sender.
+func (r *MessageRepository) GetAllForUser(ctx context.Context, userID int) ([]models.Message, error) {
+	query := `
+SELECT * FROM messages
+WHERE sender_id = $1 OR (recipient_id = $1 AND moderation_status <> 'held')
+ORDER BY created_at`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.GetAllForUser", query)
+	defer span.End()
+
+	var messages []models.Message
+	err := r.db.SelectContext(ctx, &messages, query, userID)
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to get messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// HasConversation reports whether the two users have exchanged at least one
+// message as seen by userID1: held messages are only visible to their sender
+func (r *MessageRepository) HasConversation(ctx context.Context, userID1, userID2 int) (bool, error) {
+	query := `
+SELECT EXISTS (
+    SELECT 1 FROM messages
+    WHERE (sender_id = $1 AND recipient_id = $2)
+    OR (sender_id = $2 AND recipient_id = $1 AND moderation_status <> 'held')
+)`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.HasConversation", query)
+	defer span.End()
+
+	var exists bool
+	if err := r.db.GetContext(ctx, &exists, query, userID1, userID2); err != nil {
+		tracing.RecordError(span, err)
+		return false, fmt.Errorf("failed to check conversation: %w", err)
+	}
+
+	return exists, nil
+}
+
+// ListHeld returns the messages waiting for review, oldest first
+func (r *MessageRepository) ListHeld(ctx context.Context, limit, offset int) ([]models.Message, error) {
+	query := `
+SELECT * FROM messages
+WHERE moderation_status = 'held'
+ORDER BY created_at, id
+LIMIT $1 OFFSET $2`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.ListHeld", query)
+	defer span.End()
+
+	var messages []models.Message
+	if err := r.db.SelectContext(ctx, &messages, query, limit, offset); err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to list held messages: %w", err)
+	}
+
+	return messages, nil
+}
+
+// ApproveHeld releases a held message and returns it. It returns nil if the
+// message does not exist or is no longer held.
+func (r *MessageRepository) ApproveHeld(ctx context.Context, id int) (*models.Message, error) {
+	query := `
+UPDATE messages
+SET moderation_status = 'approved'
+WHERE id = $1 AND moderation_status = 'held'
+RETURNING *`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.ApproveHeld", query)
+	defer span.End()
+
+	message := &models.Message{}
+	err := r.db.GetContext(ctx, message, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to approve message: %w", err)
+	}
+
+	return message, nil
+}
+
+// RejectHeld deletes a held message and returns it. It returns nil if the
+// message does not exist or is no longer held.
+func (r *MessageRepository) RejectHeld(ctx context.Context, id int) (*models.Message, error) {
+	query := `
+DELETE FROM messages
+WHERE id = $1 AND moderation_status = 'held'
+RETURNING *`
+	ctx, span := startQuerySpan(ctx, "MessageRepository.RejectHeld", query)
+	defer span.End()
+
+	message := &models.Message{}
+	err := r.db.GetContext(ctx, message, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		tracing.RecordError(span, err)
+		return nil, fmt.Errorf("failed to reject message: %w", err)
+	}
+
+	return message, nil
+}
@@ -0,0 +1,37 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestMarkAsReadSkipsHeld(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := NewMessageRepository(sqlx.NewDb(db, "postgres"))
+
+	// Held messages were never shown to the recipient, so they stay unread
+	// and only the rest of the conversation produces a read receipt
+	query := regexp.QuoteMeta("WHERE sender_id = $1 AND recipient_id = $2 AND moderation_status <> 'held'")
+	mock.ExpectExec(query).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(query).WithArgs(1, 2).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := repo.MarkAsRead(context.Background(), 1, 2); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.MarkAsRead(context.Background(), 1, 2); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("MarkAsRead with only held messages = %v, want sql.ErrNoRows", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
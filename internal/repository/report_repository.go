@@ -0,0 +1,134 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
+)
+
+var (
+	ErrDuplicateReport = errors.New("report already open")
+	// ErrReportConflict means the report was claimed or resolved concurrently
+	ErrReportConflict = errors.New("report was changed by another admin")
+)
+
+type ReportRepository struct {
+	db *sqlx.DB
+}
+
+func NewReportRepository(db *sqlx.DB) *ReportRepository {
+	return &ReportRepository{db: db}
+}
+
+func (r *ReportRepository) Create(ctx context.Context, report *models.Report) error {
+	query := `
+INSERT INTO reports (reporter_id, target_type, reported_user_id, message_id, message_content, reason, details)
+VALUES ($1, $2, $3, $4, $5, $6, $7)
+RETURNING id, status, created_at
+`
+	err := r.db.QueryRowContext(ctx,
+		query,
+		report.ReporterID,
+		report.TargetType,
+		report.ReportedUserID,
+		report.MessageID,
+		report.MessageContent,
+		report.Reason,
+		report.Details,
+	).Scan(&report.ID, &report.Status, &report.CreatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrDuplicateReport
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create report: %w", err)
+	}
+
+	return nil
+}
+
+func (r *ReportRepository) GetByID(ctx context.Context, id int) (*models.Report, error) {
+	report := &models.Report{}
+	query := `SELECT * FROM reports WHERE id = $1`
+
+	err := r.db.GetContext(ctx, report, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get report: %w", err)
+	}
+
+	return report, nil
+}
+
+// List returns reports oldest first so the queue is worked in order. An empty
+// status returns reports in every status.
+func (r *ReportRepository) List(ctx context.Context, status models.ReportStatus, limit, offset int) ([]models.Report, error) {
+	query := `
+SELECT * FROM reports
+WHERE $1 = '' OR status = $1
+ORDER BY created_at, id
+LIMIT $2 OFFSET $3`
+
+	var reports []models.Report
+	if err := r.db.SelectContext(ctx, &reports, query, status, limit, offset); err != nil {
+		return nil, fmt.Errorf("failed to list reports: %w", err)
+	}
+
+	return reports, nil
+}
+
+// Claim assigns an open report to the admin. Claiming a report the admin
+// already holds is a no-op; any other claimed or resolved report returns
+// ErrReportConflict.
+func (r *ReportRepository) Claim(ctx context.Context, id, adminID int) error {
+	query := `
+UPDATE reports
+SET status = 'claimed', assignee_id = $2, claimed_at = COALESCE(claimed_at, CURRENT_TIMESTAMP)
+WHERE id = $1 AND (status = 'open' OR (status = 'claimed' AND assignee_id = $2))`
+
+	result, err := r.db.ExecContext(ctx, query, id, adminID)
+	if err != nil {
+		return fmt.Errorf("failed to claim report: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrReportConflict
+	}
+
+	return nil
+}
+
+// Resolve closes a report claimed by the admin
+func (r *ReportRepository) Resolve(ctx context.Context, id, adminID int, action models.ReportAction, note string) error {
+	query := `
+UPDATE reports
+SET status = 'resolved', action = $3, resolution_note = NULLIF($4, ''), resolved_at = CURRENT_TIMESTAMP
+WHERE id = $1 AND status = 'claimed' AND assignee_id = $2`
+
+	result, err := r.db.ExecContext(ctx, query, id, adminID, action, note)
+	if err != nil {
+		return fmt.Errorf("failed to resolve report: %w", err)
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to get rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrReportConflict
+	}
+
+	return nil
+}
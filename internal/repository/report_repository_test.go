@@ -0,0 +1,49 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestReportClaimAndResolveConflicts(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	repo := NewReportRepository(sqlx.NewDb(db, "postgres"))
+	ctx := context.Background()
+
+	// The conditional update decides who wins: a report held by another admin
+	// or already resolved matches no row
+	mock.ExpectExec("UPDATE reports SET status = 'claimed'").WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE reports SET status = 'claimed'").WithArgs(1, 6).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE reports SET status = 'resolved'").WithArgs(1, 6, models.ReportActionDismiss, "").WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("UPDATE reports SET status = 'resolved'").WithArgs(1, 5, models.ReportActionWarn, "spam").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE reports SET status = 'claimed'").WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+
+	if err := repo.Claim(ctx, 1, 5); err != nil {
+		t.Fatalf("Claim = %v", err)
+	}
+	if err := repo.Claim(ctx, 1, 6); !errors.Is(err, ErrReportConflict) {
+		t.Fatalf("Claim of a claimed report = %v, want ErrReportConflict", err)
+	}
+	if err := repo.Resolve(ctx, 1, 6, models.ReportActionDismiss, ""); !errors.Is(err, ErrReportConflict) {
+		t.Fatalf("Resolve by another admin = %v, want ErrReportConflict", err)
+	}
+	if err := repo.Resolve(ctx, 1, 5, models.ReportActionWarn, "spam"); err != nil {
+		t.Fatalf("Resolve = %v", err)
+	}
+	if err := repo.Claim(ctx, 1, 5); !errors.Is(err, ErrReportConflict) {
+		t.Fatalf("Claim of a resolved report = %v, want ErrReportConflict", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,103 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type SessionRepository struct {
+	db DBTX
+}
+
+func NewSessionRepository(db *sqlx.DB) *SessionRepository {
+	return &SessionRepository{db: db}
+}
+
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *SessionRepository) WithTx(tx *sqlx.Tx) *SessionRepository {
+	return &SessionRepository{db: tx}
+}
+
+func (r *SessionRepository) Create(ctx context.Context, session *models.Session) error {
+	query := `
+INSERT INTO sessions (id, user_id, user_agent, ip_address, expires_at)
+VALUES ($1, $2, $3, $4, $5)
+RETURNING created_at
+`
+	err := r.db.QueryRowContext(ctx,
+		query,
+		session.ID,
+		session.UserID,
+		session.UserAgent,
+		session.IPAddress,
+		session.ExpiresAt,
+	).Scan(&session.CreatedAt)
+
+	if err != nil {
+		return fmt.Errorf("failed to create session: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SessionRepository) GetByID(ctx context.Context, id string) (*models.Session, error) {
+	session := &models.Session{}
+	query := `SELECT * FROM sessions WHERE id = $1`
+
+	err := r.db.GetContext(ctx, session, query, id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get session by id: %w", err)
+	}
+
+	return session, nil
+}
+
+// RevokeAllForUser revokes every active session of the user
+func (r *SessionRepository) RevokeAllForUser(ctx context.Context, userID int) error {
+	query := `
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND revoked_at IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, userID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+// RevokeAllExcept revokes every active session of the user other than keepID
+func (r *SessionRepository) RevokeAllExcept(ctx context.Context, userID int, keepID string) error {
+	query := `
+UPDATE sessions
+SET revoked_at = CURRENT_TIMESTAMP
+WHERE user_id = $1 AND id != $2 AND revoked_at IS NULL`
+
+	if _, err := r.db.ExecContext(ctx, query, userID, keepID); err != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", err)
+	}
+
+	return nil
+}
+
+func (r *SessionRepository) ListByUser(ctx context.Context, userID int) ([]models.Session, error) {
+	query := `
+SELECT * FROM sessions
+WHERE user_id = $1
+ORDER BY created_at DESC`
+
+	var sessions []models.Session
+	if err := r.db.SelectContext(ctx, &sessions, query, userID); err != nil {
+		return nil, fmt.Errorf("failed to list sessions: %w", err)
+	}
+
+	return sessions, nil
+}
@@ -0,0 +1,46 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/jmoiron/sqlx"
+)
+
+type StatsRepository struct {
+	db *sqlx.DB
+}
+
+func NewStatsRepository(db *sqlx.DB) *StatsRepository {
+	return &StatsRepository{db: db}
+}
+
+// Platform counts users, messages and reports in a single round trip
+func (r *StatsRepository) Platform(ctx context.Context) (*models.PlatformStats, error) {
+	query := `
+SELECT
+    (SELECT COUNT(*) FROM users) AS users,
+    (SELECT COUNT(*) FROM users
+     WHERE deleted_at IS NULL AND banned_at IS NULL
+       AND (suspended_until IS NULL OR suspended_until <= CURRENT_TIMESTAMP)) AS active_users,
+    (SELECT COUNT(*) FROM users
+     WHERE banned_at IS NULL AND suspended_until > CURRENT_TIMESTAMP) AS suspended_users,
+    (SELECT COUNT(*) FROM users WHERE banned_at IS NOT NULL) AS banned_users,
+    (SELECT COUNT(*) FROM users WHERE deleted_at IS NOT NULL) AS deleted_users,
+    (SELECT COUNT(*) FROM users
+     WHERE created_at > CURRENT_TIMESTAMP - INTERVAL '24 hours') AS new_users_24h,
+    (SELECT COUNT(*) FROM messages) AS messages,
+    (SELECT COUNT(*) FROM messages
+     WHERE created_at > CURRENT_TIMESTAMP - INTERVAL '24 hours') AS messages_24h,
+    (SELECT COUNT(*) FROM messages WHERE moderation_status = 'held') AS held_messages,
+    (SELECT COUNT(*) FROM reports WHERE status = 'open') AS open_reports,
+    (SELECT COUNT(*) FROM reports WHERE status = 'claimed') AS claimed_reports`
+
+	stats := &models.PlatformStats{}
+	if err := r.db.GetContext(ctx, stats, query); err != nil {
+		return nil, fmt.Errorf("failed to get platform stats: %w", err)
+	}
+
+	return stats, nil
+}
@@ -0,0 +1,21 @@
+package repository
+
+import (
+	"context"
+	"strings"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startQuerySpan starts a client span for a database query. The query text is
+// recorded with its placeholders, never with the arguments.
+func startQuerySpan(ctx context.Context, name, query string) (context.Context, trace.Span) {
+	ctx, span := tracing.Start(ctx, name, trace.SpanKindClient)
+	span.SetAttributes(
+		attribute.String("db.system", "postgresql"),
+		attribute.String("db.query.text", strings.TrimSpace(query)),
+	)
+	return ctx, span
+}
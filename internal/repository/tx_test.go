@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jmoiron/sqlx"
+)
+
+func TestRunInTx(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	sqlxDB := sqlx.NewDb(db, "postgres")
+	sessions := NewSessionRepository(sqlxDB)
+	users := NewUserRepository(sqlxDB)
+
+	// Every statement runs in the transaction, which is committed
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE sessions").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE users").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+
+	err = RunInTx(context.Background(), sqlxDB, func(tx *sqlx.Tx) error {
+		if err := sessions.WithTx(tx).RevokeAllForUser(context.Background(), 1); err != nil {
+			return err
+		}
+		return users.WithTx(tx).Anonymize(context.Background(), 1)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A failing statement rolls back what ran before it
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE sessions").WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec("UPDATE users").WillReturnError(errors.New("boom"))
+	mock.ExpectRollback()
+
+	err = RunInTx(context.Background(), sqlxDB, func(tx *sqlx.Tx) error {
+		if err := sessions.WithTx(tx).RevokeAllForUser(context.Background(), 1); err != nil {
+			return err
+		}
+		return users.WithTx(tx).Anonymize(context.Background(), 1)
+	})
+	if err == nil {
+		t.Fatal("RunInTx returned nil for a failed statement")
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
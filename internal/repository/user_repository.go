@@ -1,16 +1,21 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
 	"github.com/jmoiron/sqlx"
+	"github.com/lib/pq"
 )
 
+var ErrUsernameTaken = errors.New("username already taken")
+
 type UserRepository struct {
-	db *sqlx.DB
+	db DBTX
 }
 
 func NewUserRepository(db *sqlx.DB) *UserRepository {
@@ -19,7 +24,12 @@ func NewUserRepository(db *sqlx.DB) *UserRepository {
 	}
 }
 
-func (r *UserRepository) Create(user *models.User) error {
+// WithTx returns a copy of the repository that runs its queries in tx
+func (r *UserRepository) WithTx(tx *sqlx.Tx) *UserRepository {
+	return &UserRepository{db: tx}
+}
+
+func (r *UserRepository) Create(ctx context.Context, user *models.User) error {
 	query := `
 		INSERT INTO users (username, email, password_hash, display_name)
 		VALUES ($1, $2, $3, $4)
@@ -33,7 +43,7 @@ func (r *UserRepository) Create(user *models.User) error {
 		displayName = nil
 	}
 
-	err := r.db.QueryRow(query, user.Username, user.Email, user.PasswordHash, displayName).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
+	err := r.db.QueryRowContext(ctx, query, user.Username, user.Email, user.PasswordHash, displayName).Scan(&user.ID, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return fmt.Errorf("failed to insert user: %w", err)
 	}
@@ -41,11 +51,11 @@ func (r *UserRepository) Create(user *models.User) error {
 	return nil
 }
 
-func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
+func (r *UserRepository) GetByEmail(ctx context.Context, email string) (*models.User, error) {
 	user := &models.User{}
 	query := `SELECT * FROM users WHERE email = $1`
 
-	err := r.db.Get(user, query, email)
+	err := r.db.GetContext(ctx, user, query, email)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -56,11 +66,11 @@ func (r *UserRepository) GetByEmail(email string) (*models.User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
+func (r *UserRepository) GetByUsername(ctx context.Context, username string) (*models.User, error) {
 	user := &models.User{}
 	query := `SELECT * FROM users WHERE username = $1`
 
-	err := r.db.Get(user, query, username)
+	err := r.db.GetContext(ctx, user, query, username)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -71,11 +81,11 @@ func (r *UserRepository) GetByUsername(username string) (*models.User, error) {
 	return user, nil
 }
 
-func (r *UserRepository) GetByID(id int) (*models.User, error) {
+func (r *UserRepository) GetByID(ctx context.Context, id int) (*models.User, error) {
 	user := &models.User{}
 	query := `SELECT * FROM users WHERE id = $1`
 
-	err := r.db.Get(user, query, id)
+	err := r.db.GetContext(ctx, user, query, id)
 	if errors.Is(err, sql.ErrNoRows) {
 		return nil, nil
 	}
@@ -86,17 +96,34 @@ func (r *UserRepository) GetByID(id int) (*models.User, error) {
 	return user, nil
 }
 
+// GetByIDs returns the users with the given IDs in no particular order.
+// Missing IDs are skipped.
+func (r *UserRepository) GetByIDs(ctx context.Context, ids []int) ([]models.User, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query := `SELECT * FROM users WHERE id = ANY($1)`
+
+	var users []models.User
+	if err := r.db.SelectContext(ctx, &users, query, pq.Array(ids)); err != nil {
+		return nil, fmt.Errorf("failed to get users by id: %w", err)
+	}
+
+	return users, nil
+}
+
 // SearchUsers searches for users by username or email
-func (r *UserRepository) SearchUsers(query string, limit int) ([]models.User, error) {
+func (r *UserRepository) SearchUsers(ctx context.Context, query string, limit int) ([]models.User, error) {
 	searchQuery := `
         SELECT * FROM users 
-        WHERE username ILIKE $1 OR email ILIKE $1
+        WHERE (username ILIKE $1 OR email ILIKE $1) AND deleted_at IS NULL
         LIMIT $2
     `
 
 	var users []models.User
 	searchPattern := "%" + query + "%"
-	err := r.db.Select(&users, searchQuery, searchPattern, limit)
+	err := r.db.SelectContext(ctx, &users, searchQuery, searchPattern, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to search users: %w", err)
 	}
@@ -104,19 +131,181 @@ func (r *UserRepository) SearchUsers(query string, limit int) ([]models.User, er
 	return users, nil
 }
 
-func (r *UserRepository) GetAllUsers(excludeUserID int, limit int) ([]models.User, error) {
+func (r *UserRepository) GetAllUsers(ctx context.Context, excludeUserID int, limit int) ([]models.User, error) {
 	query := `
         SELECT * FROM users 
-        WHERE id != $1
+        WHERE id != $1 AND deleted_at IS NULL
         ORDER BY username
         LIMIT $2
     `
 
 	var users []models.User
-	err := r.db.Select(&users, query, excludeUserID, limit)
+	err := r.db.SelectContext(ctx, &users, query, excludeUserID, limit)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get users: %w", err)
 	}
 
 	return users, nil
 }
+
+// MarkEmailVerified sets email_verified_at if the email was not verified yet
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET email_verified_at = CURRENT_TIMESTAMP
+		WHERE id = $1 AND email_verified_at IS NULL
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UpdatePassword(ctx context.Context, id int, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, passwordHash, id); err != nil {
+		return fmt.Errorf("failed to update password: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateProfile persists the editable profile fields of the user
+func (r *UserRepository) UpdateProfile(ctx context.Context, user *models.User) error {
+	query := `
+		UPDATE users
+		SET username = $1, display_name = $2, bio = $3, status_text = $4, avatar_url = $5,
+		    last_seen_visibility = $6
+		WHERE id = $7
+		RETURNING updated_at
+	`
+
+	err := r.db.QueryRowContext(ctx,
+		query,
+		user.Username,
+		user.DisplayName,
+		user.Bio,
+		user.StatusText,
+		user.AvatarURL,
+		user.LastSeenVisibility,
+		user.ID,
+	).Scan(&user.UpdatedAt)
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return ErrUsernameTaken
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update profile: %w", err)
+	}
+
+	return nil
+}
+
+// Anonymize strips all personal data from the user row while keeping the ID so
+// that retained messages still reference a (deleted) user
+func (r *UserRepository) Anonymize(ctx context.Context, id int) error {
+	query := `
+		UPDATE users
+		SET username          = 'deleted_' || id,
+		    email             = 'deleted_' || id || '@deleted.invalid',
+		    password_hash     = '!',
+		    display_name      = 'Deleted user',
+		    avatar_url        = NULL,
+		    bio               = NULL,
+		    status_text       = NULL,
+		    email_verified_at = NULL,
+		    deleted_at        = CURRENT_TIMESTAMP
+		WHERE id = $1
+	`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to anonymize user: %w", err)
+	}
+
+	return nil
+}
+
+// List returns users matching the filter, newest first
+func (r *UserRepository) List(ctx context.Context, filter models.UserFilter, limit, offset int) ([]models.User, error) {
+	query := `
+		SELECT * FROM users
+		WHERE ($1 = '' OR username ILIKE '%' || $1 || '%' OR email ILIKE '%' || $1 || '%')
+		  AND ($2 = '' OR role = $2)
+		  AND CASE $3
+		      WHEN 'active' THEN deleted_at IS NULL AND banned_at IS NULL
+		          AND (suspended_until IS NULL OR suspended_until <= CURRENT_TIMESTAMP)
+		      WHEN 'suspended' THEN banned_at IS NULL AND suspended_until > CURRENT_TIMESTAMP
+		      WHEN 'banned' THEN banned_at IS NOT NULL
+		      WHEN 'deleted' THEN deleted_at IS NOT NULL
+		      ELSE TRUE
+		  END
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4 OFFSET $5
+	`
+
+	var users []models.User
+	err := r.db.SelectContext(ctx, &users, query, filter.Query, filter.Role, filter.Status, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list users: %w", err)
+	}
+
+	return users, nil
+}
+
+// Suspend blocks logins until the given time; a zero time lifts the suspension
+func (r *UserRepository) Suspend(ctx context.Context, id int, until time.Time, reason string) error {
+	query := `UPDATE users SET suspended_until = $1, restriction_reason = NULLIF($2, '') WHERE id = $3`
+
+	suspendedUntil := sql.NullTime{Time: until, Valid: !until.IsZero()}
+	if _, err := r.db.ExecContext(ctx, query, suspendedUntil, reason, id); err != nil {
+		return fmt.Errorf("failed to suspend user: %w", err)
+	}
+
+	return nil
+}
+
+// Ban blocks logins until the ban is lifted
+func (r *UserRepository) Ban(ctx context.Context, id int, reason string) error {
+	query := `UPDATE users SET banned_at = CURRENT_TIMESTAMP, restriction_reason = NULLIF($1, '') WHERE id = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, reason, id); err != nil {
+		return fmt.Errorf("failed to ban user: %w", err)
+	}
+
+	return nil
+}
+
+// LiftRestrictions clears any ban or suspension
+func (r *UserRepository) LiftRestrictions(ctx context.Context, id int) error {
+	query := `UPDATE users SET banned_at = NULL, suspended_until = NULL, restriction_reason = NULL WHERE id = $1`
+
+	if _, err := r.db.ExecContext(ctx, query, id); err != nil {
+		return fmt.Errorf("failed to lift restrictions: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UpdateRole(ctx context.Context, id int, role models.Role) error {
+	query := `UPDATE users SET role = $1 WHERE id = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, role, id); err != nil {
+		return fmt.Errorf("failed to update role: %w", err)
+	}
+
+	return nil
+}
+
+func (r *UserRepository) UpdateLastSeen(ctx context.Context, id int, at time.Time) error {
+	query := `UPDATE users SET last_seen_at = $1 WHERE id = $2`
+
+	if _, err := r.db.ExecContext(ctx, query, at, id); err != nil {
+		return fmt.Errorf("failed to update last seen: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	purposeVerifyEmail   = "verify_email"
+	purposeResetPassword = "reset_password"
+)
+
+// generateActionToken signs a short-lived token that authorizes a single account
+// action. The fingerprint binds the token to the state it was issued for, so a
+// verification token dies when the email changes and a reset token dies once
+// the password has been changed.
+func (s *AuthService) generateActionToken(user *models.User, purpose string, ttl time.Duration) (string, error) {
+	claims := jwt.MapClaims{
+		"user_id": user.ID,
+		"purpose": purpose,
+		"fp":      actionFingerprint(user, purpose),
+		"exp":     time.Now().Add(ttl).Unix(),
+		"iat":     time.Now().Unix(),
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(s.jwtSecret)
+}
+
+// parseActionToken validates the token for the given purpose and returns its user
+func (s *AuthService) parseActionToken(ctx context.Context, tokenString, purpose string) (*models.User, error) {
+	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, errors.New("invalid signing method")
+		}
+		return s.jwtSecret, nil
+	})
+	if err != nil || !token.Valid {
+		return nil, ErrInvalidToken
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || claims["purpose"] != purpose {
+		return nil, ErrInvalidToken
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, ErrInvalidToken
+	}
+
+	user, err := s.userRepo.GetByID(ctx, int(userID))
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || claims["fp"] != actionFingerprint(user, purpose) {
+		return nil, ErrInvalidToken
+	}
+
+	return user, nil
+}
+
+func actionFingerprint(user *models.User, purpose string) string {
+	var state string
+	switch purpose {
+	case purposeVerifyEmail:
+		state = user.Email
+	case purposeResetPassword:
+		state = user.PasswordHash
+	}
+
+	sum := sha256.Sum256([]byte(purpose + ":" + state))
+	return hex.EncodeToString(sum[:8])
+}
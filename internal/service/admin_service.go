@@ -0,0 +1,195 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/websocket"
+)
+
+var (
+	ErrCannotModerateSelf = errors.New("cannot change your own account")
+	// ErrInsufficientRole means the target's role is not below the actor's
+	ErrInsufficientRole = errors.New("insufficient role")
+)
+
+// AdminService manages accounts on behalf of moderators and admins. Every
+// change is written to the audit log.
+type AdminService struct {
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	statsRepo   *repository.StatsRepository
+	auditRepo   *repository.AuditRepository
+	hub         *websocket.Hub
+}
+
+func NewAdminService(
+	userRepo *repository.UserRepository,
+	sessionRepo *repository.SessionRepository,
+	statsRepo *repository.StatsRepository,
+	auditRepo *repository.AuditRepository,
+	hub *websocket.Hub,
+) *AdminService {
+	return &AdminService{
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		statsRepo:   statsRepo,
+		auditRepo:   auditRepo,
+		hub:         hub,
+	}
+}
+
+func (s *AdminService) ListUsers(ctx context.Context, filter models.UserFilter, limit, offset int) ([]models.User, error) {
+	return s.userRepo.List(ctx, filter, limit, offset)
+}
+
+// Suspend bars the user from logging in for the given duration and ends their
+// sessions and live connections
+func (s *AdminService) Suspend(ctx context.Context, actorID, userID int, duration time.Duration, reason string) (*models.User, error) {
+	user, err := s.target(ctx, actorID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.Suspend(ctx, user.ID, time.Now().Add(duration), reason); err != nil {
+		return nil, err
+	}
+	if err := s.signOut(ctx, user.ID, "account suspended"); err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, actorID, models.AuditActionUserSuspended, user.ID, map[string]any{
+		"duration": duration.String(),
+		"reason":   reason,
+	})
+	return s.userRepo.GetByID(ctx, user.ID)
+}
+
+// Ban bars the user from logging in until reinstated and ends their sessions
+// and live connections
+func (s *AdminService) Ban(ctx context.Context, actorID, userID int, reason string) (*models.User, error) {
+	user, err := s.target(ctx, actorID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.Ban(ctx, user.ID, reason); err != nil {
+		return nil, err
+	}
+	if err := s.signOut(ctx, user.ID, "account banned"); err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, actorID, models.AuditActionUserBanned, user.ID, map[string]any{"reason": reason})
+	return s.userRepo.GetByID(ctx, user.ID)
+}
+
+// Reinstate lifts any ban or suspension
+func (s *AdminService) Reinstate(ctx context.Context, actorID, userID int) (*models.User, error) {
+	user, err := s.target(ctx, actorID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := s.userRepo.LiftRestrictions(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, actorID, models.AuditActionUserReinstated, user.ID, nil)
+	return s.userRepo.GetByID(ctx, user.ID)
+}
+
+// SetRole changes the user's role. Tokens carry the role, so the user's
+// sessions are revoked and they sign in again to pick up the new one.
+func (s *AdminService) SetRole(ctx context.Context, actorID, userID int, role models.Role) (*models.User, error) {
+	user, err := s.target(ctx, actorID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user.Role == role {
+		return user, nil
+	}
+
+	if err := s.userRepo.UpdateRole(ctx, user.ID, role); err != nil {
+		return nil, err
+	}
+	if err := s.signOut(ctx, user.ID, "role changed"); err != nil {
+		return nil, err
+	}
+
+	s.audit(ctx, actorID, models.AuditActionUserRoleChanged, user.ID, map[string]any{
+		"from": user.Role,
+		"to":   role,
+	})
+	return s.userRepo.GetByID(ctx, user.ID)
+}
+
+// Stats returns the platform summary
+func (s *AdminService) Stats(ctx context.Context) (*models.PlatformStats, error) {
+	stats, err := s.statsRepo.Platform(ctx)
+	if err != nil {
+		return nil, err
+	}
+	stats.ConnectedUsers = len(s.hub.GetOnlineUsers())
+	return stats, nil
+}
+
+// target loads the user an action applies to. Only users with a lower role than
+// the actor can be changed, and never the actor themselves.
+func (s *AdminService) target(ctx context.Context, actorID, userID int) (*models.User, error) {
+	if actorID == userID {
+		return nil, ErrCannotModerateSelf
+	}
+
+	actor, err := s.userRepo.GetByID(ctx, actorID)
+	if err != nil {
+		return nil, err
+	}
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.DeletedAt.Valid {
+		return nil, ErrUserNotFound
+	}
+
+	if actor == nil || user.Role.Includes(actor.Role) {
+		return nil, ErrInsufficientRole
+	}
+	return user, nil
+}
+
+// signOut revokes every session of the user and closes their live connections
+func (s *AdminService) signOut(ctx context.Context, userID int, reason string) error {
+	if err := s.sessionRepo.RevokeAllForUser(ctx, userID); err != nil {
+		return err
+	}
+	s.hub.DisconnectUser(userID, reason)
+	return nil
+}
+
+func (s *AdminService) audit(ctx context.Context, actorID int, action models.AuditAction, userID int, metadata map[string]any) {
+	entry := &models.AuditEntry{
+		ActorID:    sql.NullInt64{Int64: int64(actorID), Valid: true},
+		Action:     action,
+		TargetType: sql.NullString{String: "user", Valid: true},
+		TargetID:   sql.NullInt64{Int64: int64(userID), Valid: true},
+	}
+	if metadata != nil {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to marshal audit metadata", "error", err)
+		}
+		entry.Metadata = data
+	}
+
+	if err := s.auditRepo.Create(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit entry", "action", action, "target_id", userID, "error", err)
+	}
+}
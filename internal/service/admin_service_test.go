@@ -0,0 +1,100 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/jmoiron/sqlx"
+)
+
+func newTestAdminService(t *testing.T) (*AdminService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	s := NewAdminService(
+		repository.NewUserRepository(sqlxDB),
+		repository.NewSessionRepository(sqlxDB),
+		repository.NewStatsRepository(sqlxDB),
+		repository.NewAuditRepository(sqlxDB),
+		nil,
+	)
+	return s, mock
+}
+
+func expectUser(mock sqlmock.Sqlmock, id int, role models.Role) {
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(id).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "username", "role"}).AddRow(id, "user", role))
+}
+
+func TestAdminActionRoleGating(t *testing.T) {
+	tests := []struct {
+		name       string
+		actorRole  models.Role
+		targetRole models.Role
+		want       error
+	}{
+		{"moderator on user", models.RoleModerator, models.RoleUser, nil},
+		{"moderator on moderator", models.RoleModerator, models.RoleModerator, ErrInsufficientRole},
+		{"moderator on admin", models.RoleModerator, models.RoleAdmin, ErrInsufficientRole},
+		{"admin on moderator", models.RoleAdmin, models.RoleModerator, nil},
+		{"admin on admin", models.RoleAdmin, models.RoleAdmin, ErrInsufficientRole},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestAdminService(t)
+			expectUser(mock, 1, tt.actorRole)
+			expectUser(mock, 2, tt.targetRole)
+
+			// target is the check every account action goes through
+			user, err := s.target(context.Background(), 1, 2)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("target = %v, want %v", err, tt.want)
+			}
+			if err == nil && user.ID != 2 {
+				t.Fatalf("target returned user %d", user.ID)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestAdminActionRejected(t *testing.T) {
+	s, mock := newTestAdminService(t)
+	ctx := context.Background()
+
+	// Nothing is loaded or changed for the actor's own account
+	if _, err := s.Suspend(ctx, 1, 1, time.Hour, ""); !errors.Is(err, ErrCannotModerateSelf) {
+		t.Fatalf("suspending yourself = %v, want ErrCannotModerateSelf", err)
+	}
+
+	// A moderator cannot act on an admin, and the ban never reaches the database
+	expectUser(mock, 1, models.RoleModerator)
+	expectUser(mock, 2, models.RoleAdmin)
+	if _, err := s.Ban(ctx, 1, 2, "spam"); !errors.Is(err, ErrInsufficientRole) {
+		t.Fatalf("moderator banning an admin = %v, want ErrInsufficientRole", err)
+	}
+
+	expectUser(mock, 1, models.RoleAdmin)
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(3).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if _, err := s.SetRole(ctx, 1, 3, models.RoleModerator); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("changing a missing user = %v, want ErrUserNotFound", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -1,35 +1,91 @@
 package service
 
 import (
+	"context"
+	"crypto/rand"
 	"database/sql"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"log/slog"
+	"net/url"
 	"time"
 
+	"github.com/HaykAghajanyan/chat-backend/internal/mailer"
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
 	"github.com/HaykAghajanyan/chat-backend/internal/repository"
 	"github.com/golang-jwt/jwt/v5"
 	"golang.org/x/crypto/bcrypt"
 )
 
+const (
+	sessionTTL           = 2 * time.Hour
+	emailVerificationTTL = 48 * time.Hour
+	passwordResetTTL     = time.Hour
+	mailSendTimeout      = 30 * time.Second
+)
+
 var (
 	ErrUserExists         = errors.New("user already exists")
 	ErrInvalidCredentials = errors.New("invalid credentials")
+	ErrInvalidToken       = errors.New("invalid or expired token")
 )
 
+// AccountSuspendedError is returned for a suspended or banned account when it
+// logs in or opens a WebSocket
+type AccountSuspendedError struct {
+	// Until is zero for a ban
+	Until  time.Time
+	Reason string
+}
+
+func (e *AccountSuspendedError) Banned() bool {
+	return e.Until.IsZero()
+}
+
+func (e *AccountSuspendedError) Error() string {
+	if e.Banned() {
+		return "account banned"
+	}
+	return fmt.Sprintf("account suspended until %s", e.Until.Format(time.RFC3339))
+}
+
+// Claims are the authenticated identity carried by an access token
+type Claims struct {
+	UserID    int
+	SessionID string
+	Role      models.Role
+}
+
 type AuthService struct {
-	userRepo  *repository.UserRepository
-	jwtSecret []byte
+	userRepo    *repository.UserRepository
+	sessionRepo *repository.SessionRepository
+	loginGuard  *LoginGuard
+	mailer      mailer.Mailer
+	jwtSecret   []byte
+	appBaseURL  string
 }
 
-func NewAuthService(userRepo *repository.UserRepository, jwtSecret string) *AuthService {
+func NewAuthService(
+	userRepo *repository.UserRepository,
+	sessionRepo *repository.SessionRepository,
+	loginGuard *LoginGuard,
+	mailer mailer.Mailer,
+	jwtSecret string,
+	appBaseURL string,
+) *AuthService {
 	return &AuthService{
-		userRepo:  userRepo,
-		jwtSecret: []byte(jwtSecret),
+		userRepo:    userRepo,
+		sessionRepo: sessionRepo,
+		loginGuard:  loginGuard,
+		mailer:      mailer,
+		jwtSecret:   []byte(jwtSecret),
+		appBaseURL:  appBaseURL,
 	}
 }
 
-func (s *AuthService) Register(req models.RegisterRequest) (*models.AuthResponse, error) {
-	existingUser, err := s.userRepo.GetByEmail(req.Email)
+func (s *AuthService) Register(ctx context.Context, req models.RegisterRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	existingUser, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
 		return nil, err
 	}
@@ -37,7 +93,7 @@ func (s *AuthService) Register(req models.RegisterRequest) (*models.AuthResponse
 		return nil, ErrUserExists
 	}
 
-	existingUser, err = s.userRepo.GetByUsername(req.Username)
+	existingUser, err = s.userRepo.GetByUsername(ctx, req.Username)
 	if err != nil {
 		return nil, err
 	}
@@ -54,41 +110,59 @@ func (s *AuthService) Register(req models.RegisterRequest) (*models.AuthResponse
 		Username:     req.Username,
 		Email:        req.Email,
 		PasswordHash: string(hashedPassword),
+		Role:         models.RoleUser,
 	}
 
 	if req.DisplayName != "" {
 		user.DisplayName = sql.NullString{String: req.DisplayName, Valid: true}
 	}
 
-	if err := s.userRepo.Create(user); err != nil {
+	if err := s.userRepo.Create(ctx, user); err != nil {
 		return nil, err
 	}
 
-	token, err := s.generateToken(user.ID)
+	token, err := s.createSession(ctx, user, client)
 	if err != nil {
 		return nil, err
 	}
 
+	s.sendVerificationEmail(ctx, user)
+
 	return &models.AuthResponse{
 		Token: token,
 		User:  *user,
 	}, nil
 }
 
-func (s *AuthService) Login(req models.LoginRequest) (*models.AuthResponse, error) {
-	user, err := s.userRepo.GetByEmail(req.Email)
+// Login returns a *LoginThrottledError when the email or client IP has too many
+// recent failed attempts
+func (s *AuthService) Login(ctx context.Context, req models.LoginRequest, client models.ClientInfo) (*models.AuthResponse, error) {
+	if err := s.loginGuard.Check(ctx, req.Email, client.IPAddress); err != nil {
+		return nil, err
+	}
+
+	user, err := s.userRepo.GetByEmail(ctx, req.Email)
 	if err != nil {
 		return nil, err
 	}
 	if user == nil {
+		s.loginGuard.RecordFailure(ctx, req.Email, client.IPAddress)
 		return nil, ErrInvalidCredentials
 	}
 	err = bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.Password))
 	if err != nil {
+		s.loginGuard.RecordFailure(ctx, req.Email, client.IPAddress)
 		return nil, ErrInvalidCredentials
 	}
 
-	token, err := s.generateToken(user.ID)
+	s.loginGuard.RecordSuccess(ctx, req.Email)
+
+	// Only revealed after the password matched
+	if err := checkRestrictions(user); err != nil {
+		return nil, err
+	}
+
+	token, err := s.createSession(ctx, user, client)
 	if err != nil {
 		return nil, err
 	}
@@ -99,10 +173,183 @@ func (s *AuthService) Login(req models.LoginRequest) (*models.AuthResponse, erro
 	}, nil
 }
 
-func (s *AuthService) generateToken(userID int) (string, error) {
+// ResendVerification sends a fresh verification email if the user is not verified yet
+func (s *AuthService) ResendVerification(ctx context.Context, userID int) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrInvalidCredentials
+	}
+	if user.EmailVerifiedAt.Valid {
+		return nil
+	}
+
+	s.sendVerificationEmail(ctx, user)
+	return nil
+}
+
+func (s *AuthService) VerifyEmail(ctx context.Context, token string) error {
+	user, err := s.parseActionToken(ctx, token, purposeVerifyEmail)
+	if err != nil {
+		return err
+	}
+
+	return s.userRepo.MarkEmailVerified(ctx, user.ID)
+}
+
+// ForgotPassword emails a reset link if an account exists for the address.
+// It never reports whether the account exists.
+func (s *AuthService) ForgotPassword(ctx context.Context, email string) error {
+	user, err := s.userRepo.GetByEmail(ctx, email)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return nil
+	}
+
+	token, err := s.generateActionToken(user, purposeResetPassword, passwordResetTTL)
+	if err != nil {
+		return err
+	}
+
+	s.sendMail(ctx, user, "reset_password", s.actionLink("/reset-password", token), passwordResetTTL)
+	return nil
+}
+
+// ResetPassword sets a new password and revokes every existing session of the user
+func (s *AuthService) ResetPassword(ctx context.Context, token, password string) error {
+	user, err := s.parseActionToken(ctx, token, purposeResetPassword)
+	if err != nil {
+		return err
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, string(hashedPassword)); err != nil {
+		return err
+	}
+
+	return s.sessionRepo.RevokeAllForUser(ctx, user.ID)
+}
+
+func (s *AuthService) sendVerificationEmail(ctx context.Context, user *models.User) {
+	token, err := s.generateActionToken(user, purposeVerifyEmail, emailVerificationTTL)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to generate verification token", "user_id", user.ID, "error", err)
+		return
+	}
+
+	s.sendMail(ctx, user, "verify_email", s.actionLink("/verify-email", token), emailVerificationTTL)
+}
+
+// sendMail renders and delivers a templated email in the background so the
+// response time does not depend on the mail relay
+func (s *AuthService) sendMail(ctx context.Context, user *models.User, template, link string, ttl time.Duration) {
+	name := user.Username
+	if user.DisplayName.Valid {
+		name = user.DisplayName.String
+	}
+
+	msg, err := mailer.Compose(user.Email, template, map[string]any{
+		"Name":      name,
+		"Link":      link,
+		"ExpiresIn": humanizeDuration(ttl),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to compose email", "template", template, "error", err)
+		return
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), mailSendTimeout)
+		defer cancel()
+
+		if err := s.mailer.Send(ctx, msg); err != nil {
+			slog.ErrorContext(ctx, "failed to send email", "template", template, "user_id", user.ID, "error", err)
+		}
+	}()
+}
+
+// humanizeDuration formats whole hours and minutes for email copy ("48 hours")
+func humanizeDuration(d time.Duration) string {
+	switch {
+	case d >= time.Hour && d%time.Hour == 0:
+		return pluralize(int64(d/time.Hour), "hour")
+	case d >= time.Minute && d%time.Minute == 0:
+		return pluralize(int64(d/time.Minute), "minute")
+	default:
+		return d.String()
+	}
+}
+
+// pluralize formats a count of unit, singular for one ("1 minute")
+func pluralize(n int64, unit string) string {
+	if n == 1 {
+		return "1 " + unit
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func (s *AuthService) actionLink(path, token string) string {
+	return s.appBaseURL + path + "?token=" + url.QueryEscape(token)
+}
+
+// CheckAccount returns an *AccountSuspendedError if the user may not connect
+func (s *AuthService) CheckAccount(ctx context.Context, userID int) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.DeletedAt.Valid {
+		return ErrInvalidCredentials
+	}
+	return checkRestrictions(user)
+}
+
+func checkRestrictions(user *models.User) error {
+	switch {
+	case user.IsBanned():
+		return &AccountSuspendedError{Reason: user.RestrictionReason.String}
+	case user.IsSuspended():
+		return &AccountSuspendedError{Until: user.SuspendedUntil.Time, Reason: user.RestrictionReason.String}
+	}
+	return nil
+}
+
+func (s *AuthService) createSession(ctx context.Context, user *models.User, client models.ClientInfo) (string, error) {
+	id, err := newSessionID()
+	if err != nil {
+		return "", err
+	}
+
+	session := &models.Session{
+		ID:        id,
+		UserID:    user.ID,
+		UserAgent: sql.NullString{String: client.UserAgent, Valid: client.UserAgent != ""},
+		IPAddress: sql.NullString{String: client.IPAddress, Valid: client.IPAddress != ""},
+		ExpiresAt: time.Now().Add(sessionTTL),
+	}
+	if err := s.sessionRepo.Create(ctx, session); err != nil {
+		return "", err
+	}
+
+	return s.generateToken(user, session.ID, session.ExpiresAt)
+}
+
+// generateToken signs an access token. The role claim is trusted for the life of
+// the token, so changing a user's role revokes their sessions.
+func (s *AuthService) generateToken(user *models.User, sessionID string, expiresAt time.Time) (string, error) {
 	claims := jwt.MapClaims{
-		"user_id": userID,
-		"exp":     time.Now().Add(time.Hour * 2).Unix(),
+		"user_id": user.ID,
+		"sid":     sessionID,
+		"role":    string(user.Role),
+		"exp":     expiresAt.Unix(),
 		"iat":     time.Now().Unix(),
 	}
 
@@ -110,7 +357,7 @@ func (s *AuthService) generateToken(userID int) (string, error) {
 	return token.SignedString(s.jwtSecret)
 }
 
-func (s *AuthService) ValidateToken(tokenString string) (int, error) {
+func (s *AuthService) ValidateToken(ctx context.Context, tokenString string) (*Claims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, errors.New("invalid signing method")
@@ -119,13 +366,44 @@ func (s *AuthService) ValidateToken(tokenString string) (int, error) {
 	})
 
 	if err != nil {
-		return 0, err
+		return nil, err
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, errors.New("invalid token")
+	}
+
+	userID, ok := claims["user_id"].(float64)
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	sessionID, ok := claims["sid"].(string)
+	if !ok || sessionID == "" {
+		return nil, errors.New("invalid token")
+	}
+
+	session, err := s.sessionRepo.GetByID(ctx, sessionID)
+	if err != nil {
+		return nil, err
+	}
+	if session == nil || session.UserID != int(userID) || !session.IsActive() {
+		return nil, errors.New("session revoked")
 	}
 
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		userID := int(claims["user_id"].(float64))
-		return userID, nil
+	// Tokens issued before roles existed carry no role claim
+	role := models.RoleUser
+	if r, ok := claims["role"].(string); ok && models.Role(r).Valid() {
+		role = models.Role(r)
 	}
 
-	return 0, errors.New("invalid token")
+	return &Claims{UserID: int(userID), SessionID: sessionID, Role: role}, nil
+}
+
+func newSessionID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
 }
@@ -0,0 +1,203 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/mailer"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/jmoiron/sqlx"
+)
+
+// recordingMailer hands every message it is asked to send to the test
+type recordingMailer chan mailer.Message
+
+func (m recordingMailer) Send(ctx context.Context, msg mailer.Message) error {
+	m <- msg
+	return nil
+}
+
+func newTestAuthService(t *testing.T) (*AuthService, sqlmock.Sqlmock, recordingMailer) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	mail := make(recordingMailer, 1)
+	s := NewAuthService(
+		repository.NewUserRepository(sqlxDB),
+		repository.NewSessionRepository(sqlxDB),
+		nil,
+		mail,
+		"test-secret",
+		"https://chat.example.com",
+	)
+	return s, mock, mail
+}
+
+// userRows returns the users row for user
+func userRows(user *models.User) *sqlmock.Rows {
+	return sqlmock.NewRows([]string{"id", "username", "email", "password_hash", "email_verified_at"}).
+		AddRow(user.ID, user.Username, user.Email, user.PasswordHash, user.EmailVerifiedAt)
+}
+
+var linkToken = regexp.MustCompile(`\?token=(\S+)`)
+
+// mailedToken waits for an email and returns the token of its link
+func mailedToken(t *testing.T, mail recordingMailer) (mailer.Message, string) {
+	t.Helper()
+
+	select {
+	case msg := <-mail:
+		m := linkToken.FindStringSubmatch(msg.TextBody)
+		if m == nil {
+			t.Fatalf("no link in %q", msg.TextBody)
+		}
+		token, err := url.QueryUnescape(m[1])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return msg, token
+	case <-time.After(2 * time.Second):
+		t.Fatal("no email sent")
+		return mailer.Message{}, ""
+	}
+}
+
+func TestPasswordResetToken(t *testing.T) {
+	s, mock, mail := newTestAuthService(t)
+	ctx := context.Background()
+	user := &models.User{ID: 7, Username: "ann", Email: "ann@example.com", PasswordHash: "old-hash"}
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE email").WithArgs(user.Email).WillReturnRows(userRows(user))
+	if err := s.ForgotPassword(ctx, user.Email); err != nil {
+		t.Fatal(err)
+	}
+	msg, token := mailedToken(t, mail)
+	if msg.To != user.Email || !strings.Contains(msg.TextBody, "expires in 1 hour.") {
+		t.Fatalf("email = %+v", msg)
+	}
+
+	// A reset token is not a verification token
+	if err := s.VerifyEmail(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyEmail with a reset token = %v, want ErrInvalidToken", err)
+	}
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(user.ID).WillReturnRows(userRows(user))
+	mock.ExpectExec("UPDATE users SET password_hash").WithArgs(sqlmock.AnyArg(), user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("UPDATE sessions").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 2))
+	if err := s.ResetPassword(ctx, token, "new password"); err != nil {
+		t.Fatalf("ResetPassword = %v", err)
+	}
+
+	// The password changed, so the same token no longer matches the account
+	changed := *user
+	changed.PasswordHash = "new-hash"
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(user.ID).WillReturnRows(userRows(&changed))
+	if err := s.ResetPassword(ctx, token, "another password"); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("reusing the token = %v, want ErrInvalidToken", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestForgotPasswordUnknownEmail(t *testing.T) {
+	s, mock, mail := newTestAuthService(t)
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE email").WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if err := s.ForgotPassword(context.Background(), "nobody@example.com"); err != nil {
+		t.Fatalf("ForgotPassword = %v, want no error so accounts cannot be probed", err)
+	}
+	select {
+	case msg := <-mail:
+		t.Fatalf("email sent to %s", msg.To)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestVerifyEmailToken(t *testing.T) {
+	s, mock, mail := newTestAuthService(t)
+	ctx := context.Background()
+	user := &models.User{ID: 3, Username: "bob", Email: "bob@example.com"}
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(user.ID).WillReturnRows(userRows(user))
+	if err := s.ResendVerification(ctx, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	msg, token := mailedToken(t, mail)
+	if !strings.Contains(msg.TextBody, "48 hours") {
+		t.Fatalf("email = %q, want the link lifetime", msg.TextBody)
+	}
+
+	// The email was changed after the link was sent
+	moved := *user
+	moved.Email = "robert@example.com"
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(user.ID).WillReturnRows(userRows(&moved))
+	if err := s.VerifyEmail(ctx, token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("VerifyEmail after an email change = %v, want ErrInvalidToken", err)
+	}
+
+	mock.ExpectQuery("SELECT \\* FROM users WHERE id").WithArgs(user.ID).WillReturnRows(userRows(user))
+	mock.ExpectExec("UPDATE users").WithArgs(user.ID).WillReturnResult(sqlmock.NewResult(0, 1))
+	if err := s.VerifyEmail(ctx, token); err != nil {
+		t.Fatalf("VerifyEmail = %v", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestActionTokenRejected(t *testing.T) {
+	s, _, _ := newTestAuthService(t)
+	other, _, _ := newTestAuthService(t)
+	other.jwtSecret = []byte("another secret")
+	user := &models.User{ID: 1, Email: "a@example.com"}
+
+	expired, err := s.generateActionToken(user, purposeVerifyEmail, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, err := other.generateActionToken(user, purposeVerifyEmail, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// None of these reach the database
+	for name, token := range map[string]string{"expired": expired, "forged": forged, "garbage": "not-a-token"} {
+		if err := s.VerifyEmail(context.Background(), token); !errors.Is(err, ErrInvalidToken) {
+			t.Errorf("%s token: VerifyEmail = %v, want ErrInvalidToken", name, err)
+		}
+	}
+}
+
+func TestHumanizeDuration(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want string
+	}{
+		{time.Hour, "1 hour"},
+		{48 * time.Hour, "48 hours"},
+		{time.Minute, "1 minute"},
+		{90 * time.Minute, "90 minutes"},
+		{90 * time.Second, "1m30s"},
+	}
+	for _, tt := range tests {
+		if got := humanizeDuration(tt.d); got != tt.want {
+			t.Errorf("humanizeDuration(%v) = %q, want %q", tt.d, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,548 @@
+package service
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/logging"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/storage"
+	"github.com/HaykAghajanyan/chat-backend/internal/websocket"
+)
+
+const (
+	// How long a finished export can be downloaded
+	exportRetention = 72 * time.Hour
+
+	exportWorkers     = 2
+	exportQueueSize   = 100
+	exportSweepPeriod = time.Minute
+
+	// An export processing for longer than this is assumed abandoned by a
+	// node that died, and is retried up to exportMaxAttempts times in total
+	exportJobTimeout  = 15 * time.Minute
+	exportMaxAttempts = 3
+)
+
+var (
+	ErrExportNotFound   = errors.New("export not found")
+	ErrExportInProgress = repository.ErrExportActive
+	ErrExportNotReady   = errors.New("export is not ready")
+)
+
+//go:embed templates/data_export.html
+var exportTemplateFS embed.FS
+
+var exportTemplate = template.Must(
+	template.New("data_export.html").
+		Funcs(template.FuncMap{"datetime": formatExportTime}).
+		ParseFS(exportTemplateFS, "templates/data_export.html"),
+)
+
+// ExportService builds data-subject exports in the background. Jobs are persisted
+// in data_exports so any node can pick up a pending job.
+type ExportService struct {
+	exportRepo  *repository.ExportRepository
+	userRepo    *repository.UserRepository
+	messageRepo *repository.MessageRepository
+	sessionRepo *repository.SessionRepository
+	uploads     *storage.Local
+	exports     *storage.Local
+	hub         *websocket.Hub
+	queue       chan int
+	wg          sync.WaitGroup
+}
+
+func NewExportService(
+	exportRepo *repository.ExportRepository,
+	userRepo *repository.UserRepository,
+	messageRepo *repository.MessageRepository,
+	sessionRepo *repository.SessionRepository,
+	uploads *storage.Local,
+	exports *storage.Local,
+	hub *websocket.Hub,
+) *ExportService {
+	return &ExportService{
+		exportRepo:  exportRepo,
+		userRepo:    userRepo,
+		messageRepo: messageRepo,
+		sessionRepo: sessionRepo,
+		uploads:     uploads,
+		exports:     exports,
+		hub:         hub,
+		queue:       make(chan int, exportQueueSize),
+	}
+}
+
+// Start runs the export workers and the sweeper that picks up pending jobs and
+// removes expired archives. It returns immediately; cancel ctx and call Wait
+// to stop them.
+func (s *ExportService) Start(ctx context.Context) {
+	for i := 0; i < exportWorkers; i++ {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.worker(ctx)
+		}()
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.sweeper(ctx)
+	}()
+}
+
+// Wait blocks until the goroutines started by Start have returned. A job that
+// is being built when ctx is cancelled is finished first.
+func (s *ExportService) Wait() {
+	s.wg.Wait()
+}
+
+// Request queues a new export for the user. The check is repeated by the
+// database when the job is created, so concurrent requests cannot both succeed.
+func (s *ExportService) Request(ctx context.Context, userID int) (*models.DataExport, error) {
+	active, err := s.exportRepo.HasActive(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if active {
+		return nil, ErrExportInProgress
+	}
+
+	export := &models.DataExport{
+		UserID: userID,
+		Status: models.DataExportStatusPending,
+	}
+	if err := s.exportRepo.Create(ctx, export); err != nil {
+		return nil, err
+	}
+
+	s.enqueue(export.ID)
+	return export, nil
+}
+
+func (s *ExportService) List(ctx context.Context, userID int) ([]models.DataExport, error) {
+	return s.exportRepo.ListByUser(ctx, userID)
+}
+
+// Get returns the user's export, hiding exports that belong to someone else
+func (s *ExportService) Get(ctx context.Context, userID, exportID int) (*models.DataExport, error) {
+	export, err := s.exportRepo.GetByID(ctx, exportID)
+	if err != nil {
+		return nil, err
+	}
+	if export == nil || export.UserID != userID {
+		return nil, ErrExportNotFound
+	}
+	return export, nil
+}
+
+// Open returns the archive of a ready export. The caller must close the file.
+func (s *ExportService) Open(ctx context.Context, userID, exportID int) (*os.File, *models.DataExport, error) {
+	export, err := s.Get(ctx, userID, exportID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if export.Status != models.DataExportStatusReady || !export.StorageKey.Valid ||
+		(export.ExpiresAt.Valid && time.Now().After(export.ExpiresAt.Time)) {
+		return nil, nil, ErrExportNotReady
+	}
+
+	file, err := s.exports.Open(export.StorageKey.String)
+	if err != nil {
+		return nil, nil, err
+	}
+	return file, export, nil
+}
+
+func (s *ExportService) enqueue(id int) {
+	select {
+	case s.queue <- id:
+	default:
+		// Queue is full; the sweeper will pick the job up from the database
+	}
+}
+
+func (s *ExportService) worker(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case id := <-s.queue:
+			s.process(ctx, id)
+		}
+	}
+}
+
+func (s *ExportService) sweeper(ctx context.Context) {
+	ticker := time.NewTicker(exportSweepPeriod)
+	defer ticker.Stop()
+
+	for {
+		s.sweep(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *ExportService) sweep(ctx context.Context) {
+	reclaimed, failed, err := s.exportRepo.ReclaimStale(ctx, exportJobTimeout, exportMaxAttempts)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to reclaim stale exports", "error", err)
+	}
+	if reclaimed > 0 || failed > 0 {
+		slog.WarnContext(ctx, "reclaimed stale exports", "requeued", reclaimed, "failed", failed)
+	}
+
+	ids, err := s.exportRepo.GetPendingIDs(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load pending exports", "error", err)
+	}
+	for _, id := range ids {
+		s.enqueue(id)
+	}
+
+	expired, err := s.exportRepo.ExpireDue(ctx)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to expire exports", "error", err)
+		return
+	}
+	for _, export := range expired {
+		if !export.StorageKey.Valid {
+			continue
+		}
+		if err := s.exports.Delete(export.StorageKey.String); err != nil {
+			slog.ErrorContext(ctx, "failed to delete expired export", "export_id", export.ID, "error", err)
+		}
+	}
+}
+
+func (s *ExportService) process(ctx context.Context, id int) {
+	// A job that was claimed is finished even if the worker is stopped meanwhile
+	ctx = logging.With(context.WithoutCancel(ctx), "export_id", id)
+
+	export, err := s.exportRepo.Claim(ctx, id)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to claim export", "error", err)
+		return
+	}
+	if export == nil {
+		return
+	}
+
+	key, err := s.build(ctx, export)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to build export", "error", err)
+		if err := s.exportRepo.MarkFailed(ctx, export, err.Error()); err != nil {
+			slog.ErrorContext(ctx, "failed to mark export failed", "error", err)
+		}
+		return
+	}
+
+	if err := s.exportRepo.MarkReady(ctx, export, key, time.Now().Add(exportRetention)); err != nil {
+		slog.ErrorContext(ctx, "failed to mark export ready", "error", err)
+		s.exports.Delete(key)
+		return
+	}
+
+	s.notifyReady(ctx, export)
+}
+
+func (s *ExportService) notifyReady(ctx context.Context, export *models.DataExport) {
+	outgoing := models.WSOutgoingMessage{
+		Type:      models.WSMessageTypeExportReady,
+		Export:    export,
+		Timestamp: time.Now(),
+	}
+
+	data, err := json.Marshal(outgoing)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal export notification", "error", err)
+		return
+	}
+
+	s.hub.SendToUser(ctx, export.UserID, data)
+}
+
+type exportProfile struct {
+	ID              int        `json:"id"`
+	Username        string     `json:"username"`
+	Email           string     `json:"email"`
+	DisplayName     string     `json:"display_name,omitempty"`
+	Bio             string     `json:"bio,omitempty"`
+	StatusText      string     `json:"status_text,omitempty"`
+	Avatar          string     `json:"avatar,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+type exportSession struct {
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	IPAddress string     `json:"ip_address,omitempty"`
+	UserAgent string     `json:"user_agent,omitempty"`
+}
+
+type exportContact struct {
+	ID       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type exportMessage struct {
+	ID        int       `json:"id"`
+	Outgoing  bool      `json:"outgoing"`
+	Content   string    `json:"content"`
+	IsRead    bool      `json:"is_read"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type exportConversation struct {
+	With     exportContact   `json:"with"`
+	Messages []exportMessage `json:"messages"`
+}
+
+type exportDocument struct {
+	GeneratedAt   time.Time
+	Profile       exportProfile
+	Sessions      []exportSession
+	Conversations []exportConversation
+}
+
+// build collects the user's data and writes the archive to export storage,
+// returning its storage key
+func (s *ExportService) build(ctx context.Context, export *models.DataExport) (string, error) {
+	doc, avatarKey, err := s.collect(ctx, export.UserID)
+	if err != nil {
+		return "", err
+	}
+
+	tmp, err := os.CreateTemp("", "export-*.zip")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	archive := zip.NewWriter(tmp)
+
+	files := []struct {
+		name  string
+		value any
+	}{
+		{"profile.json", doc.Profile},
+		{"sessions.json", doc.Sessions},
+		{"conversations.json", doc.Conversations},
+	}
+	for _, f := range files {
+		w, err := archive.Create(f.name)
+		if err != nil {
+			return "", err
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(f.value); err != nil {
+			return "", fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+
+	w, err := archive.Create("index.html")
+	if err != nil {
+		return "", err
+	}
+	if err := exportTemplate.Execute(w, doc); err != nil {
+		return "", fmt.Errorf("failed to render index.html: %w", err)
+	}
+
+	if avatarKey != "" {
+		if err := copyAttachment(archive, s.uploads, avatarKey, doc.Profile.Avatar); err != nil {
+			return "", err
+		}
+	}
+
+	if err := archive.Close(); err != nil {
+		return "", err
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	suffix := make([]byte, 16)
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	key := fmt.Sprintf("%d/%s.zip", export.UserID, hex.EncodeToString(suffix))
+
+	if _, err := s.exports.Put(key, tmp); err != nil {
+		return "", err
+	}
+	return key, nil
+}
+
+func (s *ExportService) collect(ctx context.Context, userID int) (*exportDocument, string, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	if user == nil {
+		return nil, "", ErrUserNotFound
+	}
+
+	doc := &exportDocument{
+		GeneratedAt: time.Now(),
+		Profile: exportProfile{
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			DisplayName: user.DisplayName.String,
+			Bio:         user.Bio.String,
+			StatusText:  user.StatusText.String,
+			CreatedAt:   user.CreatedAt,
+			UpdatedAt:   user.UpdatedAt,
+		},
+	}
+	if user.EmailVerifiedAt.Valid {
+		doc.Profile.EmailVerifiedAt = &user.EmailVerifiedAt.Time
+	}
+
+	var avatarKey string
+	if user.AvatarURL.Valid {
+		if key, ok := s.uploads.KeyFromURL(user.AvatarURL.String); ok {
+			avatarKey = key
+			doc.Profile.Avatar = path.Join("attachments", path.Base(key))
+		}
+	}
+
+	sessions, err := s.sessionRepo.ListByUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+	doc.Sessions = make([]exportSession, 0, len(sessions))
+	for _, session := range sessions {
+		entry := exportSession{
+			CreatedAt: session.CreatedAt,
+			ExpiresAt: session.ExpiresAt,
+			IPAddress: session.IPAddress.String,
+			UserAgent: session.UserAgent.String,
+		}
+		if session.RevokedAt.Valid {
+			entry.RevokedAt = &session.RevokedAt.Time
+		}
+		doc.Sessions = append(doc.Sessions, entry)
+	}
+
+	messages, err := s.messageRepo.GetAllForUser(ctx, userID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	usernames, err := s.contactUsernames(ctx, userID, messages)
+	if err != nil {
+		return nil, "", err
+	}
+
+	byContact := make(map[int]int)
+	for _, message := range messages {
+		otherID := message.SenderID
+		if otherID == userID {
+			otherID = message.RecipientID
+		}
+
+		idx, ok := byContact[otherID]
+		if !ok {
+			contact := exportContact{ID: otherID, Username: usernames[otherID]}
+			idx = len(doc.Conversations)
+			byContact[otherID] = idx
+			doc.Conversations = append(doc.Conversations, exportConversation{With: contact})
+		}
+
+		doc.Conversations[idx].Messages = append(doc.Conversations[idx].Messages, exportMessage{
+			ID:        message.ID,
+			Outgoing:  message.SenderID == userID,
+			Content:   message.Content,
+			IsRead:    message.IsRead,
+			CreatedAt: message.CreatedAt,
+		})
+	}
+	if doc.Conversations == nil {
+		doc.Conversations = []exportConversation{}
+	}
+
+	return doc, avatarKey, nil
+}
+
+// contactUsernames loads the usernames of everyone the messages were exchanged with
+func (s *ExportService) contactUsernames(ctx context.Context, userID int, messages []models.Message) (map[int]string, error) {
+	seen := make(map[int]bool)
+	var ids []int
+	for _, message := range messages {
+		for _, id := range []int{message.SenderID, message.RecipientID} {
+			if id != userID && !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+
+	users, err := s.userRepo.GetByIDs(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	usernames := make(map[int]string, len(users))
+	for _, user := range users {
+		usernames[user.ID] = user.Username
+	}
+	return usernames, nil
+}
+
+func copyAttachment(archive *zip.Writer, store *storage.Local, key, name string) error {
+	src, err := store.Open(key)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, src)
+	return err
+}
+
+func formatExportTime(v any) string {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC().Format("2006-01-02 15:04:05 UTC")
+	case *time.Time:
+		if t == nil {
+			return ""
+		}
+		return t.UTC().Format("2006-01-02 15:04:05 UTC")
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,229 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Failed attempts are counted over a sliding window of this length
+	loginAttemptWindow = 15 * time.Minute
+
+	// After this many failures each further attempt has to wait, doubling from loginBaseDelay
+	loginDelayThreshold = 3
+	loginBaseDelay      = time.Second
+	loginMaxDelay       = 30 * time.Second
+
+	// Failures that trigger a temporary lockout, per email and per IP address
+	loginEmailLockThreshold = 10
+	loginIPLockThreshold    = 50
+	loginLockoutDuration    = 15 * time.Minute
+)
+
+// LoginThrottledError is returned when a login attempt is refused before the
+// credentials are checked
+type LoginThrottledError struct {
+	RetryAfter time.Duration
+	Locked     bool
+}
+
+func (e *LoginThrottledError) Error() string {
+	if e.Locked {
+		return fmt.Sprintf("login locked, retry after %s", e.RetryAfter)
+	}
+	return fmt.Sprintf("too many login attempts, retry after %s", e.RetryAfter)
+}
+
+// LoginGuard keeps Redis-backed sliding-window counters of failed logins keyed by
+// email and by IP address. Redis failures never block a login.
+//
+// The IP address must be the one established by middleware.RealIP, never a
+// header the client controls, or the IP lockout can be sidestepped. IPv6
+// addresses are counted per /64 since a single host usually has a whole /64.
+type LoginGuard struct {
+	redis     *redis.Client
+	auditRepo *repository.AuditRepository
+	now       func() time.Time
+}
+
+func NewLoginGuard(client *redis.Client, auditRepo *repository.AuditRepository) *LoginGuard {
+	return &LoginGuard{
+		redis:     client,
+		auditRepo: auditRepo,
+		now:       time.Now,
+	}
+}
+
+// Check returns a *LoginThrottledError if the email or IP is locked out or has to
+// wait before the next attempt
+func (g *LoginGuard) Check(ctx context.Context, email, ip string) error {
+	for _, key := range []string{lockKey("email", email), lockKey("ip", ipScope(ip))} {
+		ttl, err := g.redis.TTL(ctx, key).Result()
+		if err != nil {
+			slog.ErrorContext(ctx, "login guard: failed to read lock", "key", key, "error", err)
+			continue
+		}
+		if ttl > 0 {
+			return &LoginThrottledError{RetryAfter: ttl, Locked: true}
+		}
+	}
+
+	now := g.now()
+	key := failuresKey("email", email)
+	failures, last, err := g.failures(ctx, key, now)
+	if err != nil {
+		slog.ErrorContext(ctx, "login guard: failed to read failures", "key", key, "error", err)
+		return nil
+	}
+
+	if delay := progressiveDelay(failures); delay > 0 {
+		if wait := last.Add(delay).Sub(now); wait > 0 {
+			return &LoginThrottledError{RetryAfter: wait}
+		}
+	}
+
+	return nil
+}
+
+// RecordFailure counts a failed attempt and locks the email or IP out once its
+// threshold is crossed
+func (g *LoginGuard) RecordFailure(ctx context.Context, email, ip string) {
+	now := g.now()
+
+	scopes := []struct {
+		scope     string
+		value     string
+		threshold int
+	}{
+		{"email", email, loginEmailLockThreshold},
+		{"ip", ipScope(ip), loginIPLockThreshold},
+	}
+
+	for _, s := range scopes {
+		if s.value == "" {
+			continue
+		}
+
+		key := failuresKey(s.scope, s.value)
+		member := strconv.FormatInt(now.UnixNano(), 10) + ":" + strconv.FormatUint(rand.Uint64(), 36)
+
+		pipe := g.redis.TxPipeline()
+		pipe.ZAdd(ctx, key, redis.Z{Score: float64(now.UnixNano()), Member: member})
+		pipe.Expire(ctx, key, loginAttemptWindow)
+		if _, err := pipe.Exec(ctx); err != nil {
+			slog.ErrorContext(ctx, "login guard: failed to record failure", "key", key, "error", err)
+			continue
+		}
+
+		failures, _, err := g.failures(ctx, key, now)
+		if err != nil {
+			slog.ErrorContext(ctx, "login guard: failed to read failures", "key", key, "error", err)
+			continue
+		}
+		if failures < s.threshold {
+			continue
+		}
+
+		locked, err := g.redis.SetNX(ctx, lockKey(s.scope, s.value), now.Unix(), loginLockoutDuration).Result()
+		if err != nil {
+			slog.ErrorContext(ctx, "login guard: failed to set lock", "key", key, "error", err)
+			continue
+		}
+		if locked {
+			g.auditLockout(ctx, s.scope, email, ip, failures)
+		}
+	}
+}
+
+// RecordSuccess clears the failure history of the email. IP counters are kept so
+// a valid account cannot be used to reset an attacker's budget.
+func (g *LoginGuard) RecordSuccess(ctx context.Context, email string) {
+	if err := g.redis.Del(ctx, failuresKey("email", email)).Err(); err != nil {
+		slog.ErrorContext(ctx, "login guard: failed to reset failures", "error", err)
+	}
+}
+
+// failures trims entries outside the window and returns the remaining count and
+// the time of the most recent failure
+func (g *LoginGuard) failures(ctx context.Context, key string, now time.Time) (int, time.Time, error) {
+	windowStart := now.Add(-loginAttemptWindow).UnixNano()
+
+	pipe := g.redis.TxPipeline()
+	pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(windowStart, 10))
+	count := pipe.ZCard(ctx, key)
+	last := pipe.ZRevRangeWithScores(ctx, key, 0, 0)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var lastAt time.Time
+	if entries := last.Val(); len(entries) > 0 {
+		lastAt = time.Unix(0, int64(entries[0].Score))
+	}
+
+	return int(count.Val()), lastAt, nil
+}
+
+func (g *LoginGuard) auditLockout(ctx context.Context, scope, email, ip string, failures int) {
+	metadata, _ := json.Marshal(map[string]any{
+		"scope":    scope,
+		"email":    email,
+		"failures": failures,
+		"duration": loginLockoutDuration.String(),
+	})
+
+	entry := &models.AuditEntry{
+		Action:    models.AuditActionLoginLockout,
+		IPAddress: sql.NullString{String: ip, Valid: ip != ""},
+		Metadata:  metadata,
+	}
+	if err := g.auditRepo.Create(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "login guard: failed to audit lockout", "error", err)
+	}
+}
+
+func progressiveDelay(failures int) time.Duration {
+	if failures < loginDelayThreshold {
+		return 0
+	}
+
+	delay := loginBaseDelay << (failures - loginDelayThreshold)
+	if delay <= 0 || delay > loginMaxDelay {
+		return loginMaxDelay
+	}
+	return delay
+}
+
+// ipScope returns the unit the IP counters are kept for: the address itself
+// for IPv4 and its /64 network for IPv6
+func ipScope(ip string) string {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return ip
+	}
+	addr = addr.Unmap()
+	if addr.Is4() {
+		return addr.String()
+	}
+	return netip.PrefixFrom(addr, 64).Masked().String()
+}
+
+func failuresKey(scope, value string) string {
+	return "login:failures:" + scope + ":" + strings.ToLower(value)
+}
+
+func lockKey(scope, value string) string {
+	return "login:lock:" + scope + ":" + strings.ToLower(value)
+}
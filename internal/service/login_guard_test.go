@@ -0,0 +1,215 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+type guardFixture struct {
+	guard *LoginGuard
+	redis *miniredis.Miniredis
+	mock  sqlmock.Sqlmock
+	now   time.Time
+}
+
+func newGuardFixture(t *testing.T) *guardFixture {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	f := &guardFixture{
+		guard: NewLoginGuard(client, repository.NewAuditRepository(sqlx.NewDb(db, "postgres"))),
+		redis: mr,
+		mock:  mock,
+		now:   time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC),
+	}
+	f.guard.now = func() time.Time { return f.now }
+	return f
+}
+
+func (f *guardFixture) advance(d time.Duration) {
+	f.now = f.now.Add(d)
+	f.redis.FastForward(d)
+}
+
+func (f *guardFixture) expectAudit() {
+	f.mock.ExpectQuery("INSERT INTO audit_log").
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, f.now))
+}
+
+func (f *guardFixture) fail(email, ip string, n int) {
+	for i := 0; i < n; i++ {
+		f.guard.RecordFailure(context.Background(), email, ip)
+		f.advance(time.Millisecond)
+	}
+}
+
+func throttled(t *testing.T, err error) *LoginThrottledError {
+	t.Helper()
+	var throttled *LoginThrottledError
+	if !errors.As(err, &throttled) {
+		t.Fatalf("Check = %v, want *LoginThrottledError", err)
+	}
+	return throttled
+}
+
+func TestLoginGuardProgressiveDelay(t *testing.T) {
+	f := newGuardFixture(t)
+	ctx := context.Background()
+
+	f.fail("a@example.com", "203.0.113.1", loginDelayThreshold-1)
+	if err := f.guard.Check(ctx, "a@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("Check below the delay threshold = %v", err)
+	}
+
+	f.fail("a@example.com", "203.0.113.1", 1)
+	err := throttled(t, f.guard.Check(ctx, "a@example.com", "203.0.113.1"))
+	if err.Locked || err.RetryAfter <= 0 || err.RetryAfter > loginBaseDelay {
+		t.Fatalf("Check = %+v, want a delay of at most %s", err, loginBaseDelay)
+	}
+
+	f.advance(loginBaseDelay)
+	if err := f.guard.Check(ctx, "a@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("Check after the delay = %v", err)
+	}
+
+	// The delay doubles with the next failure
+	f.fail("a@example.com", "203.0.113.1", 1)
+	err = throttled(t, f.guard.Check(ctx, "a@example.com", "203.0.113.1"))
+	if err.RetryAfter <= loginBaseDelay || err.RetryAfter > 2*loginBaseDelay {
+		t.Fatalf("RetryAfter = %s, want between %s and %s", err.RetryAfter, loginBaseDelay, 2*loginBaseDelay)
+	}
+
+	// Other emails are not delayed
+	if err := f.guard.Check(ctx, "b@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("Check for another email = %v", err)
+	}
+}
+
+func TestLoginGuardWindow(t *testing.T) {
+	f := newGuardFixture(t)
+	ctx := context.Background()
+
+	f.fail("a@example.com", "203.0.113.1", loginDelayThreshold)
+	throttled(t, f.guard.Check(ctx, "a@example.com", "203.0.113.1"))
+
+	// Failures older than the window no longer count
+	f.now = f.now.Add(loginAttemptWindow + time.Second)
+	if err := f.guard.Check(ctx, "a@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("Check after the window = %v", err)
+	}
+}
+
+func TestLoginGuardEmailLockout(t *testing.T) {
+	f := newGuardFixture(t)
+	ctx := context.Background()
+
+	f.expectAudit()
+	f.fail("a@example.com", "203.0.113.1", loginEmailLockThreshold)
+
+	err := throttled(t, f.guard.Check(ctx, "A@example.com", "198.51.100.1"))
+	if !err.Locked || err.RetryAfter <= 0 || err.RetryAfter > loginLockoutDuration {
+		t.Fatalf("Check = %+v, want a lockout of at most %s", err, loginLockoutDuration)
+	}
+	if err := f.mock.ExpectationsWereMet(); err != nil {
+		t.Fatalf("lockout not audited: %v", err)
+	}
+
+	// The lock outlives the failures it was set for, then expires
+	f.advance(loginLockoutDuration + time.Second)
+	if err := f.guard.Check(ctx, "a@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("Check after the lockout = %v", err)
+	}
+}
+
+func TestLoginGuardIPLockout(t *testing.T) {
+	f := newGuardFixture(t)
+	ctx := context.Background()
+
+	// Spreading attempts over many emails does not avoid the IP lockout
+	f.expectAudit()
+	for i := 0; i < loginIPLockThreshold; i++ {
+		f.fail(fmt.Sprintf("user%d@example.com", i), "2001:db8:1:2::1", 1)
+	}
+
+	// Neither does changing the address within the same IPv6 /64
+	err := throttled(t, f.guard.Check(ctx, "new@example.com", "2001:db8:1:2:ffff::9"))
+	if !err.Locked {
+		t.Fatalf("Check = %+v, want locked", err)
+	}
+	if err := f.guard.Check(ctx, "new@example.com", "2001:db8:1:3::1"); err != nil {
+		t.Fatalf("Check from another /64 = %v", err)
+	}
+}
+
+func TestLoginGuardRecordSuccess(t *testing.T) {
+	f := newGuardFixture(t)
+	ctx := context.Background()
+
+	f.fail("a@example.com", "203.0.113.1", loginDelayThreshold)
+	f.guard.RecordSuccess(ctx, "a@example.com")
+	if err := f.guard.Check(ctx, "a@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("Check after a successful login = %v", err)
+	}
+}
+
+func TestLoginGuardRedisDown(t *testing.T) {
+	f := newGuardFixture(t)
+	f.redis.Close()
+
+	if err := f.guard.Check(context.Background(), "a@example.com", "203.0.113.1"); err != nil {
+		t.Fatalf("Check with Redis down = %v, want nil", err)
+	}
+}
+
+func TestProgressiveDelay(t *testing.T) {
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{loginDelayThreshold - 1, 0},
+		{loginDelayThreshold, loginBaseDelay},
+		{loginDelayThreshold + 1, 2 * loginBaseDelay},
+		{loginDelayThreshold + 4, 16 * loginBaseDelay},
+		{loginDelayThreshold + 5, loginMaxDelay},
+		{1000, loginMaxDelay},
+	}
+	for _, tt := range tests {
+		if got := progressiveDelay(tt.failures); got != tt.want {
+			t.Errorf("progressiveDelay(%d) = %s, want %s", tt.failures, got, tt.want)
+		}
+	}
+}
+
+func TestIPScope(t *testing.T) {
+	tests := map[string]string{
+		"203.0.113.7":          "203.0.113.7",
+		"::ffff:203.0.113.7":   "203.0.113.7",
+		"2001:db8:1:2:3:4:5:6": "2001:db8:1:2::/64",
+		"not an address":       "not an address",
+		"":                     "",
+	}
+	for ip, want := range tests {
+		if got := ipScope(ip); got != want {
+			t.Errorf("ipScope(%q) = %q, want %q", ip, got, want)
+		}
+	}
+}
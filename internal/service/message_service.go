@@ -0,0 +1,226 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/moderation"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/websocket"
+)
+
+var (
+	ErrMissingRecipient = errors.New("missing recipient")
+	ErrEmptyMessage     = errors.New("empty message")
+
+	// ErrHeldMessageNotFound means the message does not exist or was already reviewed
+	ErrHeldMessageNotFound = errors.New("held message not found")
+)
+
+// MessageRejectedError is returned when moderation refuses a message
+type MessageRejectedError struct {
+	Reason string
+}
+
+func (e *MessageRejectedError) Error() string {
+	return "message rejected: " + e.Reason
+}
+
+// MessageService sends chat messages. It is shared by the websocket chat frame
+// and POST /api/messages so both have the same side effects. It also runs the
+// review queue of held messages.
+type MessageService struct {
+	messageRepo *repository.MessageRepository
+	userRepo    *repository.UserRepository
+	auditRepo   *repository.AuditRepository
+	hub         *websocket.Hub
+	moderator   moderation.Moderator
+}
+
+func NewMessageService(
+	messageRepo *repository.MessageRepository,
+	userRepo *repository.UserRepository,
+	auditRepo *repository.AuditRepository,
+	hub *websocket.Hub,
+	moderator moderation.Moderator,
+) *MessageService {
+	return &MessageService{
+		messageRepo: messageRepo,
+		userRepo:    userRepo,
+		auditRepo:   auditRepo,
+		hub:         hub,
+		moderator:   moderator,
+	}
+}
+
+// Send validates, moderates and stores a message, ends the sender's typing
+// indicator and delivers a chat frame to the recipient and to the sender's own
+// connections. A rejected message returns *MessageRejectedError. A held message
+// is stored and returned with ModerationHeld but only echoed to the sender.
+func (s *MessageService) Send(ctx context.Context, senderID int, req models.SendMessageRequest) (*models.Message, error) {
+	if req.RecipientID <= 0 {
+		return nil, ErrMissingRecipient
+	}
+	if req.Content == "" {
+		return nil, ErrEmptyMessage
+	}
+
+	recipient, err := s.userRepo.GetByID(ctx, req.RecipientID)
+	if err != nil {
+		return nil, err
+	}
+	if recipient == nil {
+		return nil, ErrUserNotFound
+	}
+
+	verdict, err := s.moderator.Moderate(ctx, moderation.Message{
+		SenderID:    senderID,
+		RecipientID: req.RecipientID,
+		Content:     req.Content,
+		Locale:      req.Locale,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("moderating message: %w", err)
+	}
+
+	message := &models.Message{
+		SenderID:         senderID,
+		RecipientID:      req.RecipientID,
+		Content:          req.Content,
+		IsRead:           false,
+		ModerationStatus: models.ModerationApproved,
+	}
+	switch verdict.Action {
+	case moderation.Reject:
+		return nil, &MessageRejectedError{Reason: verdict.Reason}
+	case moderation.Hold:
+		message.ModerationStatus = models.ModerationHeld
+	case moderation.Mask:
+		message.ModerationStatus = models.ModerationMasked
+		message.Content = verdict.Content
+	}
+	if verdict.Action != moderation.Allow {
+		message.ModerationReason = sql.NullString{String: verdict.Reason, Valid: true}
+	}
+
+	if err := s.messageRepo.Create(ctx, message); err != nil {
+		return nil, err
+	}
+
+	// A sent message ends the sender's typing indicator
+	s.hub.StopTyping(senderID, req.RecipientID)
+
+	if err := s.deliver(ctx, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// deliver sends a chat frame to the recipient, unless the message is held, and
+// to the sender's own connections as confirmation
+func (s *MessageService) deliver(ctx context.Context, message *models.Message) error {
+	data, err := json.Marshal(models.WSOutgoingMessage{
+		Type:      models.WSMessageTypeChat,
+		Message:   message,
+		SenderID:  message.SenderID,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling message: %w", err)
+	}
+
+	if message.ModerationStatus != models.ModerationHeld {
+		s.hub.SendToUser(ctx, message.RecipientID, data)
+	}
+	s.hub.SendToUser(ctx, message.SenderID, data)
+	return nil
+}
+
+// ListHeld returns the messages waiting for review, oldest first
+func (s *MessageService) ListHeld(ctx context.Context, limit, offset int) ([]models.HeldMessage, error) {
+	messages, err := s.messageRepo.ListHeld(ctx, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+
+	held := make([]models.HeldMessage, len(messages))
+	for i, m := range messages {
+		held[i] = models.HeldMessage{Message: m, Reason: m.ModerationReason.String}
+	}
+	return held, nil
+}
+
+// ApproveHeld releases a held message and delivers it to the recipient. The
+// sender receives the chat frame again with the new moderation status.
+func (s *MessageService) ApproveHeld(ctx context.Context, moderatorID, messageID int, note string) (*models.Message, error) {
+	message, err := s.messageRepo.ApproveHeld(ctx, messageID)
+	if err != nil {
+		return nil, err
+	}
+	if message == nil {
+		return nil, ErrHeldMessageNotFound
+	}
+	s.audit(ctx, moderatorID, models.AuditActionMessageApproved, message, note)
+
+	if err := s.deliver(ctx, message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// RejectHeld deletes a held message and tells the sender. The recipient never
+// saw it.
+func (s *MessageService) RejectHeld(ctx context.Context, moderatorID, messageID int, note string) error {
+	message, err := s.messageRepo.RejectHeld(ctx, messageID)
+	if err != nil {
+		return err
+	}
+	if message == nil {
+		return ErrHeldMessageNotFound
+	}
+	s.audit(ctx, moderatorID, models.AuditActionMessageRejected, message, note)
+
+	data, err := json.Marshal(models.WSOutgoingMessage{
+		Type:        models.WSMessageTypeMessageRejected,
+		MessageID:   message.ID,
+		RecipientID: message.RecipientID,
+		Reason:      message.ModerationReason.String,
+		Timestamp:   time.Now(),
+	})
+	if err != nil {
+		return fmt.Errorf("marshaling rejection: %w", err)
+	}
+	s.hub.SendToUser(ctx, message.SenderID, data)
+	return nil
+}
+
+// audit records a moderator's review. A failed write is logged rather than
+// undoing a review that already took effect.
+func (s *MessageService) audit(ctx context.Context, moderatorID int, action models.AuditAction, message *models.Message, note string) {
+	metadata, err := json.Marshal(map[string]any{
+		"sender_id":    message.SenderID,
+		"recipient_id": message.RecipientID,
+		"reason":       message.ModerationReason.String,
+		"note":         note,
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal audit metadata", "error", err)
+	}
+
+	entry := &models.AuditEntry{
+		ActorID:    sql.NullInt64{Int64: int64(moderatorID), Valid: true},
+		Action:     action,
+		TargetType: sql.NullString{String: "message", Valid: true},
+		TargetID:   sql.NullInt64{Int64: int64(message.ID), Valid: true},
+		Metadata:   metadata,
+	}
+	if err := s.auditRepo.Create(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit entry", "action", action, "message_id", message.ID, "error", err)
+	}
+}
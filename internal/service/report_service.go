@@ -0,0 +1,242 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/websocket"
+)
+
+var (
+	ErrInvalidReportTarget = errors.New("report exactly one of message_id and user_id")
+	ErrCannotReportSelf    = errors.New("cannot report yourself")
+	ErrMessageNotFound     = errors.New("message not found")
+	ErrDuplicateReport     = repository.ErrDuplicateReport
+
+	ErrReportNotFound      = errors.New("report not found")
+	ErrReportConflict      = repository.ErrReportConflict
+	ErrInvalidReportAction = errors.New("action does not apply to this report")
+)
+
+// ReportService files abuse reports and runs the admin review queue. Every admin
+// action is written to the audit log.
+type ReportService struct {
+	reportRepo   *repository.ReportRepository
+	messageRepo  *repository.MessageRepository
+	userRepo     *repository.UserRepository
+	auditRepo    *repository.AuditRepository
+	adminService *AdminService
+	hub          *websocket.Hub
+}
+
+func NewReportService(
+	reportRepo *repository.ReportRepository,
+	messageRepo *repository.MessageRepository,
+	userRepo *repository.UserRepository,
+	auditRepo *repository.AuditRepository,
+	adminService *AdminService,
+	hub *websocket.Hub,
+) *ReportService {
+	return &ReportService{
+		reportRepo:   reportRepo,
+		messageRepo:  messageRepo,
+		userRepo:     userRepo,
+		auditRepo:    auditRepo,
+		adminService: adminService,
+		hub:          hub,
+	}
+}
+
+// Create files a report. A message can only be reported by its recipient, and
+// its content is copied into the report so it survives deletion.
+func (s *ReportService) Create(ctx context.Context, reporterID int, req models.CreateReportRequest) (*models.Report, error) {
+	if (req.MessageID == nil) == (req.UserID == nil) {
+		return nil, ErrInvalidReportTarget
+	}
+
+	report := &models.Report{
+		ReporterID: sql.NullInt64{Int64: int64(reporterID), Valid: true},
+		Reason:     req.Reason,
+		Details:    nullString(req.Details),
+	}
+
+	if req.MessageID != nil {
+		message, err := s.messageRepo.GetByID(ctx, *req.MessageID)
+		if err != nil {
+			return nil, err
+		}
+		if message == nil || message.RecipientID != reporterID {
+			return nil, ErrMessageNotFound
+		}
+
+		report.TargetType = models.ReportTargetMessage
+		report.ReportedUserID = message.SenderID
+		report.MessageID = sql.NullInt64{Int64: int64(message.ID), Valid: true}
+		report.MessageContent = sql.NullString{String: message.Content, Valid: true}
+	} else {
+		if *req.UserID == reporterID {
+			return nil, ErrCannotReportSelf
+		}
+
+		user, err := s.userRepo.GetByID(ctx, *req.UserID)
+		if err != nil {
+			return nil, err
+		}
+		if user == nil || user.DeletedAt.Valid {
+			return nil, ErrUserNotFound
+		}
+
+		report.TargetType = models.ReportTargetUser
+		report.ReportedUserID = user.ID
+	}
+
+	if err := s.reportRepo.Create(ctx, report); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (s *ReportService) List(ctx context.Context, status models.ReportStatus, limit, offset int) ([]models.Report, error) {
+	return s.reportRepo.List(ctx, status, limit, offset)
+}
+
+func (s *ReportService) Get(ctx context.Context, id int) (*models.Report, error) {
+	report, err := s.reportRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if report == nil {
+		return nil, ErrReportNotFound
+	}
+	return report, nil
+}
+
+// Claim assigns the report to the admin so two admins do not work the same report
+func (s *ReportService) Claim(ctx context.Context, adminID, reportID int) (*models.Report, error) {
+	if _, err := s.Get(ctx, reportID); err != nil {
+		return nil, err
+	}
+
+	if err := s.reportRepo.Claim(ctx, reportID, adminID); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, adminID, models.AuditActionReportClaimed, "report", reportID, nil)
+
+	return s.Get(ctx, reportID)
+}
+
+// Resolve applies the action to the reported user or message and closes the
+// report. An open report is claimed first; one claimed by another admin
+// returns ErrReportConflict.
+func (s *ReportService) Resolve(ctx context.Context, adminID, reportID int, req models.ResolveReportRequest) (*models.Report, error) {
+	report, err := s.Get(ctx, reportID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Action {
+	case models.ReportActionSuspend:
+		if req.SuspendHours <= 0 {
+			return nil, ErrInvalidReportAction
+		}
+	case models.ReportActionDeleteContent:
+		if report.TargetType != models.ReportTargetMessage {
+			return nil, ErrInvalidReportAction
+		}
+	}
+
+	// Check the claim before acting so a lost race does not apply the action twice
+	switch {
+	case report.Status == models.ReportStatusResolved:
+		return nil, ErrReportConflict
+	case report.Status == models.ReportStatusClaimed && report.AssigneeID.Int64 != int64(adminID):
+		return nil, ErrReportConflict
+	case report.Status == models.ReportStatusOpen:
+		if err := s.reportRepo.Claim(ctx, reportID, adminID); err != nil {
+			return nil, err
+		}
+		s.audit(ctx, adminID, models.AuditActionReportClaimed, "report", reportID, nil)
+	}
+
+	if err := s.applyAction(ctx, adminID, report, req); err != nil {
+		return nil, err
+	}
+
+	if err := s.reportRepo.Resolve(ctx, reportID, adminID, req.Action, req.Note); err != nil {
+		return nil, err
+	}
+	s.audit(ctx, adminID, models.AuditActionReportResolved, "report", reportID, map[string]any{
+		"action": req.Action,
+		"note":   req.Note,
+	})
+
+	return s.Get(ctx, reportID)
+}
+
+func (s *ReportService) applyAction(ctx context.Context, adminID int, report *models.Report, req models.ResolveReportRequest) error {
+	metadata := map[string]any{"report_id": report.ID}
+
+	switch req.Action {
+	case models.ReportActionWarn:
+		s.sendWarning(ctx, report.ReportedUserID, req.Note)
+		s.audit(ctx, adminID, models.AuditActionUserWarned, "user", report.ReportedUserID, metadata)
+
+	case models.ReportActionSuspend:
+		duration := time.Duration(req.SuspendHours) * time.Hour
+		if _, err := s.adminService.Suspend(ctx, adminID, report.ReportedUserID, duration, req.Note); err != nil {
+			return err
+		}
+
+	case models.ReportActionDeleteContent:
+		messageID := int(report.MessageID.Int64)
+		if err := s.messageRepo.Delete(ctx, messageID); err != nil {
+			return err
+		}
+		metadata["sender_id"] = report.ReportedUserID
+		s.audit(ctx, adminID, models.AuditActionMessageDeleted, "message", messageID, metadata)
+	}
+
+	return nil
+}
+
+// sendWarning tells the user's live connections about the warning
+func (s *ReportService) sendWarning(ctx context.Context, userID int, note string) {
+	data, err := json.Marshal(models.WSOutgoingMessage{
+		Type:      models.WSMessageTypeWarning,
+		Reason:    note,
+		Timestamp: time.Now(),
+	})
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal warning", "error", err)
+		return
+	}
+	s.hub.SendToUser(ctx, userID, data)
+}
+
+// audit records an admin action. A failed write is logged rather than undoing
+// an action that already took effect.
+func (s *ReportService) audit(ctx context.Context, adminID int, action models.AuditAction, targetType string, targetID int, metadata map[string]any) {
+	entry := &models.AuditEntry{
+		ActorID:    sql.NullInt64{Int64: int64(adminID), Valid: true},
+		Action:     action,
+		TargetType: sql.NullString{String: targetType, Valid: true},
+		TargetID:   sql.NullInt64{Int64: int64(targetID), Valid: true},
+	}
+	if metadata != nil {
+		data, err := json.Marshal(metadata)
+		if err != nil {
+			slog.ErrorContext(ctx, "failed to marshal audit metadata", "error", err)
+		}
+		entry.Metadata = data
+	}
+
+	if err := s.auditRepo.Create(ctx, entry); err != nil {
+		slog.ErrorContext(ctx, "failed to write audit entry", "action", action, "target_type", targetType, "target_id", targetID, "error", err)
+	}
+}
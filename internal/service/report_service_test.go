@@ -0,0 +1,133 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/jmoiron/sqlx"
+)
+
+func newTestReportService(t *testing.T) (*ReportService, sqlmock.Sqlmock) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	// Dismissing and deleting content need neither the admin service nor the hub
+	s := NewReportService(
+		repository.NewReportRepository(sqlxDB),
+		repository.NewMessageRepository(sqlxDB),
+		repository.NewUserRepository(sqlxDB),
+		repository.NewAuditRepository(sqlxDB),
+		nil,
+		nil,
+	)
+	return s, mock
+}
+
+// reportRows returns the reports row for a report about message 40 by user 2
+func reportRows(id int, status models.ReportStatus, assigneeID int) *sqlmock.Rows {
+	assignee := sql.NullInt64{Int64: int64(assigneeID), Valid: assigneeID != 0}
+	return sqlmock.NewRows([]string{"id", "target_type", "reported_user_id", "message_id", "status", "assignee_id"}).
+		AddRow(id, models.ReportTargetMessage, 2, 40, status, assignee)
+}
+
+func expectAudit(mock sqlmock.Sqlmock, action models.AuditAction) {
+	mock.ExpectQuery("INSERT INTO audit_log").
+		WithArgs(sqlmock.AnyArg(), action, sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg(), sqlmock.AnyArg()).
+		WillReturnRows(sqlmock.NewRows([]string{"id", "created_at"}).AddRow(1, time.Now()))
+}
+
+func TestResolveReportConflicts(t *testing.T) {
+	deleteContent := models.ResolveReportRequest{Action: models.ReportActionDeleteContent}
+
+	tests := []struct {
+		name   string
+		expect func(mock sqlmock.Sqlmock)
+	}{
+		{"claimed by another admin", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT \\* FROM reports").WithArgs(1).WillReturnRows(reportRows(1, models.ReportStatusClaimed, 9))
+		}},
+		{"already resolved", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT \\* FROM reports").WithArgs(1).WillReturnRows(reportRows(1, models.ReportStatusResolved, 5))
+		}},
+		{"claimed by another admin first", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery("SELECT \\* FROM reports").WithArgs(1).WillReturnRows(reportRows(1, models.ReportStatusOpen, 0))
+			mock.ExpectExec("UPDATE reports SET status = 'claimed'").WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, mock := newTestReportService(t)
+			tt.expect(mock)
+
+			// The message is only deleted by the admin who holds the claim
+			if _, err := s.Resolve(context.Background(), 5, 1, deleteContent); !errors.Is(err, ErrReportConflict) {
+				t.Fatalf("Resolve = %v, want ErrReportConflict", err)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+func TestResolveOpenReport(t *testing.T) {
+	s, mock := newTestReportService(t)
+
+	// An open report is claimed, acted on and closed by the same admin
+	mock.ExpectQuery("SELECT \\* FROM reports").WithArgs(1).WillReturnRows(reportRows(1, models.ReportStatusOpen, 0))
+	mock.ExpectExec("UPDATE reports SET status = 'claimed'").WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, models.AuditActionReportClaimed)
+	mock.ExpectExec("DELETE FROM messages").WithArgs(40).WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, models.AuditActionMessageDeleted)
+	mock.ExpectExec("UPDATE reports SET status = 'resolved'").
+		WithArgs(1, 5, models.ReportActionDeleteContent, "abusive").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	expectAudit(mock, models.AuditActionReportResolved)
+	mock.ExpectQuery("SELECT \\* FROM reports").WithArgs(1).WillReturnRows(reportRows(1, models.ReportStatusResolved, 5))
+
+	report, err := s.Resolve(context.Background(), 5, 1, models.ResolveReportRequest{
+		Action: models.ReportActionDeleteContent,
+		Note:   "abusive",
+	})
+	if err != nil {
+		t.Fatalf("Resolve = %v", err)
+	}
+	if report.Status != models.ReportStatusResolved {
+		t.Fatalf("status = %q, want resolved", report.Status)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClaimReport(t *testing.T) {
+	s, mock := newTestReportService(t)
+	ctx := context.Background()
+
+	mock.ExpectQuery("SELECT \\* FROM reports").WithArgs(1).WillReturnRows(reportRows(1, models.ReportStatusClaimed, 9))
+	mock.ExpectExec("UPDATE reports SET status = 'claimed'").WithArgs(1, 5).WillReturnResult(sqlmock.NewResult(0, 0))
+	if _, err := s.Claim(ctx, 5, 1); !errors.Is(err, ErrReportConflict) {
+		t.Fatalf("Claim of another admin's report = %v, want ErrReportConflict", err)
+	}
+
+	mock.ExpectQuery("SELECT \\* FROM reports").WithArgs(2).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+	if _, err := s.Claim(ctx, 5, 2); !errors.Is(err, ErrReportNotFound) {
+		t.Fatalf("Claim of a missing report = %v, want ErrReportNotFound", err)
+	}
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
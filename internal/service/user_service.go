@@ -0,0 +1,410 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/imaging"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/storage"
+	"github.com/HaykAghajanyan/chat-backend/internal/websocket"
+	"github.com/jmoiron/sqlx"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// What happens to a deleted user's messages
+const (
+	// DeletedMessagesKeep leaves messages in place, attributed to "Deleted user"
+	DeletedMessagesKeep = "keep"
+	// DeletedMessagesCascade removes every message the user sent or received
+	DeletedMessagesCascade = "cascade"
+)
+
+const (
+	avatarSize        = 256
+	avatarJPEGQuality = 85
+
+	// Reject images whose header claims more pixels than this before decoding them
+	maxAvatarPixels = 40_000_000
+)
+
+var (
+	ErrUserNotFound    = errors.New("user not found")
+	ErrUsernameTaken   = repository.ErrUsernameTaken
+	ErrInvalidUsername = errors.New("username must not be empty")
+	ErrInvalidImage    = errors.New("unsupported or corrupt image")
+
+	ErrCannotBlockSelf = errors.New("cannot block yourself")
+)
+
+type UserService struct {
+	db                    *sqlx.DB
+	userRepo              *repository.UserRepository
+	messageRepo           *repository.MessageRepository
+	sessionRepo           *repository.SessionRepository
+	blockRepo             *repository.BlockRepository
+	hub                   *websocket.Hub
+	store                 *storage.Local
+	deletedMessagesPolicy string
+}
+
+func NewUserService(
+	db *sqlx.DB,
+	userRepo *repository.UserRepository,
+	messageRepo *repository.MessageRepository,
+	sessionRepo *repository.SessionRepository,
+	blockRepo *repository.BlockRepository,
+	hub *websocket.Hub,
+	store *storage.Local,
+	deletedMessagesPolicy string,
+) *UserService {
+	return &UserService{
+		db:                    db,
+		userRepo:              userRepo,
+		messageRepo:           messageRepo,
+		sessionRepo:           sessionRepo,
+		blockRepo:             blockRepo,
+		hub:                   hub,
+		store:                 store,
+		deletedMessagesPolicy: deletedMessagesPolicy,
+	}
+}
+
+// UpdateProfile applies a partial profile update and notifies the user's contacts
+func (s *UserService) UpdateProfile(ctx context.Context, userID int, req models.UpdateProfileRequest) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	if req.Username != nil {
+		username := strings.TrimSpace(*req.Username)
+		if username == "" {
+			return nil, ErrInvalidUsername
+		}
+		if username != user.Username {
+			existing, err := s.userRepo.GetByUsername(ctx, username)
+			if err != nil {
+				return nil, err
+			}
+			if existing != nil {
+				return nil, ErrUsernameTaken
+			}
+			user.Username = username
+		}
+	}
+	if req.DisplayName != nil {
+		user.DisplayName = nullString(*req.DisplayName)
+	}
+	if req.Bio != nil {
+		user.Bio = nullString(*req.Bio)
+	}
+	if req.StatusText != nil {
+		user.StatusText = nullString(*req.StatusText)
+	}
+	if req.LastSeenVisibility != nil {
+		user.LastSeenVisibility = *req.LastSeenVisibility
+	}
+
+	if err := s.userRepo.UpdateProfile(ctx, user); err != nil {
+		return nil, err
+	}
+
+	s.notifyProfileUpdated(ctx, user)
+	return user, nil
+}
+
+// UploadAvatar decodes the uploaded image, stores a square thumbnail of it and
+// replaces the user's previous avatar
+func (s *UserService) UploadAvatar(ctx context.Context, userID int, r io.Reader) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil || cfg.Width == 0 || cfg.Height == 0 || cfg.Width*cfg.Height > maxAvatarPixels {
+		return nil, ErrInvalidImage
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, ErrInvalidImage
+	}
+
+	var encoded bytes.Buffer
+	thumbnail := imaging.Thumbnail(img, avatarSize, color.White)
+	if err := jpeg.Encode(&encoded, thumbnail, &jpeg.Options{Quality: avatarJPEGQuality}); err != nil {
+		return nil, fmt.Errorf("failed to encode avatar: %w", err)
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("avatars/%d-%s.jpg", user.ID, hex.EncodeToString(suffix))
+
+	url, err := s.store.Put(key, &encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	previous := user.AvatarURL
+	user.AvatarURL = sql.NullString{String: url, Valid: true}
+	if err := s.userRepo.UpdateProfile(ctx, user); err != nil {
+		s.store.Delete(key)
+		return nil, err
+	}
+
+	s.deleteStoredAvatar(ctx, previous)
+	s.notifyProfileUpdated(ctx, user)
+	return user, nil
+}
+
+func (s *UserService) RemoveAvatar(ctx context.Context, userID int) (*models.User, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, ErrUserNotFound
+	}
+	if !user.AvatarURL.Valid {
+		return user, nil
+	}
+
+	previous := user.AvatarURL
+	user.AvatarURL = sql.NullString{}
+	if err := s.userRepo.UpdateProfile(ctx, user); err != nil {
+		return nil, err
+	}
+
+	s.deleteStoredAvatar(ctx, previous)
+	s.notifyProfileUpdated(ctx, user)
+	return user, nil
+}
+
+// GetPresence returns the user's presence as seen by viewerID. The last-seen
+// timestamp is only included when the user's privacy setting allows it.
+func (s *UserService) GetPresence(ctx context.Context, viewerID, userID int) (*models.PresenceInfo, error) {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil || user.DeletedAt.Valid {
+		return nil, ErrUserNotFound
+	}
+
+	status, err := s.hub.Presence(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	// Only the user themselves can tell invisible from offline
+	if status == models.PresenceInvisible && viewerID != userID {
+		status = models.PresenceOffline
+	}
+
+	info := &models.PresenceInfo{
+		UserID: userID,
+		Status: status,
+	}
+
+	if status != models.PresenceOffline || !user.LastSeenAt.Valid {
+		return info, nil
+	}
+
+	visible := viewerID == userID
+	switch user.LastSeenVisibility {
+	case models.LastSeenEveryone:
+		visible = true
+	case models.LastSeenContacts:
+		if !visible {
+			if visible, err = s.messageRepo.HasConversation(ctx, viewerID, userID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if visible {
+		info.LastSeenAt = &user.LastSeenAt.Time
+	}
+	return info, nil
+}
+
+// BlockUser stops blockedID's typing indicators from reaching userID
+func (s *UserService) BlockUser(ctx context.Context, userID, blockedID int) error {
+	if userID == blockedID {
+		return ErrCannotBlockSelf
+	}
+
+	user, err := s.userRepo.GetByID(ctx, blockedID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := s.blockRepo.Block(ctx, userID, blockedID); err != nil {
+		return err
+	}
+
+	// Neither side keeps seeing the other's presence
+	s.hub.Unwatch(userID, blockedID)
+	s.hub.Unwatch(blockedID, userID)
+	return nil
+}
+
+func (s *UserService) UnblockUser(ctx context.Context, userID, blockedID int) error {
+	return s.blockRepo.Unblock(ctx, userID, blockedID)
+}
+
+func (s *UserService) GetBlockedUserIDs(ctx context.Context, userID int) ([]int, error) {
+	return s.blockRepo.GetBlockedIDs(ctx, userID)
+}
+
+// ChangePassword verifies the current password, stores the new one, revokes
+// every session except the one making the request and closes the user's live
+// connections
+func (s *UserService) ChangePassword(ctx context.Context, userID int, sessionID string, req models.ChangePasswordRequest) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil {
+		return ErrUserNotFound
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(req.CurrentPassword)); err != nil {
+		return ErrInvalidCredentials
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(req.NewPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+
+	if err := s.userRepo.UpdatePassword(ctx, user.ID, string(hashedPassword)); err != nil {
+		return err
+	}
+
+	if err := s.sessionRepo.RevokeAllExcept(ctx, user.ID, sessionID); err != nil {
+		return err
+	}
+
+	// Connections are not tied to a session, so all of them are closed; the
+	// client making the request reconnects with its session that is still valid
+	s.hub.DisconnectUser(user.ID, "password changed")
+	return nil
+}
+
+// DeleteAccount anonymises the user, applies the configured message policy,
+// revokes all sessions and drops the user's live connections
+func (s *UserService) DeleteAccount(ctx context.Context, userID int, password string) error {
+	user, err := s.userRepo.GetByID(ctx, userID)
+	if err != nil {
+		return err
+	}
+	if user == nil || user.DeletedAt.Valid {
+		return ErrUserNotFound
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidCredentials
+	}
+
+	// A failure part way must not leave a signed-out user with their data half removed
+	err = repository.RunInTx(ctx, s.db, func(tx *sqlx.Tx) error {
+		if err := s.sessionRepo.WithTx(tx).RevokeAllForUser(ctx, user.ID); err != nil {
+			return err
+		}
+
+		if s.deletedMessagesPolicy == DeletedMessagesCascade {
+			if err := s.messageRepo.WithTx(tx).DeleteAllForUser(ctx, user.ID); err != nil {
+				return err
+			}
+		}
+
+		return s.userRepo.WithTx(tx).Anonymize(ctx, user.ID)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.deleteStoredAvatar(ctx, user.AvatarURL)
+
+	// Closing the sockets unregisters them, which also removes the user from presence
+	s.hub.DisconnectUser(user.ID, "account deleted")
+	return nil
+}
+
+func (s *UserService) deleteStoredAvatar(ctx context.Context, url sql.NullString) {
+	if !url.Valid {
+		return
+	}
+	if key, ok := s.store.KeyFromURL(url.String); ok {
+		if err := s.store.Delete(key); err != nil {
+			slog.ErrorContext(ctx, "failed to delete avatar", "key", key, "error", err)
+		}
+	}
+}
+
+// notifyProfileUpdated pushes the new profile to everyone the user has talked to
+// and to the user's own connections
+func (s *UserService) notifyProfileUpdated(ctx context.Context, user *models.User) {
+	contacts, err := s.messageRepo.GetContactIDs(ctx, user.ID)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to load contacts", "user_id", user.ID, "error", err)
+		return
+	}
+
+	outgoing := models.WSOutgoingMessage{
+		Type:      models.WSMessageTypeProfileUpdated,
+		SenderID:  user.ID,
+		User:      user,
+		Timestamp: time.Now(),
+	}
+
+	data, err := json.Marshal(outgoing)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal profile update", "error", err)
+		return
+	}
+
+	for _, contactID := range contacts {
+		s.hub.SendToUser(ctx, contactID, data)
+	}
+	s.hub.SendToUser(ctx, user.ID, data)
+}
+
+func nullString(value string) sql.NullString {
+	value = strings.TrimSpace(value)
+	return sql.NullString{String: value, Valid: value != ""}
+}
@@ -0,0 +1,133 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrInvalidKey = errors.New("storage: invalid key")
+
+// Local stores files on the local filesystem (or a volume shared between nodes)
+// and serves them under baseURL
+type Local struct {
+	root    string
+	baseURL string
+}
+
+func NewLocal(root, baseURL string) (*Local, error) {
+	if err := os.MkdirAll(root, 0o755); err != nil {
+		return nil, fmt.Errorf("storage: failed to create root %s: %w", root, err)
+	}
+
+	return &Local{
+		root:    root,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+// Put writes the content under key, replacing any existing file, and returns its public URL
+func (s *Local) Put(key string, r io.Reader) (string, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return "", fmt.Errorf("storage: failed to create directory: %w", err)
+	}
+
+	// Write to a temp file first so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return "", fmt.Errorf("storage: failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return "", fmt.Errorf("storage: failed to write %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("storage: failed to write %s: %w", key, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", fmt.Errorf("storage: failed to store %s: %w", key, err)
+	}
+
+	return s.URL(key), nil
+}
+
+func (s *Local) Open(key string) (*os.File, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+// Delete removes the file stored under key. Missing files are not an error.
+func (s *Local) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("storage: failed to delete %s: %w", key, err)
+	}
+	return nil
+}
+
+func (s *Local) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// KeyFromURL returns the key of a URL produced by this store, if it is one
+func (s *Local) KeyFromURL(url string) (string, bool) {
+	key, ok := strings.CutPrefix(url, s.baseURL+"/")
+	if !ok || key == "" {
+		return "", false
+	}
+	return key, true
+}
+
+func (s *Local) path(key string) (string, error) {
+	clean := filepath.Clean("/" + key)
+	if clean == "/" || clean != "/"+key {
+		return "", ErrInvalidKey
+	}
+	return filepath.Join(s.root, filepath.FromSlash(clean)), nil
+}
+
+// Handler serves the stored files. Directories are answered with 404 rather
+// than a listing, so keys cannot be discovered by browsing.
+func (s *Local) Handler() http.Handler {
+	return http.FileServer(noDirFS{http.Dir(s.root)})
+}
+
+// noDirFS hides directories from http.FileServer
+type noDirFS struct {
+	fs http.FileSystem
+}
+
+func (fs noDirFS) Open(name string) (http.File, error) {
+	f, err := fs.fs.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, os.ErrNotExist
+	}
+	return f, nil
+}
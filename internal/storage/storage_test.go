@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandlerHidesDirectories(t *testing.T) {
+	store, err := NewLocal(t.TempDir(), "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.Put("avatars/1/a.png", strings.NewReader("png")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/avatars/1/a.png", http.StatusOK},
+		{"/", http.StatusNotFound},
+		{"/avatars/", http.StatusNotFound},
+		{"/avatars/1", http.StatusNotFound},
+		{"/avatars/1/missing.png", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		store.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+		if rec.Code != tt.want {
+			t.Errorf("GET %s = %d, want %d", tt.path, rec.Code, tt.want)
+		}
+		if tt.want == http.StatusOK && rec.Body.String() != "png" {
+			t.Errorf("GET %s body = %q", tt.path, rec.Body.String())
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Package tracing sets up OpenTelemetry tracing and holds the helpers shared by
+// the instrumented packages. Trace context is propagated in the W3C
+// traceparent format, over HTTP headers and inside broker messages.
+//
+// Until Setup installs a tracer provider, Start returns non-recording spans, so
+// instrumented code costs next to nothing.
+package tracing
+
+import (
+	"context"
+	"io"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName identifies the spans of this module
+const instrumentationName = "github.com/HaykAghajanyan/chat-backend"
+
+// Setup installs a tracer provider that exports spans in batches with
+// exporter, and the W3C trace context propagator. A ratio below 1 samples that
+// fraction of new traces; traces continued from a remote parent follow its
+// sampling decision. The returned function flushes pending spans and stops the
+// exporter.
+func Setup(exporter sdktrace.SpanExporter, serviceName string, ratio float64) (shutdown func(context.Context) error, err error) {
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", serviceName),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// NewOTLPExporter exports to endpoint, the base URL of an OTLP/HTTP collector
+// such as http://otel-collector:4318. Spans are posted to /v1/traces.
+func NewOTLPExporter(ctx context.Context, endpoint string) (sdktrace.SpanExporter, error) {
+	return otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(strings.TrimRight(endpoint, "/")+"/v1/traces"))
+}
+
+// NewStdoutExporter writes one JSON document per span to w, for local development
+func NewStdoutExporter(w io.Writer) (sdktrace.SpanExporter, error) {
+	return stdouttrace.New(stdouttrace.WithWriter(w))
+}
+
+// Start begins a span as a child of the active span in ctx, which may be a
+// remote one. The returned context carries the new span.
+func Start(ctx context.Context, name string, kind trace.SpanKind) (context.Context, trace.Span) {
+	return otel.Tracer(instrumentationName).Start(ctx, name, trace.WithSpanKind(kind))
+}
+
+// RecordError records err on the span and marks it as failed. A nil error is
+// ignored.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// Fail marks the span as failed without recording an error event, for
+// failures that are not Go errors such as 5xx responses
+func Fail(span trace.Span, description string) {
+	span.SetStatus(codes.Error, description)
+}
+
+// TraceID returns the trace ID of the span, for log records
+func TraceID(span trace.Span) string {
+	return span.SpanContext().TraceID().String()
+}
+
+// Detach returns ctx without its active span, so spans started from it begin a
+// new trace. Long-lived work such as a WebSocket connection uses it to keep
+// its operations out of the trace of the request that started it.
+func Detach(ctx context.Context) context.Context {
+	return trace.ContextWithSpanContext(ctx, trace.SpanContext{})
+}
+
+// traceparentKey is the carrier key of the W3C trace context
+const traceparentKey = "traceparent"
+
+// Traceparent returns the traceparent of the active span in ctx, or "" if
+// there is none
+func Traceparent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier.Get(traceparentKey)
+}
+
+// ContextWithTraceparent makes the span identified by traceparent the remote
+// parent of the next span started from ctx. An empty or invalid traceparent
+// leaves ctx unchanged.
+func ContextWithTraceparent(ctx context.Context, traceparent string) context.Context {
+	if traceparent == "" {
+		return ctx
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier{traceparentKey: traceparent})
+}
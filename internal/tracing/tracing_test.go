@@ -0,0 +1,154 @@
+package tracing
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// setupTest installs a provider that keeps spans in memory. flush returns the
+// spans ended so far.
+func setupTest(t *testing.T, ratio float64) (flush func() tracetest.SpanStubs) {
+	t.Helper()
+
+	exporter := tracetest.NewInMemoryExporter()
+	shutdown, err := Setup(exporter, "chat-test", ratio)
+	if err != nil {
+		t.Fatalf("Setup: %v", err)
+	}
+	t.Cleanup(func() { shutdown(context.Background()) })
+
+	return func() tracetest.SpanStubs {
+		provider := otel.GetTracerProvider().(*sdktrace.TracerProvider)
+		if err := provider.ForceFlush(context.Background()); err != nil {
+			t.Fatalf("ForceFlush: %v", err)
+		}
+		return exporter.GetSpans()
+	}
+}
+
+func TestStart(t *testing.T) {
+	flush := setupTest(t, 1)
+
+	ctx, parent := Start(context.Background(), "parent", trace.SpanKindServer)
+	_, child := Start(ctx, "child", trace.SpanKindClient)
+	RecordError(child, errors.New("boom"))
+	RecordError(child, nil)
+	child.End()
+	parent.End()
+
+	spans := flush()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	c, p := spans[0], spans[1]
+
+	if c.Parent.SpanID() != p.SpanContext.SpanID() || c.SpanContext.TraceID() != p.SpanContext.TraceID() {
+		t.Errorf("child is not in the parent's trace")
+	}
+	if c.SpanKind != trace.SpanKindClient || p.SpanKind != trace.SpanKindServer {
+		t.Errorf("kinds = %v, %v", c.SpanKind, p.SpanKind)
+	}
+	if c.Status.Code != codes.Error || c.Status.Description != "boom" {
+		t.Errorf("child status = %+v, want the error", c.Status)
+	}
+	if len(c.Events) != 1 || c.Events[0].Name != "exception" {
+		t.Errorf("child events = %v, want one exception", c.Events)
+	}
+	if p.Status.Code != codes.Unset {
+		t.Errorf("parent status = %+v, want unset", p.Status)
+	}
+
+	name, _ := p.Resource.Set().Value(attribute.Key("service.name"))
+	if name.AsString() != "chat-test" {
+		t.Errorf("service.name = %q", name.AsString())
+	}
+}
+
+func TestTraceparentRoundTrip(t *testing.T) {
+	flush := setupTest(t, 1)
+
+	ctx, sender := Start(context.Background(), "Broker.Publish", trace.SpanKindProducer)
+	traceparent := Traceparent(ctx)
+	sender.End()
+
+	if !regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`).MatchString(traceparent) {
+		t.Fatalf("Traceparent = %q", traceparent)
+	}
+
+	// The receiving side continues the trace
+	_, receiver := Start(ContextWithTraceparent(context.Background(), traceparent), "Hub.Deliver", trace.SpanKindConsumer)
+	receiver.End()
+
+	spans := flush()
+	if len(spans) != 2 {
+		t.Fatalf("exported %d spans, want 2", len(spans))
+	}
+	s, r := spans[0], spans[1]
+	if r.SpanContext.TraceID() != s.SpanContext.TraceID() || r.Parent.SpanID() != s.SpanContext.SpanID() {
+		t.Errorf("receiver did not continue the sender's trace")
+	}
+	if !r.Parent.IsRemote() {
+		t.Errorf("receiver's parent is not remote")
+	}
+
+	if got := Traceparent(context.Background()); got != "" {
+		t.Errorf("Traceparent without a span = %q, want empty", got)
+	}
+}
+
+func TestContextWithInvalidTraceparent(t *testing.T) {
+	setupTest(t, 1)
+
+	for _, traceparent := range []string{"", "garbage", "00-00000000000000000000000000000000-0000000000000000-01"} {
+		ctx := ContextWithTraceparent(context.Background(), traceparent)
+		if trace.SpanContextFromContext(ctx).IsValid() {
+			t.Errorf("%q gave a valid parent", traceparent)
+		}
+	}
+}
+
+func TestDetach(t *testing.T) {
+	setupTest(t, 1)
+
+	ctx, request := Start(context.Background(), "GET /ws", trace.SpanKindServer)
+	defer request.End()
+
+	_, frame := Start(Detach(ctx), "ws.frame chat", trace.SpanKindServer)
+	defer frame.End()
+
+	if frame.SpanContext().TraceID() == request.SpanContext().TraceID() {
+		t.Fatal("span started from a detached context joined the request's trace")
+	}
+}
+
+func TestSamplingFollowsRemoteParent(t *testing.T) {
+	setupTest(t, 0)
+
+	_, root := Start(context.Background(), "root", trace.SpanKindServer)
+	if root.IsRecording() {
+		t.Error("new trace was sampled with ratio 0")
+	}
+	root.End()
+
+	const parent = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-"
+	_, sampled := Start(ContextWithTraceparent(context.Background(), parent+"01"), "sampled", trace.SpanKindServer)
+	if !sampled.IsRecording() {
+		t.Error("span with a sampled remote parent was not recorded")
+	}
+	sampled.End()
+
+	_, unsampled := Start(ContextWithTraceparent(context.Background(), parent+"00"), "unsampled", trace.SpanKindServer)
+	if unsampled.IsRecording() {
+		t.Error("span with an unsampled remote parent was recorded")
+	}
+	unsampled.End()
+}
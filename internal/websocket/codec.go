@@ -0,0 +1,91 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/gorilla/websocket"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// codec converts frames to and from their wire format. Frames stay JSON inside
+// the hub and the broker; only the pumps see the wire format.
+type codec interface {
+	// messageType is the websocket message type used for outgoing batches
+	messageType() int
+	// decode parses a single frame received from the client
+	decode(data []byte, msg *models.WSMessage) error
+	// writeBatch writes a batch of frames as one websocket message
+	writeBatch(w io.Writer, batch []*Frame) error
+}
+
+// codecFor returns the codec for a negotiated subprotocol
+func codecFor(protocol string) codec {
+	if protocol == ProtocolV2MsgPack {
+		return msgpackCodec{}
+	}
+	return jsonCodec{}
+}
+
+// jsonCodec sends text messages with frames separated by newlines
+type jsonCodec struct{}
+
+func (jsonCodec) messageType() int {
+	return websocket.TextMessage
+}
+
+func (jsonCodec) decode(data []byte, msg *models.WSMessage) error {
+	return json.Unmarshal(data, msg)
+}
+
+func (jsonCodec) writeBatch(w io.Writer, batch []*Frame) error {
+	for i, f := range batch {
+		if i > 0 {
+			if _, err := w.Write([]byte{'\n'}); err != nil {
+				return err
+			}
+		}
+		if _, err := w.Write(f.JSON()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// msgpackCodec sends binary messages holding MessagePack frames, each prefixed
+// with its length as an unsigned varint. Clients send one frame per message
+// without a prefix. Frames have the keys of their JSON form; timestamps use the
+// MessagePack timestamp extension in both directions.
+type msgpackCodec struct{}
+
+func (msgpackCodec) messageType() int {
+	return websocket.BinaryMessage
+}
+
+func (msgpackCodec) decode(data []byte, msg *models.WSMessage) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(data))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(msg)
+}
+
+func (msgpackCodec) writeBatch(w io.Writer, batch []*Frame) error {
+	var prefix [binary.MaxVarintLen64]byte
+	for _, f := range batch {
+		frame, err := f.msgpack()
+		if err != nil {
+			return err
+		}
+
+		n := binary.PutUvarint(prefix[:], uint64(len(frame)))
+		if _, err := w.Write(prefix[:n]); err != nil {
+			return err
+		}
+		if _, err := w.Write(frame); err != nil {
+			return err
+		}
+	}
+	return nil
+}
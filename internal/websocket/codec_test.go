@@ -0,0 +1,206 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+func testOutgoing() models.WSOutgoingMessage {
+	at := time.Date(2026, 10, 18, 9, 30, 15, 123456789, time.UTC)
+	return models.WSOutgoingMessage{
+		Type: models.WSMessageTypeChat,
+		Message: &models.Message{
+			ID: 1, SenderID: 2, RecipientID: 3, Content: "hello",
+			CreatedAt: at, ModerationStatus: models.ModerationApproved,
+		},
+		Statuses: map[int]models.PresenceStatus{2: models.PresenceOnline, 10: models.PresenceAway},
+		User: &models.User{
+			ID: 2, Username: "ann", Email: "ann@example.com",
+			DisplayName:     sql.NullString{String: "Ann", Valid: true},
+			EmailVerifiedAt: sql.NullTime{Valid: true},
+			CreatedAt:       at, UpdatedAt: at,
+		},
+		Export: &models.DataExport{
+			ID: 4, Status: models.DataExportStatusReady, CreatedAt: at,
+			ExpiresAt: sql.NullTime{Time: at.Add(time.Hour), Valid: true},
+		},
+		Timestamp: at,
+	}
+}
+
+func TestFrameMsgpackTimestamps(t *testing.T) {
+	f, err := encodeFrame(testOutgoing())
+	if err != nil {
+		t.Fatalf("encodeFrame: %v", err)
+	}
+	packed, err := f.msgpack()
+	if err != nil {
+		t.Fatalf("msgpack: %v", err)
+	}
+
+	var frame map[string]any
+	if err := msgpack.Unmarshal(packed, &frame); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	if _, ok := frame["timestamp"].(time.Time); !ok {
+		t.Errorf("timestamp = %T, want a MessagePack timestamp", frame["timestamp"])
+	}
+	if _, ok := frame["message"].(map[string]any)["created_at"].(time.Time); !ok {
+		t.Errorf("message.created_at is not a MessagePack timestamp")
+	}
+
+	user := frame["user"].(map[string]any)
+	if user["display_name"] != "Ann" || user["email_verified"] != true {
+		t.Errorf("user = %v, want the fields of User.MarshalJSON", user)
+	}
+	if _, ok := user["password_hash"]; ok {
+		t.Errorf("user leaks password_hash")
+	}
+	export := frame["export"].(map[string]any)
+	if export["download_url"] != "/api/users/me/exports/4/download" {
+		t.Errorf("export = %v, want the download link", export)
+	}
+	if _, ok := export["expires_at"].(time.Time); !ok {
+		t.Errorf("export.expires_at is not a MessagePack timestamp")
+	}
+}
+
+func TestFrameMsgpackFromBroker(t *testing.T) {
+	local, err := encodeFrame(testOutgoing())
+	if err != nil {
+		t.Fatalf("encodeFrame: %v", err)
+	}
+	want, err := local.msgpack()
+	if err != nil {
+		t.Fatalf("msgpack: %v", err)
+	}
+
+	// A frame that crossed the broker only has its JSON form, and must encode
+	// to the same bytes
+	remote := newFrame(local.JSON())
+	if remote.msgType != models.WSMessageTypeChat {
+		t.Fatalf("msgType = %q, want %q", remote.msgType, models.WSMessageTypeChat)
+	}
+	got, err := remote.msgpack()
+	if err != nil {
+		t.Fatalf("msgpack: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Fatalf("broker frame encodes differently:\n got % x\nwant % x", got, want)
+	}
+}
+
+func TestFrameMsgpackEncodedOnce(t *testing.T) {
+	f := newFrame([]byte(`{"type":"online","sender_id":5,"timestamp":"2026-10-18T09:30:00Z"}`))
+	first, err := f.msgpack()
+	if err != nil {
+		t.Fatalf("msgpack: %v", err)
+	}
+	second, _ := f.msgpack()
+	if &first[0] != &second[0] {
+		t.Fatal("frame was encoded twice")
+	}
+}
+
+func TestMsgpackCodecWriteBatch(t *testing.T) {
+	frames := []*Frame{
+		newFrame([]byte(`{"type":"online","sender_id":5,"timestamp":"2026-10-18T09:30:00Z"}`)),
+		newFrame([]byte(`{"type":"offline","sender_id":6,"timestamp":"2026-10-18T09:31:00Z"}`)),
+	}
+
+	var buf bytes.Buffer
+	if err := (msgpackCodec{}).writeBatch(&buf, frames); err != nil {
+		t.Fatalf("writeBatch: %v", err)
+	}
+
+	r := bufio.NewReader(&buf)
+	for i, want := range []int{5, 6} {
+		n, err := binary.ReadUvarint(r)
+		if err != nil {
+			t.Fatalf("frame %d: reading length: %v", i, err)
+		}
+		data := make([]byte, n)
+		if _, err := io.ReadFull(r, data); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+
+		var out struct {
+			SenderID int `msgpack:"sender_id"`
+		}
+		if err := msgpack.Unmarshal(data, &out); err != nil {
+			t.Fatalf("frame %d: Decode: %v", i, err)
+		}
+		if out.SenderID != want {
+			t.Errorf("frame %d: sender_id = %d, want %d", i, out.SenderID, want)
+		}
+	}
+	if r.Buffered() != 0 {
+		t.Errorf("%d bytes left after the batch", r.Buffered())
+	}
+}
+
+func TestMsgpackCodecDecode(t *testing.T) {
+	at := time.Date(2026, 10, 18, 9, 30, 0, 0, time.UTC)
+	data, err := msgpack.Marshal(map[string]any{
+		"type":       "chat",
+		"request_id": "r1",
+		"content":    "hi",
+		"recipient":  3,
+		"user_ids":   []any{1, 2},
+		"timestamp":  at,
+		"extra":      map[string]any{"ignored": true},
+	})
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+
+	var msg models.WSMessage
+	if err := (msgpackCodec{}).decode(data, &msg); err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	want := models.WSMessage{
+		Type: models.WSMessageTypeChat, RequestID: "r1", Content: "hi",
+		Recipient: 3, UserIDs: []int{1, 2},
+	}
+	// MessagePack timestamps carry no zone, so compare the instant
+	if !msg.Timestamp.Equal(at) {
+		t.Fatalf("timestamp = %v, want %v", msg.Timestamp, at)
+	}
+	msg.Timestamp = time.Time{}
+	if !reflect.DeepEqual(msg, want) {
+		t.Fatalf("decode = %+v, want %+v", msg, want)
+	}
+
+	bad, _ := msgpack.Marshal(map[string]any{"recipient": "three"})
+	if err := (msgpackCodec{}).decode(bad, &msg); err == nil {
+		t.Fatal("decode accepted a string recipient")
+	}
+}
+
+func FuzzMsgpackCodecDecode(f *testing.F) {
+	for _, v := range []any{
+		map[string]any{"type": "chat", "content": "hi", "recipient": 2},
+		map[string]any{"type": "presence_subscribe", "user_ids": []any{1, 2, 3}},
+		map[string]any{"type": "typing", "timestamp": time.Unix(1700000000, 0)},
+	} {
+		data, err := msgpack.Marshal(v)
+		if err != nil {
+			f.Fatalf("Marshal: %v", err)
+		}
+		f.Add(data)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var msg models.WSMessage
+		_ = msgpackCodec{}.decode(data, &msg)
+	})
+}
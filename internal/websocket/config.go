@@ -0,0 +1,102 @@
+package websocket
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Config holds the connection limits and compression settings. Use
+// WithDefaults to fill in unset fields.
+type Config struct {
+	ReadBufferSize  int
+	WriteBufferSize int
+	// SendBufferSize is the number of frames queued per client before frames
+	// are dropped
+	SendBufferSize int
+	// OverflowSize is the number of reliable frames held once the send buffer
+	// is full before the client is disconnected as a slow consumer
+	OverflowSize int
+
+	// MaxMessageSize is the largest message accepted from a client, in bytes,
+	// measured after decompression
+	MaxMessageSize int64
+
+	// WriteWait is the time allowed to write a message to the peer
+	WriteWait time.Duration
+	// PongWait is the time allowed to read the next pong from the peer. Pings
+	// are sent every 9/10 of it.
+	PongWait time.Duration
+
+	// EnableCompression negotiates permessage-deflate with clients that offer it
+	EnableCompression bool
+	// CompressionLevel is passed to compress/flate
+	CompressionLevel int
+	// CompressionThreshold is the batch size in bytes below which outgoing
+	// messages are sent uncompressed
+	CompressionThreshold int
+}
+
+func DefaultConfig() Config {
+	return Config{
+		ReadBufferSize:       1024,
+		WriteBufferSize:      1024,
+		SendBufferSize:       256,
+		OverflowSize:         1024,
+		MaxMessageSize:       16 * 1024,
+		WriteWait:            10 * time.Second,
+		PongWait:             60 * time.Second,
+		EnableCompression:    true,
+		CompressionLevel:     1,
+		CompressionThreshold: 1024,
+	}
+}
+
+// WithDefaults returns a copy of c with unset fields replaced by their
+// defaults. EnableCompression is kept as is.
+func (c Config) WithDefaults() Config {
+	d := DefaultConfig()
+	if c.ReadBufferSize <= 0 {
+		c.ReadBufferSize = d.ReadBufferSize
+	}
+	if c.WriteBufferSize <= 0 {
+		c.WriteBufferSize = d.WriteBufferSize
+	}
+	if c.SendBufferSize <= 0 {
+		c.SendBufferSize = d.SendBufferSize
+	}
+	if c.OverflowSize <= 0 {
+		c.OverflowSize = d.OverflowSize
+	}
+	if c.MaxMessageSize <= 0 {
+		c.MaxMessageSize = d.MaxMessageSize
+	}
+	if c.WriteWait <= 0 {
+		c.WriteWait = d.WriteWait
+	}
+	if c.PongWait <= 0 {
+		c.PongWait = d.PongWait
+	}
+	if c.CompressionLevel == 0 {
+		c.CompressionLevel = d.CompressionLevel
+	}
+	return c
+}
+
+func (c Config) pingPeriod() time.Duration {
+	return (c.PongWait * 9) / 10
+}
+
+// Upgrader returns an upgrader for this configuration
+func (c Config) Upgrader() websocket.Upgrader {
+	return websocket.Upgrader{
+		ReadBufferSize:    c.ReadBufferSize,
+		WriteBufferSize:   c.WriteBufferSize,
+		Subprotocols:      Subprotocols,
+		EnableCompression: c.EnableCompression,
+		CheckOrigin: func(r *http.Request) bool {
+			return true // Allow all origins in development
+		},
+	}
+}
@@ -1,37 +1,23 @@
 package websocket
 
 import (
-	"encoding/json"
-	"log"
-	"net/http"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"time"
 
+	"github.com/HaykAghajanyan/chat-backend/internal/logging"
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-const (
-	// Time allowed to write a message to the peer
-	writeWait = 10 * time.Second
-
-	// Time allowed to read the next pong message from the peer
-	pongWait = 60 * time.Second
-
-	// Send pings to peer with this period (must be less than pongWait)
-	pingPeriod = (pongWait * 9) / 10
-
-	// Maximum message size allowed from peer
-	maxMessageSize = 512
-)
-
-var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		// Allow all origins (in production, restrict this)
-		return true
-	},
-}
+// errMessageTooBig is returned by readMessage for messages over Config.MaxMessageSize
+var errMessageTooBig = errors.New("message too big")
 
 type Connection struct {
 	Ws *websocket.Conn
@@ -41,44 +27,116 @@ func NewConnection(conn *websocket.Conn) *Connection {
 	return &Connection{Ws: conn}
 }
 
+// Close sends a close frame with the given code and reason and closes the
+// connection. ReadPump then unregisters the client. Safe to call concurrently
+// with the pumps. Stream clients are told through Done instead.
+func (c *Client) Close(code int, reason string) {
+	if c.stream != nil {
+		c.stream.close(reason)
+		return
+	}
+
+	deadline := time.Now().Add(c.Config.WriteWait)
+	c.Conn.Ws.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), deadline)
+	c.Conn.Ws.Close()
+}
+
 // ReadPump pumps messages from the websocket connection to the hub
-func (c *Client) ReadPump(hub *Hub, messageHandler func(*Client, *models.WSMessage)) {
+// A handler error is reported to the client as an error frame. Each frame is
+// handled in its own trace, under a span started from the client's context.
+func (c *Client) ReadPump(hub *Hub, messageHandler func(context.Context, *Client, *models.WSMessage) error) {
 	defer func() {
 		hub.Unregister <- c
 		c.Conn.Ws.Close()
 	}()
 
-	c.Conn.Ws.SetReadLimit(maxMessageSize)
-	c.Conn.Ws.SetReadDeadline(time.Now().Add(pongWait))
+	c.Conn.Ws.SetReadDeadline(time.Now().Add(c.Config.PongWait))
 	c.Conn.Ws.SetPongHandler(func(string) error {
-		c.Conn.Ws.SetReadDeadline(time.Now().Add(pongWait))
+		c.Conn.Ws.SetReadDeadline(time.Now().Add(c.Config.PongWait))
 		return nil
 	})
 
 	for {
-		_, message, err := c.Conn.Ws.ReadMessage()
+		messageType, message, err := c.readMessage()
+		if errors.Is(err, errMessageTooBig) {
+			slog.WarnContext(c.Context(), "closing connection: message too big", "limit", c.Config.MaxMessageSize)
+			c.Close(websocket.CloseMessageTooBig, fmt.Sprintf("message exceeds %d bytes", c.Config.MaxMessageSize))
+			break
+		}
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
-				log.Printf("WebSocket error: %v", err)
+				slog.WarnContext(c.Context(), "websocket read error", "error", err)
 			}
 			break
 		}
 
-		// Parse incoming message
+		// Parse incoming message. Binary frames are only accepted from clients
+		// that negotiated a binary codec.
+		codec := codecFor(c.Protocol)
+		if messageType != codec.messageType() {
+			c.SendError("", NewProtocolError(models.WSErrorBadFrame, "unexpected message type"))
+			continue
+		}
+
 		var wsMsg models.WSMessage
-		if err := json.Unmarshal(message, &wsMsg); err != nil {
-			log.Printf("Error parsing message: %v", err)
+		if err := codec.decode(message, &wsMsg); err != nil {
+			c.SendError("", NewProtocolError(models.WSErrorBadJSON, err.Error()))
 			continue
 		}
 
+		framesReceived.WithLabelValues(receivedTypeLabel(wsMsg.Type)).Inc()
+
+		// Any frame from the client counts as activity for idle detection
+		if c.touch() {
+			hub.markActive(c)
+		}
+
 		// Handle the message
-		messageHandler(c, &wsMsg)
+		ctx, span := c.startFrameSpan(wsMsg.Type)
+		if err := messageHandler(ctx, c, &wsMsg); err != nil {
+			tracing.RecordError(span, err)
+			c.SendError(wsMsg.RequestID, err)
+		}
+		span.End()
 	}
 }
 
+func (c *Client) startFrameSpan(msgType models.WSMessageType) (context.Context, trace.Span) {
+	frameType := receivedTypeLabel(msgType)
+	ctx, span := tracing.Start(c.Context(), "ws.frame "+frameType, trace.SpanKindServer)
+	if !span.IsRecording() {
+		return ctx, span
+	}
+	span.SetAttributes(
+		attribute.String("ws.frame.type", frameType),
+		attribute.String("ws.protocol", c.Protocol),
+		attribute.Int("enduser.id", c.UserID),
+	)
+	return logging.With(ctx, "trace_id", tracing.TraceID(span)), span
+}
+
+// readMessage reads the next message, enforcing Config.MaxMessageSize. The
+// limit is applied to the decompressed payload so a small compressed frame
+// cannot expand past it.
+func (c *Client) readMessage() (int, []byte, error) {
+	messageType, r, err := c.Conn.Ws.NextReader()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	message, err := io.ReadAll(io.LimitReader(r, c.Config.MaxMessageSize+1))
+	if err != nil {
+		return 0, nil, err
+	}
+	if int64(len(message)) > c.Config.MaxMessageSize {
+		return 0, nil, errMessageTooBig
+	}
+	return messageType, message, nil
+}
+
 // WritePump pumps messages from the hub to the websocket connection
 func (c *Client) WritePump() {
-	ticker := time.NewTicker(pingPeriod)
+	ticker := time.NewTicker(c.Config.pingPeriod())
 	defer func() {
 		ticker.Stop()
 		c.Conn.Ws.Close()
@@ -87,24 +145,42 @@ func (c *Client) WritePump() {
 	for {
 		select {
 		case message, ok := <-c.Send:
-			c.Conn.Ws.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.Ws.SetWriteDeadline(time.Now().Add(c.Config.WriteWait))
 			if !ok {
 				// Hub closed the channel
 				c.Conn.Ws.WriteMessage(websocket.CloseMessage, []byte{})
 				return
 			}
 
-			w, err := c.Conn.Ws.NextWriter(websocket.TextMessage)
+			// Collect the queued messages so they go out in a single websocket message
+			batch := c.takePending([]*Frame{message})
+
+			if !c.Versioned() {
+				batch = translateBatchForV1(batch)
+				if len(batch) == 0 {
+					continue
+				}
+			}
+
+			// Small batches are not worth the deflate overhead. The size is
+			// measured on the JSON frames, before any binary encoding.
+			if c.Config.EnableCompression {
+				size := 0
+				for _, f := range batch {
+					size += len(f.JSON())
+				}
+				c.Conn.Ws.EnableWriteCompression(size >= c.Config.CompressionThreshold)
+			}
+
+			codec := codecFor(c.Protocol)
+			w, err := c.Conn.Ws.NextWriter(codec.messageType())
 			if err != nil {
 				return
 			}
-			w.Write(message)
-
-			// Add queued messages to the current websocket message
-			n := len(c.Send)
-			for i := 0; i < n; i++ {
-				w.Write([]byte{'\n'})
-				w.Write(<-c.Send)
+			if err := codec.writeBatch(w, batch); err != nil {
+				slog.ErrorContext(c.Context(), "failed to encode frames", "error", err)
+				w.Close()
+				return
 			}
 
 			if err := w.Close(); err != nil {
@@ -112,7 +188,7 @@ func (c *Client) WritePump() {
 			}
 
 		case <-ticker.C:
-			c.Conn.Ws.SetWriteDeadline(time.Now().Add(writeWait))
+			c.Conn.Ws.SetWriteDeadline(time.Now().Add(c.Config.WriteWait))
 			if err := c.Conn.Ws.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
@@ -0,0 +1,207 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+)
+
+// messageClass decides what happens to a frame when the client's send buffer is full
+type messageClass int
+
+const (
+	// classEphemeral frames (typing, presence) are dropped first; a later frame
+	// supersedes them anyway
+	classEphemeral messageClass = iota
+	// classReliable frames (chat, receipts, notifications) are held in the
+	// overflow buffer, and the client is disconnected once that fills up
+	classReliable
+
+	numClasses
+)
+
+func (c messageClass) String() string {
+	if c == classEphemeral {
+		return "ephemeral"
+	}
+	return "reliable"
+}
+
+// frameType returns the type of a JSON frame, or "" if it cannot be parsed
+func frameType(payload []byte) models.WSMessageType {
+	var frame struct {
+		Type models.WSMessageType `json:"type"`
+	}
+	if err := json.Unmarshal(payload, &frame); err != nil {
+		return ""
+	}
+	return frame.Type
+}
+
+// classify returns the class of a frame from its type
+func classify(msgType models.WSMessageType) messageClass {
+	switch msgType {
+	case models.WSMessageTypeTyping, models.WSMessageTypeTypingStart, models.WSMessageTypeTypingStop,
+		models.WSMessageTypePresence, models.WSMessageTypeOnline, models.WSMessageTypeOffline:
+		return classEphemeral
+	}
+	return classReliable
+}
+
+// deliveryOutcome is the result of queueing a frame for a client
+type deliveryOutcome int
+
+const (
+	// outcomeDelivered means the frame went straight into the send buffer
+	outcomeDelivered deliveryOutcome = iota
+	// outcomeBuffered means the frame was held in the overflow buffer
+	outcomeBuffered
+	// outcomeDropped means the frame was discarded, either because it was
+	// ephemeral or because the client is going away
+	outcomeDropped
+	// outcomeDisconnected means the overflow buffer was full and the client is
+	// being disconnected
+	outcomeDisconnected
+
+	numOutcomes
+)
+
+func (o deliveryOutcome) String() string {
+	switch o {
+	case outcomeDelivered:
+		return "delivered"
+	case outcomeBuffered:
+		return "buffered"
+	case outcomeDropped:
+		return "dropped"
+	default:
+		return "disconnected"
+	}
+}
+
+// DeliveryStats counts the outcomes of frames queued for local clients
+type DeliveryStats struct {
+	Delivered    uint64 `json:"delivered"`
+	Buffered     uint64 `json:"buffered"`
+	Dropped      uint64 `json:"dropped"`
+	Disconnected uint64 `json:"disconnected"`
+}
+
+type deliveryCounters [numClasses][numOutcomes]atomic.Uint64
+
+func (d *deliveryCounters) record(class messageClass, outcome deliveryOutcome) {
+	d[class][outcome].Add(1)
+	deliveries.WithLabelValues(class.String(), outcome.String()).Inc()
+}
+
+// snapshot returns the counters keyed by class name
+func (d *deliveryCounters) snapshot() map[string]DeliveryStats {
+	stats := make(map[string]DeliveryStats, numClasses)
+	for class := messageClass(0); class < numClasses; class++ {
+		stats[class.String()] = DeliveryStats{
+			Delivered:    d[class][outcomeDelivered].Load(),
+			Buffered:     d[class][outcomeBuffered].Load(),
+			Dropped:      d[class][outcomeDropped].Load(),
+			Disconnected: d[class][outcomeDisconnected].Load(),
+		}
+	}
+	return stats
+}
+
+// enqueue queues a frame for the client according to its class. Frames go to
+// the send buffer while it has room. Once it is full, ephemeral frames are
+// dropped and reliable frames wait in the overflow buffer, which WritePump
+// drains after the send buffer so ordering is kept. A client whose overflow
+// buffer fills up is disconnected with CloseSlowConsumer.
+func (c *Client) enqueue(f *Frame, class messageClass) deliveryOutcome {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.sendClosed || c.slow {
+		return outcomeDropped
+	}
+
+	if len(c.overflow) == 0 {
+		select {
+		case c.Send <- f:
+			return outcomeDelivered
+		default:
+		}
+	}
+
+	if class == classEphemeral {
+		return outcomeDropped
+	}
+
+	if len(c.overflow) < c.Config.OverflowSize {
+		c.overflow = append(c.overflow, f)
+		return outcomeBuffered
+	}
+
+	// The client cannot keep up. Closing the connection makes ReadPump
+	// unregister it, and the hub then closes Send.
+	c.slow = true
+	c.overflow = nil
+	reason := fmt.Sprintf("slow consumer: more than %d frames pending", cap(c.Send)+c.Config.OverflowSize)
+	go c.Close(CloseSlowConsumer, reason)
+	return outcomeDisconnected
+}
+
+// takePending drains the send buffer and the overflow buffer behind it. It is
+// called by WritePump, the only reader of Send.
+func (c *Client) takePending(batch []*Frame) []*Frame {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	n := len(c.Send)
+	for i := 0; i < n; i++ {
+		f, ok := <-c.Send
+		if !ok {
+			break
+		}
+		batch = append(batch, f)
+	}
+	batch = append(batch, c.overflow...)
+	c.overflow = nil
+	return batch
+}
+
+// pending reports whether frames are still waiting for WritePump
+func (c *Client) pending() bool {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	return !c.sendClosed && (len(c.Send) > 0 || len(c.overflow) > 0)
+}
+
+// waitFlushed waits until WritePump has taken every queued frame
+func (c *Client) waitFlushed(ctx context.Context) error {
+	ticker := time.NewTicker(20 * time.Millisecond)
+	defer ticker.Stop()
+
+	for c.pending() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// closeSend closes the send channel, telling WritePump to finish. Only the hub
+// calls it; it is safe to call more than once.
+func (c *Client) closeSend() {
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+
+	if c.sendClosed {
+		return
+	}
+	c.sendClosed = true
+	c.overflow = nil
+	close(c.Send)
+}
@@ -0,0 +1,175 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+)
+
+func newTestClient(sendBuffer, overflow int) *Client {
+	return NewStreamClient(1, Config{SendBufferSize: sendBuffer, OverflowSize: overflow}, models.PresenceOnline)
+}
+
+func testFrame(n int) *Frame {
+	return newFrame([]byte(fmt.Sprintf(`{"type":"chat","message_id":%d}`, n)))
+}
+
+// frameIDs returns the message IDs of a batch of test frames
+func frameIDs(batch []*Frame) string {
+	ids := make([]string, len(batch))
+	for i, f := range batch {
+		ids[i] = strings.TrimSuffix(strings.TrimPrefix(string(f.JSON()), `{"type":"chat","message_id":`), "}")
+	}
+	return strings.Join(ids, ",")
+}
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		msgType models.WSMessageType
+		want    messageClass
+	}{
+		{models.WSMessageTypeTyping, classEphemeral},
+		{models.WSMessageTypeTypingStart, classEphemeral},
+		{models.WSMessageTypePresence, classEphemeral},
+		{models.WSMessageTypeOnline, classEphemeral},
+		{models.WSMessageTypeChat, classReliable},
+		{models.WSMessageTypeRead, classReliable},
+		{"", classReliable},
+	}
+	for _, tt := range tests {
+		if got := classify(tt.msgType); got != tt.want {
+			t.Errorf("classify(%q) = %s, want %s", tt.msgType, got, tt.want)
+		}
+	}
+}
+
+func TestEnqueueOverflow(t *testing.T) {
+	c := newTestClient(2, 2)
+
+	steps := []struct {
+		class messageClass
+		want  deliveryOutcome
+	}{
+		{classReliable, outcomeDelivered},
+		{classReliable, outcomeDelivered},
+		// The send buffer is full: ephemeral frames are dropped and reliable
+		// ones wait in the overflow buffer
+		{classEphemeral, outcomeDropped},
+		{classReliable, outcomeBuffered},
+		{classReliable, outcomeBuffered},
+		// Both buffers are full
+		{classEphemeral, outcomeDropped},
+		{classReliable, outcomeDisconnected},
+		// The client is going away
+		{classReliable, outcomeDropped},
+	}
+	for i, step := range steps {
+		if got := c.enqueue(testFrame(i), step.class); got != step.want {
+			t.Fatalf("frame %d (%s) = %s, want %s", i, step.class, got, step.want)
+		}
+	}
+
+	select {
+	case <-c.Done():
+	case <-time.After(time.Second):
+		t.Fatal("slow consumer was not closed")
+	}
+	if reason := c.CloseReason(); !strings.Contains(reason, "slow consumer: more than 4 frames pending") {
+		t.Fatalf("close reason = %q", reason)
+	}
+
+	// The overflow buffer was discarded with the client
+	if got := frameIDs(c.takePending(nil)); got != "0,1" {
+		t.Fatalf("pending frames = %s, want 0,1", got)
+	}
+}
+
+func TestEnqueueKeepsOrder(t *testing.T) {
+	c := newTestClient(1, 4)
+
+	c.enqueue(testFrame(1), classReliable)
+	if got := c.enqueue(testFrame(2), classReliable); got != outcomeBuffered {
+		t.Fatalf("second frame = %s, want buffered", got)
+	}
+
+	// The send buffer has room again, but frames keep going to the overflow
+	// buffer until it is drained so they cannot overtake frame 2
+	<-c.Send
+	if got := c.enqueue(testFrame(3), classReliable); got != outcomeBuffered {
+		t.Fatalf("third frame = %s, want buffered behind the overflow", got)
+	}
+	if got := c.enqueue(testFrame(4), classEphemeral); got != outcomeDropped {
+		t.Fatalf("ephemeral frame = %s, want dropped while frames are held", got)
+	}
+
+	if got := frameIDs(c.takePending(nil)); got != "2,3" {
+		t.Fatalf("pending frames = %s, want 2,3", got)
+	}
+	if got := c.enqueue(testFrame(5), classEphemeral); got != outcomeDelivered {
+		t.Fatalf("frame after draining = %s, want delivered", got)
+	}
+}
+
+func TestCloseSend(t *testing.T) {
+	c := newTestClient(1, 1)
+	c.enqueue(testFrame(1), classReliable)
+	c.enqueue(testFrame(2), classReliable)
+
+	c.closeSend()
+	c.closeSend()
+
+	if got := c.enqueue(testFrame(3), classReliable); got != outcomeDropped {
+		t.Fatalf("frame after closeSend = %s, want dropped", got)
+	}
+	if c.pending() {
+		t.Fatal("closed client reports pending frames")
+	}
+	if err := c.waitFlushed(context.Background()); err != nil {
+		t.Fatalf("waitFlushed = %v", err)
+	}
+
+	// WritePump still sees the frames already in Send, then the closed channel
+	if f, ok := <-c.Send; !ok || frameIDs([]*Frame{f}) != "1" {
+		t.Fatal("frame 1 was lost")
+	}
+	if _, ok := <-c.Send; ok {
+		t.Fatal("Send is still open")
+	}
+}
+
+func TestWaitFlushed(t *testing.T) {
+	c := newTestClient(1, 1)
+	c.enqueue(testFrame(1), classReliable)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := c.waitFlushed(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("waitFlushed with a frame queued = %v, want DeadlineExceeded", err)
+	}
+
+	c.takePending(nil)
+	if err := c.waitFlushed(context.Background()); err != nil {
+		t.Fatalf("waitFlushed after draining = %v", err)
+	}
+}
+
+func TestDeliveryCounters(t *testing.T) {
+	var d deliveryCounters
+	d.record(classReliable, outcomeDelivered)
+	d.record(classReliable, outcomeBuffered)
+	d.record(classEphemeral, outcomeDropped)
+	d.record(classEphemeral, outcomeDropped)
+
+	stats := d.snapshot()
+	if got := stats["reliable"]; got != (DeliveryStats{Delivered: 1, Buffered: 1}) {
+		t.Errorf("reliable = %+v", got)
+	}
+	if got := stats["ephemeral"]; got != (DeliveryStats{Dropped: 2}) {
+		t.Errorf("ephemeral = %+v", got)
+	}
+}
@@ -0,0 +1,95 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// Frame is an outgoing frame queued for one or more clients. The hub and the
+// broker carry frames as JSON. The MessagePack encoding is made on first use
+// and shared by every client the frame is queued for.
+type Frame struct {
+	data    []byte
+	msgType models.WSMessageType
+
+	packOnce sync.Once
+	packed   []byte
+	packErr  error
+}
+
+// newFrame wraps a JSON frame, typically one read from the broker
+func newFrame(data []byte) *Frame {
+	return &Frame{data: data, msgType: frameType(data)}
+}
+
+// encodeFrame builds a frame from a typed message
+func encodeFrame(outgoing models.WSOutgoingMessage) (*Frame, error) {
+	data, err := json.Marshal(outgoing)
+	if err != nil {
+		return nil, err
+	}
+	return &Frame{data: data, msgType: outgoing.Type}, nil
+}
+
+// JSON returns the frame as a JSON document
+func (f *Frame) JSON() []byte {
+	return f.data
+}
+
+// msgpack returns the frame encoded as MessagePack. It has the keys of the
+// JSON form, so the models' MarshalJSON decides what clients see in both.
+func (f *Frame) msgpack() ([]byte, error) {
+	f.packOnce.Do(func() {
+		dec := json.NewDecoder(bytes.NewReader(f.data))
+		dec.UseNumber()
+
+		var v any
+		if f.packErr = dec.Decode(&v); f.packErr != nil {
+			return
+		}
+
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetSortMapKeys(true)
+		enc.UseCompactInts(true)
+		if f.packErr = enc.Encode(packValue("", v)); f.packErr == nil {
+			f.packed = buf.Bytes()
+		}
+	})
+	return f.packed, f.packErr
+}
+
+// packValue converts a decoded JSON value for MessagePack: numbers become
+// integers where they are whole, and the times the protocol sends, in fields
+// named timestamp or ending in _at, become MessagePack timestamps.
+func packValue(key string, v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, elem := range v {
+			v[k] = packValue(k, elem)
+		}
+	case []any:
+		for i, elem := range v {
+			v[i] = packValue("", elem)
+		}
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case string:
+		if key == "timestamp" || strings.HasSuffix(key, "_at") {
+			if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+				return t
+			}
+		}
+	}
+	return v
+}
@@ -3,85 +3,334 @@ package websocket
 import (
 	"context"
 	"encoding/json"
-	"log"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	broker "github.com/HaykAghajanyan/chat-backend/internal/brocker"
 	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/HaykAghajanyan/chat-backend/internal/tracing"
+	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type Client struct {
 	UserID int
 	Conn   *Connection
-	Send   chan []byte
+	Send   chan *Frame
+
+	// Protocol is the negotiated subprotocol, empty for clients that did not ask for one
+	Protocol string
+
+	// Config holds the connection limits, see Config.WithDefaults
+	Config Config
+
+	// ConnID identifies the connection in logs and rate limit keys
+	ConnID string
+
+	// Ctx carries the connection's logging attributes, see Context
+	Ctx context.Context
+
+	// Status is the presence status the client chose. Guarded by Hub.mu.
+	Status models.PresenceStatus
+
+	// subscriptions are the users whose presence this client receives. Guarded by Hub.mu.
+	subscriptions map[int]struct{}
+
+	// autoAway is set when the hub marked the client away for being idle
+	autoAway atomic.Bool
+	// lastActivity is the unix nano time of the last inbound frame
+	lastActivity atomic.Int64
+
+	// sendMu guards writes to Send and the fields below, see enqueue
+	sendMu sync.Mutex
+	// overflow holds reliable frames that did not fit in Send
+	overflow []*Frame
+	// sendClosed is set once the hub closed Send
+	sendClosed bool
+	// slow is set once the client overflowed and is being disconnected
+	slow bool
+
+	// stream is set for clients of the fallback transports, which have no Conn
+	stream *stream
+}
+
+// Context returns the context the client was created with, or
+// context.Background if it has none. It outlives the upgrade request.
+func (c *Client) Context() context.Context {
+	if c.Ctx == nil {
+		return context.Background()
+	}
+	return c.Ctx
+}
+
+// touch records inbound activity and reports whether the client was auto-away
+func (c *Client) touch() bool {
+	c.lastActivity.Store(time.Now().UnixNano())
+	return c.autoAway.Load()
 }
 
 type Hub struct {
-	clients    map[int]*Client
+	// clients holds the local connections of each user, over any transport.
+	// A user may have several, e.g. two tabs or a phone and a desktop.
+	clients map[int]map[*Client]struct{}
+	// watchers maps a user ID to the local clients subscribed to its presence
+	watchers   map[int]map[*Client]struct{}
 	mu         sync.RWMutex
 	Register   chan *Client
 	Unregister chan *Client
+	pings      chan struct{}
 	broker     *broker.Broker
+	presence   *presenceStore
+	typing     *typingTracker
+	userRepo   *repository.UserRepository
+	delivery   deliveryCounters
+	draining   atomic.Bool
+
+	// pendingPresence holds presence changes in the order they happened until
+	// writePresence stores them in Redis, so neither mu nor the main loop
+	// waits for a network call. Guarded by mu.
+	pendingPresence []presenceEvent
+	// presenceWake tells writePresence there are pending changes
+	presenceWake chan struct{}
+}
+
+// presenceEvent is a change of a user's status on this node waiting to be
+// written
+type presenceEvent struct {
+	userID int
+	// status is the user's status across this node's connections;
+	// PresenceOffline removes this node's entry
+	status models.PresenceStatus
 }
 
-func NewHub(b *broker.Broker) *Hub {
-	return &Hub{
-		clients:    make(map[int]*Client),
+func NewHub(b *broker.Broker, userRepo *repository.UserRepository, blockRepo *repository.BlockRepository) *Hub {
+	h := &Hub{
+		clients:    make(map[int]map[*Client]struct{}),
+		watchers:   make(map[int]map[*Client]struct{}),
 		Register:   make(chan *Client),
 		Unregister: make(chan *Client),
+		pings:      make(chan struct{}),
 		broker:     b,
+		presence: &presenceStore{
+			redis: b.Client(),
+			node:  strconv.FormatUint(rand.Uint64(), 36),
+		},
+		userRepo:     userRepo,
+		presenceWake: make(chan struct{}, 1),
 	}
+	h.typing = newTypingTracker(h.SendToUser, blockRepo)
+	return h
 }
 
 // Run starts the hub's main loop
 func (h *Hub) Run() {
-	h.broker.Subscribe(context.Background(), func(userID int, payload []byte) {
-		h.mu.RLock()
-		client, ok := h.clients[userID]
-		h.mu.RUnlock()
+	h.broker.Subscribe(context.Background(), func(ctx context.Context, msg broker.Message) {
+		if msg.Kind == broker.KindPresence {
+			h.deliverPresence(msg.UserID, msg.Payload)
+			return
+		}
+
+		clients := h.userClients(msg.UserID)
+		if len(clients) == 0 {
+			return
+		}
+
+		switch msg.Kind {
+		case broker.KindDisconnect:
+			for _, client := range clients {
+				client.Close(websocket.ClosePolicyViolation, string(msg.Payload))
+			}
+
+		case broker.KindUnwatch:
+			if watchedID, err := strconv.Atoi(string(msg.Payload)); err == nil {
+				for _, client := range clients {
+					h.unwatch(client, watchedID)
+				}
+			}
 
-		if ok {
-			select {
-			case client.Send <- payload:
-			default:
-				h.mu.Lock()
-				close(client.Send)
-				delete(h.clients, userID)
-				h.mu.Unlock()
+		default:
+			// The delivery joins the trace of the request that published
+			// it, possibly on another node
+			_, span := tracing.Start(ctx, "Hub.Deliver", trace.SpanKindConsumer)
+			f := newFrame(msg.Payload)
+			for _, client := range clients {
+				outcome := h.deliver(client, f)
+				span.AddEvent("delivered", trace.WithAttributes(
+					attribute.String("ws.conn.id", client.ConnID),
+					attribute.String("ws.delivery.outcome", outcome.String()),
+				))
 			}
+			span.SetAttributes(
+				attribute.String("ws.frame.type", string(f.msgType)),
+				attribute.Int("ws.connections", len(clients)),
+				attribute.Int("enduser.id", msg.UserID),
+			)
+			span.End()
 		}
 	})
 
+	go h.monitorPresence()
+	go h.writePresence()
+
 	for {
 		select {
 		case client := <-h.Register:
+			if !client.Status.IsSettable() {
+				client.Status = models.PresenceOnline
+			}
+			client.touch()
+
 			h.mu.Lock()
-			h.clients[client.UserID] = client
-			h.sendOnlineListLocked(client)
-			h.broadcastPresenceLocked(client.UserID, models.WSMessageTypeOnline, client.UserID)
+			previous := h.userStatusLocked(client.UserID)
+			if h.clients[client.UserID] == nil {
+				h.clients[client.UserID] = make(map[*Client]struct{})
+			}
+			h.clients[client.UserID][client] = struct{}{}
+			h.queueStatusChangeLocked(client.UserID, previous)
 			h.mu.Unlock()
+			h.flushPresence()
 
 		case client := <-h.Unregister:
 			h.mu.Lock()
-			if _, ok := h.clients[client.UserID]; ok {
-				delete(h.clients, client.UserID)
-				close(client.Send)
-				h.broadcastPresenceLocked(-1, models.WSMessageTypeOffline, client.UserID)
+			h.unsubscribeLocked(client)
+			client.closeSend()
+			if h.registeredLocked(client) {
+				previous := h.userStatusLocked(client.UserID)
+				delete(h.clients[client.UserID], client)
+				// The user's other connections keep them online and typing
+				if len(h.clients[client.UserID]) == 0 {
+					delete(h.clients, client.UserID)
+					go h.typing.stopAll(client.UserID)
+				}
+				h.queueStatusChangeLocked(client.UserID, previous)
 			}
 			h.mu.Unlock()
+			h.flushPresence()
+
+		case <-h.pings:
 		}
 	}
 }
 
+// Ping reports whether the main loop is running and handling registrations.
+// It fails if the loop does not answer before ctx is done.
+func (h *Hub) Ping(ctx context.Context) error {
+	select {
+	case h.pings <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("hub loop not responding: %w", ctx.Err())
+	}
+}
+
 // SendToUser sends a message to a specific user
-func (h *Hub) SendToUser(userID int, message []byte) {
-	if err := h.broker.Publish(context.Background(), userID, message); err != nil {
-		log.Printf("broker error: failed to publish message for user %d: %v", userID, err)
+func (h *Hub) SendToUser(ctx context.Context, userID int, message []byte) {
+	if err := h.broker.Publish(ctx, userID, message); err != nil {
+		slog.ErrorContext(ctx, "broker: failed to publish message", "user_id", userID, "error", err)
+	}
+}
+
+// DisconnectUser closes the user's connections on whichever node holds them.
+// The reason is sent to the client in the close frame.
+func (h *Hub) DisconnectUser(userID int, reason string) {
+	msg := broker.Message{UserID: userID, Kind: broker.KindDisconnect, Payload: []byte(reason)}
+	if err := h.broker.Send(context.Background(), msg); err != nil {
+		slog.Error("broker: failed to publish disconnect", "user_id", userID, "error", err)
 	}
 }
 
-// IsUserOnline checks if a user is currently connected
+// Unwatch stops userID from receiving the presence of watchedID on whichever
+// node holds userID's connection, e.g. after a block between them
+func (h *Hub) Unwatch(userID, watchedID int) {
+	msg := broker.Message{UserID: userID, Kind: broker.KindUnwatch, Payload: []byte(strconv.Itoa(watchedID))}
+	if err := h.broker.Send(context.Background(), msg); err != nil {
+		slog.Error("broker: failed to publish unwatch", "user_id", userID, "error", err)
+	}
+}
+
+// unwatch removes a single presence subscription of the client
+func (h *Hub) unwatch(client *Client, watchedID int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if _, ok := client.subscriptions[watchedID]; !ok {
+		return
+	}
+	delete(client.subscriptions, watchedID)
+	if watchers, ok := h.watchers[watchedID]; ok {
+		delete(watchers, client)
+		if len(watchers) == 0 {
+			delete(h.watchers, watchedID)
+		}
+	}
+}
+
+// SetStatus changes the presence status chosen by the client and notifies others
+func (h *Hub) SetStatus(client *Client, status models.PresenceStatus) {
+	defer h.flushPresence()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.registeredLocked(client) {
+		return
+	}
+
+	client.autoAway.Store(false)
+	h.changeStatusLocked(client, status)
+}
+
+// StartTyping tells the recipient that the sender is typing to them. A
+// typing_stop follows automatically if the sender goes quiet or disconnects.
+func (h *Hub) StartTyping(ctx context.Context, senderID, recipientID int) {
+	h.typing.start(ctx, senderID, recipientID)
+}
+
+// StopTyping tells the recipient that the sender stopped typing to them
+func (h *Hub) StopTyping(senderID, recipientID int) {
+	h.typing.stop(senderID, recipientID)
+}
+
+// SubscribePresence replaces the set of users whose presence the client receives
+// and sends it a snapshot of their current statuses
+func (h *Hub) SubscribePresence(client *Client, userIDs []int) {
+	if len(userIDs) > MaxPresenceSubscriptions {
+		userIDs = userIDs[:MaxPresenceSubscriptions]
+	}
+
+	h.mu.Lock()
+	if !h.registeredLocked(client) {
+		h.mu.Unlock()
+		return
+	}
+	h.unsubscribeLocked(client)
+	client.subscriptions = make(map[int]struct{}, len(userIDs))
+	for _, id := range userIDs {
+		client.subscriptions[id] = struct{}{}
+		if h.watchers[id] == nil {
+			h.watchers[id] = make(map[*Client]struct{})
+		}
+		h.watchers[id][client] = struct{}{}
+	}
+	h.mu.Unlock()
+
+	h.sendPresenceSnapshot(client, userIDs)
+}
+
+// Presence returns the status the user chose, or PresenceOffline when not
+// connected. Callers must hide PresenceInvisible from other users.
+func (h *Hub) Presence(ctx context.Context, userID int) (models.PresenceStatus, error) {
+	return h.presence.get(ctx, userID)
+}
+
+// IsUserOnline checks if a user has a connection to this node
 func (h *Hub) IsUserOnline(userID int) bool {
 	h.mu.RLock()
 	defer h.mu.RUnlock()
@@ -101,51 +350,361 @@ func (h *Hub) GetOnlineUsers() []int {
 	return users
 }
 
-// sendOnlineListLocked sends the current list of online users to the given client.
+// Draining reports whether Shutdown has started. New connections must be refused.
+func (h *Hub) Draining() bool {
+	return h.draining.Load()
+}
+
+// Shutdown moves the local clients to other nodes. Each client gets a
+// reconnect frame with a delay spread over [reconnectDelay, 2*reconnectDelay)
+// so they do not all come back at once. Clients are flushed concurrently and
+// each one is closed as soon as its send queue is empty or flushTimeout has
+// passed, so a slow consumer cannot hold up the others and every client gets
+// a close frame. Shutdown then waits for every client to be unregistered so
+// in-flight frames finish before the caller closes the database. It returns
+// ctx.Err() if ctx expires first.
+func (h *Hub) Shutdown(ctx context.Context, reconnectDelay, flushTimeout time.Duration) error {
+	h.draining.Store(true)
+
+	h.mu.RLock()
+	clients := make([]*Client, 0, len(h.clients))
+	for _, userClients := range h.clients {
+		for c := range userClients {
+			clients = append(clients, c)
+		}
+	}
+	h.mu.RUnlock()
+
+	slog.Info("draining websocket clients", "clients", len(clients))
+
+	var wg sync.WaitGroup
+	for _, c := range clients {
+		delay := reconnectDelay
+		if reconnectDelay > 0 {
+			delay += rand.N(reconnectDelay)
+		}
+		c.queueFrame(models.WSOutgoingMessage{
+			Type:         models.WSMessageTypeReconnect,
+			RetryAfterMS: delay.Milliseconds(),
+			Timestamp:    time.Now(),
+		})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			flushCtx, cancel := context.WithTimeout(ctx, flushTimeout)
+			defer cancel()
+			if err := c.waitFlushed(flushCtx); err != nil {
+				slog.WarnContext(c.Context(), "closing client with unsent frames", "error", err)
+			}
+			c.Close(websocket.CloseServiceRestart, "server shutting down")
+		}()
+	}
+	wg.Wait()
+
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		h.mu.RLock()
+		remaining := len(h.clients)
+		h.mu.RUnlock()
+		if remaining == 0 {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d clients still registered: %w", remaining, ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// DeliveryStats returns the delivery counters of this node keyed by message
+// class ("ephemeral" or "reliable")
+func (h *Hub) DeliveryStats() map[string]DeliveryStats {
+	return h.delivery.snapshot()
+}
+
+// deliver queues a frame for a local client and records the outcome
+func (h *Hub) deliver(client *Client, f *Frame) deliveryOutcome {
+	class := classify(f.msgType)
+	outcome := client.enqueue(f, class)
+	h.delivery.record(class, outcome)
+	recordSent(f.msgType, outcome)
+	if outcome == outcomeDisconnected {
+		slog.WarnContext(client.Context(), "disconnecting slow consumer")
+	}
+	return outcome
+}
+
+// markActive brings a client that was automatically marked away back online
+func (h *Hub) markActive(client *Client) {
+	defer h.flushPresence()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if !h.registeredLocked(client) || !client.autoAway.CompareAndSwap(true, false) {
+		return
+	}
+	h.changeStatusLocked(client, models.PresenceOnline)
+}
+
+// monitorPresence periodically marks idle clients away
+func (h *Hub) monitorPresence() {
+	ticker := time.NewTicker(presenceCheckPeriod)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		idleSince := time.Now().Add(-idleTimeout).UnixNano()
+
+		h.mu.Lock()
+		for _, userClients := range h.clients {
+			for c := range userClients {
+				if c.Status == models.PresenceOnline && c.lastActivity.Load() < idleSince {
+					c.autoAway.Store(true)
+					h.changeStatusLocked(c, models.PresenceAway)
+				}
+			}
+		}
+		h.mu.Unlock()
+
+		h.flushPresence()
+	}
+}
+
+// changeStatusLocked sets the client's new status and queues telling other
+// clients if it changes the user's status. Must be called with h.mu held, and
+// followed by flushPresence once it is released.
+func (h *Hub) changeStatusLocked(client *Client, status models.PresenceStatus) {
+	if client.Status == status {
+		return
+	}
+
+	previous := h.userStatusLocked(client.UserID)
+	client.Status = status
+	h.queueStatusChangeLocked(client.UserID, previous)
+}
+
+// userStatusLocked returns the status of a user across their local
+// connections, PresenceOffline if they have none, ranked by statusRanks. Must
+// be called with h.mu held.
+func (h *Hub) userStatusLocked(userID int) models.PresenceStatus {
+	status := models.PresenceOffline
+	for c := range h.clients[userID] {
+		if statusRanks[c.Status] > statusRanks[status] {
+			status = c.Status
+		}
+	}
+	return status
+}
+
+// queueStatusChangeLocked queues storing the user's status on this node if it
+// differs from previous. Must be called with h.mu held.
+func (h *Hub) queueStatusChangeLocked(userID int, previous models.PresenceStatus) {
+	if status := h.userStatusLocked(userID); status != previous {
+		h.queuePresenceLocked(presenceEvent{userID: userID, status: status})
+	}
+}
+
+// announced returns the status other users see for status
+func announced(status models.PresenceStatus) models.PresenceStatus {
+	if status == models.PresenceInvisible {
+		return models.PresenceOffline
+	}
+	return status
+}
+
+// registeredLocked reports whether the client is registered with the hub.
 // Must be called with h.mu held.
-func (h *Hub) sendOnlineListLocked(client *Client) {
-	onlineUsers := make([]int, 0, len(h.clients))
-	for id := range h.clients {
-		onlineUsers = append(onlineUsers, id)
+func (h *Hub) registeredLocked(client *Client) bool {
+	_, ok := h.clients[client.UserID][client]
+	return ok
+}
+
+// userClients returns the local connections of a user
+func (h *Hub) userClients(userID int) []*Client {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	clients := make([]*Client, 0, len(h.clients[userID]))
+	for c := range h.clients[userID] {
+		clients = append(clients, c)
 	}
+	return clients
+}
 
-	outgoing := models.WSOutgoingMessage{
+func (h *Hub) recordLastSeen(userID int, at time.Time) {
+	if err := h.userRepo.UpdateLastSeen(context.Background(), userID, at); err != nil {
+		slog.Error("failed to record last seen", "user_id", userID, "error", err)
+	}
+}
+
+// sendPresenceSnapshot sends the client the current status of the given users.
+// Invisible users are reported offline.
+func (h *Hub) sendPresenceSnapshot(client *Client, userIDs []int) {
+	statuses, err := h.presence.getMany(context.Background(), userIDs)
+	if err != nil {
+		slog.ErrorContext(client.Context(), "presence: failed to load statuses", "error", err)
+		return
+	}
+
+	onlineUsers := make([]int, 0, len(statuses))
+	for id, status := range statuses {
+		if status == models.PresenceInvisible {
+			statuses[id] = models.PresenceOffline
+			continue
+		}
+		if status != models.PresenceOffline {
+			onlineUsers = append(onlineUsers, id)
+		}
+	}
+
+	f, err := encodeFrame(models.WSOutgoingMessage{
 		Type:        models.WSMessageTypePresence,
 		OnlineUsers: onlineUsers,
+		Statuses:    statuses,
 		Timestamp:   time.Now(),
-	}
-	data, err := json.Marshal(outgoing)
+	})
 	if err != nil {
-		log.Printf("Error marshaling presence list: %v", err)
+		slog.Error("failed to marshal presence list", "error", err)
 		return
 	}
+
+	// The client may have disconnected while statuses were loading
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if !h.registeredLocked(client) {
+		return
+	}
+	h.deliver(client, f)
+}
+
+// unsubscribeLocked removes all of the client's presence subscriptions.
+// Must be called with h.mu held.
+func (h *Hub) unsubscribeLocked(client *Client) {
+	for id := range client.subscriptions {
+		if watchers, ok := h.watchers[id]; ok {
+			delete(watchers, client)
+			if len(watchers) == 0 {
+				delete(h.watchers, id)
+			}
+		}
+	}
+	client.subscriptions = nil
+}
+
+// queuePresenceLocked records a presence change for the next flushPresence.
+// Must be called with h.mu held.
+func (h *Hub) queuePresenceLocked(event presenceEvent) {
+	h.pendingPresence = append(h.pendingPresence, event)
+}
+
+// flushPresence hands the queued presence changes to writePresence without
+// waiting for them to be written
+func (h *Hub) flushPresence() {
 	select {
-	case client.Send <- data:
+	case h.presenceWake <- struct{}{}:
 	default:
+		// A wake-up is already pending and will see these changes
 	}
 }
 
-// broadcastPresenceLocked notifies all clients (except excludeUserID) about a user's status change.
-// Pass excludeUserID = -1 to notify all clients.
-// Must be called with h.mu held.
-func (h *Hub) broadcastPresenceLocked(excludeUserID int, msgType models.WSMessageType, userID int) {
+// writePresence is the only goroutine that writes presence to Redis. It
+// stores the queued changes in order, announces the ones that change what
+// other users see of the user across all nodes, and periodically rewrites
+// this node's entries so they do not expire.
+func (h *Hub) writePresence() {
+	ticker := time.NewTicker(presenceCheckPeriod)
+	defer ticker.Stop()
+
+	for {
+		var refresh bool
+		select {
+		case <-h.presenceWake:
+		case <-ticker.C:
+			refresh = true
+		}
+
+		h.mu.Lock()
+		events := h.pendingPresence
+		h.pendingPresence = nil
+		var statuses map[int]models.PresenceStatus
+		if refresh {
+			statuses = make(map[int]models.PresenceStatus, len(h.clients))
+			for id := range h.clients {
+				statuses[id] = h.userStatusLocked(id)
+			}
+		}
+		h.mu.Unlock()
+
+		for _, event := range events {
+			h.writePresenceEvent(event)
+		}
+		if err := h.presence.refresh(context.Background(), statuses); err != nil {
+			slog.Error("presence: failed to refresh entries", "error", err)
+		}
+	}
+}
+
+// writePresenceEvent stores a user's status on this node and tells the
+// subscribers if the user's status across all nodes changed. Going invisible
+// looks like going offline, and coming back looks like coming online.
+func (h *Hub) writePresenceEvent(event presenceEvent) {
+	before, after, err := h.presence.update(context.Background(), event.userID, event.status)
+	if err != nil {
+		slog.Error("presence: failed to store status", "user_id", event.userID, "error", err)
+		return
+	}
+
+	if announced(before) == announced(after) {
+		return
+	}
+	h.publishPresence(event.userID, announced(after))
+	// The user left their last node while visible
+	if after == models.PresenceOffline {
+		go h.recordLastSeen(event.userID, time.Now())
+	}
+}
+
+// publishPresence announces a user's status to the subscribers of that user on
+// every node
+func (h *Hub) publishPresence(userID int, status models.PresenceStatus) {
+	msgType := models.WSMessageTypeOnline
+	if status == models.PresenceOffline {
+		msgType = models.WSMessageTypeOffline
+	}
+
 	outgoing := models.WSOutgoingMessage{
 		Type:      msgType,
 		SenderID:  userID,
+		Status:    status,
 		Timestamp: time.Now(),
 	}
 	data, err := json.Marshal(outgoing)
 	if err != nil {
-		log.Printf("Error marshaling presence message: %v", err)
+		slog.Error("failed to marshal presence message", "error", err)
 		return
 	}
-	for id, c := range h.clients {
-		if id == excludeUserID {
-			continue
-		}
-		select {
-		case c.Send <- data:
-		default:
-		}
+
+	msg := broker.Message{UserID: userID, Kind: broker.KindPresence, Payload: data}
+	if err := h.broker.Send(context.Background(), msg); err != nil {
+		slog.Error("broker: failed to publish presence", "user_id", userID, "error", err)
+	}
+}
+
+// deliverPresence hands a presence frame about userID to the local clients
+// subscribed to that user
+func (h *Hub) deliverPresence(userID int, payload []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	// One frame for every watcher, so it is encoded at most once per codec
+	f := newFrame(payload)
+	for c := range h.watchers[userID] {
+		h.deliver(c, f)
 	}
 }
@@ -0,0 +1,329 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	broker "github.com/HaykAghajanyan/chat-backend/internal/brocker"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jmoiron/sqlx"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestHub runs a hub against an in-memory Redis. Database writes such as
+// last seen fail and are only logged.
+func newTestHub(t *testing.T) (*Hub, *miniredis.Miniredis) {
+	t.Helper()
+
+	mr := miniredis.RunT(t)
+	return newTestHubOn(t, mr), mr
+}
+
+// newTestHubOn runs another node sharing the Redis of mr
+func newTestHubOn(t *testing.T, mr *miniredis.Miniredis) *Hub {
+	t.Helper()
+
+	b := broker.New(mr.Addr())
+	t.Cleanup(func() { b.Close() })
+
+	db, _, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	sqlxDB := sqlx.NewDb(db, "postgres")
+
+	h := NewHub(b, repository.NewUserRepository(sqlxDB), repository.NewBlockRepository(sqlxDB))
+	go h.Run()
+	return h
+}
+
+// frameReader reads the frames of a stream client one at a time
+type frameReader struct {
+	client *Client
+	frames [][]byte
+}
+
+func (r *frameReader) next(t *testing.T) models.WSOutgoingMessage {
+	t.Helper()
+
+	if len(r.frames) == 0 {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+
+		frames, err := r.client.Receive(ctx)
+		if err != nil {
+			t.Fatalf("no frame received: %v", err)
+		}
+		r.frames = frames
+	}
+
+	var msg models.WSOutgoingMessage
+	if err := json.Unmarshal(r.frames[0], &msg); err != nil {
+		t.Fatal(err)
+	}
+	r.frames = r.frames[1:]
+	return msg
+}
+
+// waitPresence waits for the stored status of a user to become want. Presence
+// is written to Redis in the background.
+func waitPresence(t *testing.T, h *Hub, userID int, want models.PresenceStatus) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		got, err := h.Presence(context.Background(), userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("stored status = %q, want %q", got, want)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubPresenceChanges(t *testing.T) {
+	h, mr := newTestHub(t)
+
+	watcher := NewStreamClient(2, Config{}, models.PresenceOnline)
+	h.Register <- watcher
+	// Ping returns once the loop has handled the registration
+	h.Ping(context.Background())
+	h.SubscribePresence(watcher, []int{1})
+	frames := &frameReader{client: watcher}
+	if msg := frames.next(t); msg.Type != models.WSMessageTypePresence {
+		t.Fatalf("first frame = %s, want the presence snapshot", msg.Type)
+	}
+
+	user := NewStreamClient(1, Config{}, models.PresenceOnline)
+	h.Register <- user
+
+	steps := []struct {
+		set        models.PresenceStatus
+		wantType   models.WSMessageType
+		wantFrame  models.PresenceStatus
+		wantStored models.PresenceStatus
+	}{
+		{"", models.WSMessageTypeOnline, models.PresenceOnline, models.PresenceOnline},
+		{models.PresenceAway, models.WSMessageTypeOnline, models.PresenceAway, models.PresenceAway},
+		{models.PresenceInvisible, models.WSMessageTypeOffline, models.PresenceOffline, models.PresenceInvisible},
+		{models.PresenceOnline, models.WSMessageTypeOnline, models.PresenceOnline, models.PresenceOnline},
+	}
+	for _, step := range steps {
+		if step.set != "" {
+			h.SetStatus(user, step.set)
+		}
+
+		msg := frames.next(t)
+		if msg.Type != step.wantType || msg.SenderID != 1 || msg.Status != step.wantFrame {
+			t.Fatalf("after %q: frame = %s %d %q, want %s %q", step.set, msg.Type, msg.SenderID, msg.Status, step.wantType, step.wantFrame)
+		}
+		// The frame is published after the status is stored
+		if got, _ := h.Presence(context.Background(), 1); got != step.wantStored {
+			t.Fatalf("after %q: stored status = %q, want %q", step.set, got, step.wantStored)
+		}
+	}
+
+	h.Unregister <- user
+	if msg := frames.next(t); msg.Type != models.WSMessageTypeOffline {
+		t.Fatalf("frame after unregister = %s, want offline", msg.Type)
+	}
+	if mr.Exists(presenceKey(1)) {
+		t.Fatal("presence entries still stored after unregister")
+	}
+}
+
+func TestHubQueuesPresenceWhileLocked(t *testing.T) {
+	h, _ := newTestHub(t)
+
+	user := NewStreamClient(1, Config{}, models.PresenceOnline)
+	h.Register <- user
+	waitPresence(t, h, 1, models.PresenceOnline)
+
+	// Status changes made under the lock reach Redis only on flush
+	h.mu.Lock()
+	h.changeStatusLocked(user, models.PresenceAway)
+	h.changeStatusLocked(user, models.PresenceBusy)
+	h.mu.Unlock()
+	time.Sleep(50 * time.Millisecond)
+	if got, _ := h.Presence(context.Background(), 1); got != models.PresenceOnline {
+		t.Fatalf("stored status before flush = %q, want online", got)
+	}
+
+	h.flushPresence()
+	waitPresence(t, h, 1, models.PresenceBusy)
+}
+
+func TestHubLoopDoesNotWaitForRedis(t *testing.T) {
+	h, _ := newTestHub(t)
+
+	// A Redis that accepts connections and never answers
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	stuck := redis.NewClient(&redis.Options{Addr: ln.Addr().String(), MaxRetries: -1})
+	t.Cleanup(func() { stuck.Close() })
+	h.presence.redis = stuck
+
+	for id := 1; id <= 3; id++ {
+		h.Register <- NewStreamClient(id, Config{}, models.PresenceOnline)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := h.Ping(ctx); err != nil {
+		t.Fatalf("loop blocked by presence writes: %v", err)
+	}
+}
+
+func TestHubUnwatch(t *testing.T) {
+	h, _ := newTestHub(t)
+
+	watcher := NewStreamClient(2, Config{}, models.PresenceOnline)
+	h.Register <- watcher
+	h.Ping(context.Background())
+	h.SubscribePresence(watcher, []int{1, 3})
+
+	h.Unwatch(2, 1)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		h.mu.RLock()
+		_, watching := h.watchers[1][watcher]
+		_, others := h.watchers[3][watcher]
+		h.mu.RUnlock()
+
+		if !watching {
+			if !others {
+				t.Fatal("Unwatch removed other subscriptions")
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("subscription still present after Unwatch")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHubMultipleConnections(t *testing.T) {
+	h, _ := newTestHub(t)
+
+	watcher := NewStreamClient(2, Config{}, models.PresenceOnline)
+	h.Register <- watcher
+	h.Ping(context.Background())
+	h.SubscribePresence(watcher, []int{1})
+	presence := &frameReader{client: watcher}
+	presence.next(t)
+
+	phone := NewStreamClient(1, Config{}, models.PresenceOnline)
+	desktop := NewStreamClient(1, Config{}, models.PresenceAway)
+	h.Register <- phone
+	h.Register <- desktop
+	if msg := presence.next(t); msg.Type != models.WSMessageTypeOnline {
+		t.Fatalf("frame = %s, want online", msg.Type)
+	}
+
+	// Every connection of the user gets the frame, and neither is closed
+	h.SendToUser(context.Background(), 1, []byte(`{"type":"chat","message_id":7}`))
+	for _, c := range []*Client{phone, desktop} {
+		if msg := (&frameReader{client: c}).next(t); msg.MessageID != 7 {
+			t.Fatalf("frame = %+v, want message 7", msg)
+		}
+	}
+	select {
+	case <-phone.Done():
+		t.Fatalf("first connection was closed: %s", phone.CloseReason())
+	default:
+	}
+
+	// The phone keeps the user online while the desktop is away
+	waitPresence(t, h, 1, models.PresenceOnline)
+	h.Unregister <- phone
+	if msg := presence.next(t); msg.Type != models.WSMessageTypeOnline || msg.Status != models.PresenceAway {
+		t.Fatalf("frame after the phone left = %s %q, want away", msg.Type, msg.Status)
+	}
+
+	h.Unregister <- desktop
+	if msg := presence.next(t); msg.Type != models.WSMessageTypeOffline {
+		t.Fatalf("frame after the last connection left = %s, want offline", msg.Type)
+	}
+	if h.IsUserOnline(1) {
+		t.Fatal("user still online without connections")
+	}
+}
+
+func TestHubPresenceAcrossNodes(t *testing.T) {
+	a, mr := newTestHub(t)
+	b := newTestHubOn(t, mr)
+
+	watcher := NewStreamClient(2, Config{}, models.PresenceOnline)
+	a.Register <- watcher
+	a.Ping(context.Background())
+	a.SubscribePresence(watcher, []int{1})
+	presence := &frameReader{client: watcher}
+	presence.next(t)
+
+	onA := NewStreamClient(1, Config{}, models.PresenceOnline)
+	onB := NewStreamClient(1, Config{}, models.PresenceOnline)
+	a.Register <- onA
+	if msg := presence.next(t); msg.Type != models.WSMessageTypeOnline {
+		t.Fatalf("frame = %s, want online", msg.Type)
+	}
+	b.Register <- onB
+	waitPresence(t, a, 1, models.PresenceOnline)
+
+	// Leaving node B keeps the user online through node A, so the next frame
+	// is the status change rather than an offline
+	b.Unregister <- onB
+	time.Sleep(50 * time.Millisecond)
+	waitPresence(t, a, 1, models.PresenceOnline)
+	a.SetStatus(onA, models.PresenceBusy)
+	if msg := presence.next(t); msg.Type != models.WSMessageTypeOnline || msg.Status != models.PresenceBusy {
+		t.Fatalf("frame = %s %q, want busy", msg.Type, msg.Status)
+	}
+
+	a.Unregister <- onA
+	if msg := presence.next(t); msg.Type != models.WSMessageTypeOffline {
+		t.Fatalf("frame after the last node = %s, want offline", msg.Type)
+	}
+	waitPresence(t, b, 1, models.PresenceOffline)
+}
+
+func TestMergePresence(t *testing.T) {
+	now := time.Now()
+	entries := map[string]string{
+		"a": presenceEntry(models.PresenceAway, now),
+		"b": presenceEntry(models.PresenceBusy, now.Add(-time.Minute)),
+		// A crashed node stopped refreshing its entry
+		"c": presenceEntry(models.PresenceOnline, now.Add(-presenceTTL-time.Second)),
+		"d": "garbage",
+	}
+	if got := mergePresence(entries, now); got != models.PresenceBusy {
+		t.Fatalf("mergePresence = %q, want busy", got)
+	}
+	if got := mergePresence(nil, now); got != models.PresenceOffline {
+		t.Fatalf("mergePresence of no entries = %q, want offline", got)
+	}
+}
@@ -0,0 +1,80 @@
+package websocket
+
+import (
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	framesReceived = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chat_ws_frames_received_total",
+			Help: "Frames received from websocket clients by type.",
+		},
+		[]string{"type"},
+	)
+	framesSent = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chat_ws_frames_sent_total",
+			Help: "Frames queued for local clients by type.",
+		},
+		[]string{"type"},
+	)
+	deliveries = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "chat_ws_deliveries_total",
+			Help: "Outcome of queueing frames for local clients. The dropped and disconnected outcomes are send-buffer drops.",
+		},
+		[]string{"class", "outcome"},
+	)
+)
+
+// clientFrameTypes are the frame types clients may send. Anything else is
+// counted as unknown so clients cannot create arbitrary series.
+var clientFrameTypes = map[models.WSMessageType]bool{
+	models.WSMessageTypeChat:              true,
+	models.WSMessageTypeTyping:            true,
+	models.WSMessageTypeTypingStart:       true,
+	models.WSMessageTypeTypingStop:        true,
+	models.WSMessageTypeRead:              true,
+	models.WSMessageTypePresenceSet:       true,
+	models.WSMessageTypePresenceSubscribe: true,
+}
+
+func receivedTypeLabel(msgType models.WSMessageType) string {
+	if clientFrameTypes[msgType] {
+		return string(msgType)
+	}
+	return "unknown"
+}
+
+func recordSent(msgType models.WSMessageType, outcome deliveryOutcome) {
+	if outcome != outcomeDelivered && outcome != outcomeBuffered {
+		return
+	}
+	if msgType == "" {
+		msgType = "unknown"
+	}
+	framesSent.WithLabelValues(string(msgType)).Inc()
+}
+
+// RegisterMetrics exposes the number of clients connected to this node,
+// including the fallback transports
+func RegisterMetrics(h *Hub) {
+	promauto.NewGaugeFunc(
+		prometheus.GaugeOpts{
+			Name: "chat_ws_connections",
+			Help: "Clients connected to this node.",
+		},
+		func() float64 {
+			h.mu.RLock()
+			defer h.mu.RUnlock()
+			var n int
+			for _, userClients := range h.clients {
+				n += len(userClients)
+			}
+			return float64(n)
+		},
+	)
+}
@@ -0,0 +1,157 @@
+package websocket
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// Presence entries expire unless the owning node refreshes them, so a
+	// crashed node cannot leave users online forever
+	presenceTTL = 2 * time.Minute
+
+	// How often the hub refreshes presence entries and checks for idle clients
+	presenceCheckPeriod = 30 * time.Second
+
+	// Clients without inbound frames for this long are marked away
+	idleTimeout = 5 * time.Minute
+)
+
+// MaxPresenceSubscriptions is the number of users a single client can
+// subscribe to
+const MaxPresenceSubscriptions = 1000
+
+// statusRanks orders the statuses of a user's connections: the user has the
+// highest ranked status among them, so an idle tab does not make a user away
+// while their phone is active
+var statusRanks = map[models.PresenceStatus]int{
+	models.PresenceInvisible: 1,
+	models.PresenceAway:      2,
+	models.PresenceBusy:      3,
+	models.PresenceOnline:    4,
+}
+
+// presenceStore keeps every connected user's status in Redis so it can be read
+// from any node. Each user has a hash with one entry per node they are
+// connected to, so a node removes only its own entry when the user leaves it
+// and the hash is gone once the last node did.
+type presenceStore struct {
+	redis *redis.Client
+	// node names this node's entries
+	node string
+}
+
+func presenceKey(userID int) string {
+	return "presence:nodes:" + strconv.Itoa(userID)
+}
+
+// presenceEntry is the value of a node's entry: the status and when the node
+// last wrote it, so entries of a crashed node are ignored after presenceTTL
+func presenceEntry(status models.PresenceStatus, at time.Time) string {
+	return string(status) + "|" + strconv.FormatInt(at.UnixMilli(), 10)
+}
+
+// mergePresence returns the highest ranked status of the fresh entries,
+// PresenceOffline if there are none
+func mergePresence(entries map[string]string, now time.Time) models.PresenceStatus {
+	status := models.PresenceOffline
+	for _, entry := range entries {
+		s, at, _ := strings.Cut(entry, "|")
+		ms, err := strconv.ParseInt(at, 10, 64)
+		if err != nil || now.Sub(time.UnixMilli(ms)) > presenceTTL {
+			continue
+		}
+		if rank := statusRanks[models.PresenceStatus(s)]; rank > statusRanks[status] {
+			status = models.PresenceStatus(s)
+		}
+	}
+	return status
+}
+
+// update stores the user's status on this node, PresenceOffline to remove it,
+// and returns the user's status across all nodes before and after
+func (s *presenceStore) update(ctx context.Context, userID int, status models.PresenceStatus) (before, after models.PresenceStatus, err error) {
+	key := presenceKey(userID)
+	now := time.Now()
+
+	var current *redis.MapStringStringCmd
+	_, err = s.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		current = pipe.HGetAll(ctx, key)
+		if status == models.PresenceOffline {
+			pipe.HDel(ctx, key, s.node)
+		} else {
+			pipe.HSet(ctx, key, s.node, presenceEntry(status, now))
+			pipe.PExpire(ctx, key, presenceTTL)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("failed to update presence: %w", err)
+	}
+
+	entries := current.Val()
+	before = mergePresence(entries, now)
+	if status == models.PresenceOffline {
+		delete(entries, s.node)
+	} else {
+		entries[s.node] = presenceEntry(status, now)
+	}
+	return before, mergePresence(entries, now), nil
+}
+
+// get returns the stored status, PresenceOffline if the user is not connected
+func (s *presenceStore) get(ctx context.Context, userID int) (models.PresenceStatus, error) {
+	entries, err := s.redis.HGetAll(ctx, presenceKey(userID)).Result()
+	if err != nil {
+		return "", err
+	}
+	return mergePresence(entries, time.Now()), nil
+}
+
+// getMany returns the stored status of each user, PresenceOffline for users that
+// are not connected
+func (s *presenceStore) getMany(ctx context.Context, userIDs []int) (map[int]models.PresenceStatus, error) {
+	statuses := make(map[int]models.PresenceStatus, len(userIDs))
+	if len(userIDs) == 0 {
+		return statuses, nil
+	}
+
+	pipe := s.redis.Pipeline()
+	cmds := make([]*redis.MapStringStringCmd, len(userIDs))
+	for i, id := range userIDs {
+		cmds[i] = pipe.HGetAll(ctx, presenceKey(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	for i, id := range userIDs {
+		statuses[id] = mergePresence(cmds[i].Val(), now)
+	}
+	return statuses, nil
+}
+
+// refresh rewrites this node's entries for the given users so they do not expire
+func (s *presenceStore) refresh(ctx context.Context, statuses map[int]models.PresenceStatus) error {
+	if len(statuses) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	pipe := s.redis.Pipeline()
+	for id, status := range statuses {
+		pipe.HSet(ctx, presenceKey(id), s.node, presenceEntry(status, now))
+		pipe.PExpire(ctx, presenceKey(id), presenceTTL)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return fmt.Errorf("failed to refresh presence: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package websocket
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+)
+
+// Subprotocols negotiated through Sec-WebSocket-Protocol. Clients that do not
+// ask for one get ProtocolV1.
+const (
+	// ProtocolV1 is the original protocol: no hello, no error frames and a single
+	// "typing" frame instead of typing_start/typing_stop
+	ProtocolV1 = "schat.v1"
+	// ProtocolV2 adds the hello handshake, error frames and request IDs
+	ProtocolV2 = "schat.v2"
+	// ProtocolV2MsgPack carries ProtocolV2 frames as MessagePack in binary
+	// messages, see msgpackCodec
+	ProtocolV2MsgPack = "schat.v2+msgpack"
+)
+
+// Subprotocols lists the supported subprotocols in order of preference
+var Subprotocols = []string{ProtocolV2MsgPack, ProtocolV2, ProtocolV1}
+
+// Application close codes sent in close frames
+const (
+	// CloseSlowConsumer is sent when a client falls too far behind on its
+	// frames, see Client.enqueue
+	CloseSlowConsumer = 4008
+)
+
+// ServerVersion is reported in the hello frame. Set at build time with
+// -ldflags "-X github.com/HaykAghajanyan/chat-backend/internal/websocket.ServerVersion=..."
+var ServerVersion = "dev"
+
+// Capabilities advertised in the hello frame
+var Capabilities = []string{
+	"rich_presence",
+	"presence_subscribe",
+	"typing_ttl",
+	"error_frames",
+	"reconnect",
+	"rate_limits",
+	"msgpack",
+}
+
+// ProtocolError is returned by message handlers to report a bad frame back to the client
+type ProtocolError struct {
+	Code    models.WSErrorCode
+	Message string
+	// RetryAfter is reported to the client for rate_limited errors
+	RetryAfter time.Duration
+}
+
+func (e *ProtocolError) Error() string {
+	return string(e.Code) + ": " + e.Message
+}
+
+func NewProtocolError(code models.WSErrorCode, message string) *ProtocolError {
+	return &ProtocolError{Code: code, Message: message}
+}
+
+// Versioned reports whether the client negotiated a protocol with hello and error frames
+func (c *Client) Versioned() bool {
+	return c.Protocol != "" && c.Protocol != ProtocolV1
+}
+
+// SendHello queues the hello frame. It is a no-op for ProtocolV1 clients.
+func (c *Client) SendHello() {
+	if !c.Versioned() {
+		return
+	}
+
+	c.queueFrame(models.WSOutgoingMessage{
+		Type:          models.WSMessageTypeHello,
+		Protocol:      c.Protocol,
+		ServerVersion: ServerVersion,
+		Capabilities:  Capabilities,
+		Timestamp:     time.Now(),
+	})
+}
+
+// SendError reports a failed frame to the client. Errors that are not a
+// *ProtocolError are reported as internal errors without details. ProtocolV1
+// clients only get a log line.
+func (c *Client) SendError(requestID string, err error) {
+	var protoErr *ProtocolError
+	if !errors.As(err, &protoErr) {
+		slog.ErrorContext(c.Context(), "failed to handle frame", "request_id", requestID, "error", err)
+		protoErr = NewProtocolError(models.WSErrorInternal, "internal error")
+	}
+
+	if !c.Versioned() {
+		slog.InfoContext(c.Context(), "rejected frame", "code", protoErr.Code, "error", protoErr.Message)
+		return
+	}
+
+	c.queueFrame(models.WSOutgoingMessage{
+		Type:         models.WSMessageTypeError,
+		RequestID:    requestID,
+		Code:         protoErr.Code,
+		Error:        protoErr.Message,
+		RetryAfterMS: protoErr.RetryAfter.Milliseconds(),
+		Timestamp:    time.Now(),
+	})
+}
+
+// queueFrame sends a frame to this connection only
+func (c *Client) queueFrame(outgoing models.WSOutgoingMessage) {
+	f, err := encodeFrame(outgoing)
+	if err != nil {
+		slog.ErrorContext(c.Context(), "failed to marshal frame", "type", outgoing.Type, "error", err)
+		return
+	}
+
+	recordSent(outgoing.Type, c.enqueue(f, classReliable))
+}
+
+// translateBatchForV1 translates every frame of a batch, dropping those without
+// a V1 equivalent
+func translateBatchForV1(batch []*Frame) []*Frame {
+	translated := batch[:0]
+	for _, f := range batch {
+		if f, ok := translateForV1(f); ok {
+			translated = append(translated, f)
+		}
+	}
+	return translated
+}
+
+// translateForV1 rewrites a frame for ProtocolV1 clients. It returns false if the
+// frame has no V1 equivalent and must be dropped.
+func translateForV1(f *Frame) (*Frame, bool) {
+	switch f.msgType {
+	case models.WSMessageTypeTypingStart:
+		var outgoing models.WSOutgoingMessage
+		if err := json.Unmarshal(f.data, &outgoing); err != nil {
+			return f, true
+		}
+		outgoing.Type = models.WSMessageTypeTyping
+		translated, err := encodeFrame(outgoing)
+		if err != nil {
+			return f, true
+		}
+		return translated, true
+	case models.WSMessageTypeTypingStop, models.WSMessageTypeHello, models.WSMessageTypeError, models.WSMessageTypeReconnect:
+		return nil, false
+	}
+
+	return f, true
+}
@@ -0,0 +1,78 @@
+package websocket
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+)
+
+// ErrClientClosed is returned by Receive once the client was closed or unregistered
+var ErrClientClosed = errors.New("client closed")
+
+// stream is the state of a client that is not backed by a websocket
+// connection. Its frames are read with Receive by an HTTP handler.
+type stream struct {
+	done      chan struct{}
+	closeOnce sync.Once
+	reason    string
+}
+
+// NewStreamClient creates a client for the fallback transports (Server-Sent
+// Events and long-polling). It speaks ProtocolV2 and goes through the same
+// hub delivery path as websocket clients; the caller registers it with the
+// hub and reads its frames with Receive.
+func NewStreamClient(userID int, config Config, status models.PresenceStatus) *Client {
+	config = config.WithDefaults()
+	return &Client{
+		UserID:   userID,
+		Send:     make(chan *Frame, config.SendBufferSize),
+		Protocol: ProtocolV2,
+		Config:   config,
+		Status:   status,
+		stream:   &stream{done: make(chan struct{})},
+	}
+}
+
+// Receive waits for the next batch of frames of a stream client
+func (c *Client) Receive(ctx context.Context) ([][]byte, error) {
+	select {
+	case f, ok := <-c.Send:
+		if !ok {
+			return nil, ErrClientClosed
+		}
+		batch := c.takePending([]*Frame{f})
+		frames := make([][]byte, len(batch))
+		for i, f := range batch {
+			frames[i] = f.JSON()
+		}
+		return frames, nil
+	case <-c.stream.done:
+		return nil, ErrClientClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Done is closed when a stream client is closed by the server
+func (c *Client) Done() <-chan struct{} {
+	return c.stream.done
+}
+
+// CloseReason returns the reason passed to Close for a stream client
+func (c *Client) CloseReason() string {
+	select {
+	case <-c.stream.done:
+		return c.stream.reason
+	default:
+		return ""
+	}
+}
+
+func (s *stream) close(reason string) {
+	s.closeOnce.Do(func() {
+		s.reason = reason
+		close(s.done)
+	})
+}
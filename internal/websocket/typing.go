@@ -0,0 +1,163 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+)
+
+const (
+	// A typing indicator expires unless the client repeats typing_start within this time
+	typingTTL = 6 * time.Second
+
+	// Repeated typing_start frames are forwarded at most this often
+	typingThrottle = 3 * time.Second
+)
+
+type typingKey struct {
+	sender    int
+	recipient int
+}
+
+type typingState struct {
+	timer       *time.Timer
+	lastForward time.Time
+	suppressed  bool
+}
+
+// typingTracker keeps server-side typing state per (sender, recipient) pair so
+// that a typing_stop is always emitted: explicitly, on expiry, or when the
+// sender disconnects
+type typingTracker struct {
+	mu        sync.Mutex
+	states    map[typingKey]*typingState
+	send      func(ctx context.Context, userID int, payload []byte)
+	blockRepo *repository.BlockRepository
+
+	// typingTTL and typingThrottle, shortened by tests
+	ttl      time.Duration
+	throttle time.Duration
+}
+
+func newTypingTracker(send func(ctx context.Context, userID int, payload []byte), blockRepo *repository.BlockRepository) *typingTracker {
+	return &typingTracker{
+		states:    make(map[typingKey]*typingState),
+		send:      send,
+		blockRepo: blockRepo,
+		ttl:       typingTTL,
+		throttle:  typingThrottle,
+	}
+}
+
+// start marks sender as typing to recipient. The recipient is only told about
+// the first start and then at most once per typingThrottle.
+func (t *typingTracker) start(ctx context.Context, sender, recipient int) {
+	key := typingKey{sender: sender, recipient: recipient}
+	now := time.Now()
+
+	t.mu.Lock()
+	state, ok := t.states[key]
+	if ok {
+		state.timer.Reset(t.ttl)
+		if state.suppressed || now.Sub(state.lastForward) < t.throttle {
+			t.mu.Unlock()
+			return
+		}
+		state.lastForward = now
+		t.mu.Unlock()
+
+		t.emit(ctx, models.WSMessageTypeTypingStart, key)
+		return
+	}
+	t.mu.Unlock()
+
+	// Typing is never shown to a recipient that blocked the sender. The state is
+	// still tracked so repeated frames don't hit the database.
+	blocked, err := t.blockRepo.IsBlocked(ctx, recipient, sender)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to check block for typing indicator", "error", err)
+		blocked = true
+	}
+
+	t.mu.Lock()
+	if _, ok := t.states[key]; ok {
+		// Another frame from the same sender won the race
+		t.mu.Unlock()
+		return
+	}
+	t.states[key] = &typingState{
+		timer:       time.AfterFunc(t.ttl, func() { t.expire(key) }),
+		lastForward: now,
+		suppressed:  blocked,
+	}
+	t.mu.Unlock()
+
+	if !blocked {
+		t.emit(ctx, models.WSMessageTypeTypingStart, key)
+	}
+}
+
+// stop ends the typing state of sender towards recipient, if any
+func (t *typingTracker) stop(sender, recipient int) {
+	key := typingKey{sender: sender, recipient: recipient}
+
+	t.mu.Lock()
+	state, ok := t.states[key]
+	if ok {
+		state.timer.Stop()
+		delete(t.states, key)
+	}
+	t.mu.Unlock()
+
+	if ok && !state.suppressed {
+		t.emit(context.Background(), models.WSMessageTypeTypingStop, key)
+	}
+}
+
+// stopAll ends every typing state of the sender, used when it disconnects
+func (t *typingTracker) stopAll(sender int) {
+	var stopped []typingKey
+
+	t.mu.Lock()
+	for key, state := range t.states {
+		if key.sender != sender {
+			continue
+		}
+		state.timer.Stop()
+		delete(t.states, key)
+		if !state.suppressed {
+			stopped = append(stopped, key)
+		}
+	}
+	t.mu.Unlock()
+
+	for _, key := range stopped {
+		t.emit(context.Background(), models.WSMessageTypeTypingStop, key)
+	}
+}
+
+func (t *typingTracker) expire(key typingKey) {
+	t.stop(key.sender, key.recipient)
+}
+
+func (t *typingTracker) emit(ctx context.Context, msgType models.WSMessageType, key typingKey) {
+	outgoing := models.WSOutgoingMessage{
+		Type:        msgType,
+		SenderID:    key.sender,
+		RecipientID: key.recipient,
+		Timestamp:   time.Now(),
+	}
+
+	data, err := json.Marshal(outgoing)
+	if err != nil {
+		slog.ErrorContext(ctx, "failed to marshal typing message", "error", err)
+		return
+	}
+
+	t.send(ctx, key.recipient, data)
+}
@@ -0,0 +1,186 @@
+package websocket
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/HaykAghajanyan/chat-backend/internal/models"
+	"github.com/HaykAghajanyan/chat-backend/internal/repository"
+	"github.com/jmoiron/sqlx"
+)
+
+type typingEvent struct {
+	to      int
+	msgType models.WSMessageType
+	sender  int
+}
+
+// newTestTypingTracker returns a tracker with short timings whose frames are
+// handed to the test
+func newTestTypingTracker(t *testing.T, ttl, throttle time.Duration) (*typingTracker, sqlmock.Sqlmock, chan typingEvent) {
+	t.Helper()
+
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	events := make(chan typingEvent, 16)
+	send := func(ctx context.Context, userID int, payload []byte) {
+		var msg models.WSOutgoingMessage
+		if err := json.Unmarshal(payload, &msg); err != nil {
+			t.Error(err)
+			return
+		}
+		events <- typingEvent{to: userID, msgType: msg.Type, sender: msg.SenderID}
+	}
+
+	tracker := newTypingTracker(send, repository.NewBlockRepository(sqlx.NewDb(db, "postgres")))
+	tracker.ttl = ttl
+	tracker.throttle = throttle
+	return tracker, mock, events
+}
+
+func expectBlocked(mock sqlmock.Sqlmock, blocker, blocked int, is bool) {
+	mock.ExpectQuery("SELECT EXISTS").
+		WithArgs(blocker, blocked).
+		WillReturnRows(sqlmock.NewRows([]string{"exists"}).AddRow(is))
+}
+
+func nextTyping(t *testing.T, events chan typingEvent, within time.Duration) typingEvent {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		return ev
+	case <-time.After(within):
+		t.Fatal("no typing frame sent")
+		return typingEvent{}
+	}
+}
+
+func noTyping(t *testing.T, events chan typingEvent, within time.Duration) {
+	t.Helper()
+
+	select {
+	case ev := <-events:
+		t.Fatalf("unexpected %s frame to %d", ev.msgType, ev.to)
+	case <-time.After(within):
+	}
+}
+
+func TestTypingThrottle(t *testing.T) {
+	tracker, mock, events := newTestTypingTracker(t, time.Second, 100*time.Millisecond)
+	ctx := context.Background()
+
+	// The block check runs once per typing state
+	expectBlocked(mock, 2, 1, false)
+
+	tracker.start(ctx, 1, 2)
+	if ev := nextTyping(t, events, time.Second); ev != (typingEvent{2, models.WSMessageTypeTypingStart, 1}) {
+		t.Fatalf("first start = %+v", ev)
+	}
+
+	// Repeats within the throttle are swallowed
+	tracker.start(ctx, 1, 2)
+	tracker.start(ctx, 1, 2)
+	noTyping(t, events, 20*time.Millisecond)
+
+	time.Sleep(100 * time.Millisecond)
+	tracker.start(ctx, 1, 2)
+	if ev := nextTyping(t, events, time.Second); ev.msgType != models.WSMessageTypeTypingStart {
+		t.Fatalf("start after the throttle = %+v, want it forwarded", ev)
+	}
+
+	tracker.stop(1, 2)
+	if ev := nextTyping(t, events, time.Second); ev.msgType != models.WSMessageTypeTypingStop {
+		t.Fatalf("stop = %+v", ev)
+	}
+	// A second stop has no state left to end
+	tracker.stop(1, 2)
+	noTyping(t, events, 20*time.Millisecond)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypingExpiry(t *testing.T) {
+	tracker, mock, events := newTestTypingTracker(t, 200*time.Millisecond, time.Second)
+	ctx := context.Background()
+
+	expectBlocked(mock, 2, 1, false)
+	tracker.start(ctx, 1, 2)
+	nextTyping(t, events, time.Second)
+
+	// Each repeated start pushes the expiry back
+	for range 3 {
+		time.Sleep(50 * time.Millisecond)
+		tracker.start(ctx, 1, 2)
+	}
+	noTyping(t, events, 20*time.Millisecond)
+
+	if ev := nextTyping(t, events, time.Second); ev != (typingEvent{2, models.WSMessageTypeTypingStop, 1}) {
+		t.Fatalf("frame after the sender went quiet = %+v, want typing_stop", ev)
+	}
+
+	tracker.mu.Lock()
+	left := len(tracker.states)
+	tracker.mu.Unlock()
+	if left != 0 {
+		t.Fatalf("%d typing states left after expiry", left)
+	}
+}
+
+func TestTypingBlocked(t *testing.T) {
+	tracker, mock, events := newTestTypingTracker(t, time.Second, 10*time.Millisecond)
+	ctx := context.Background()
+
+	expectBlocked(mock, 2, 1, true)
+
+	// Neither the start, its repeats nor the stop reach a recipient that blocked
+	// the sender, and the block is only looked up once
+	tracker.start(ctx, 1, 2)
+	time.Sleep(20 * time.Millisecond)
+	tracker.start(ctx, 1, 2)
+	tracker.stop(1, 2)
+	noTyping(t, events, 50*time.Millisecond)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTypingStopAll(t *testing.T) {
+	tracker, mock, events := newTestTypingTracker(t, time.Second, time.Second)
+	ctx := context.Background()
+
+	expectBlocked(mock, 2, 1, false)
+	expectBlocked(mock, 3, 1, false)
+	expectBlocked(mock, 1, 4, false)
+	tracker.start(ctx, 1, 2)
+	tracker.start(ctx, 1, 3)
+	tracker.start(ctx, 4, 1)
+	for range 3 {
+		nextTyping(t, events, time.Second)
+	}
+
+	// Only the disconnected sender's indicators end
+	tracker.stopAll(1)
+	stopped := map[int]bool{}
+	for range 2 {
+		ev := nextTyping(t, events, time.Second)
+		if ev.msgType != models.WSMessageTypeTypingStop || ev.sender != 1 {
+			t.Fatalf("frame = %+v, want typing_stop from user 1", ev)
+		}
+		stopped[ev.to] = true
+	}
+	if !stopped[2] || !stopped[3] {
+		t.Fatalf("typing_stop sent to %v, want users 2 and 3", stopped)
+	}
+	noTyping(t, events, 20*time.Millisecond)
+}
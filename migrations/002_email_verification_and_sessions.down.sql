@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS sessions;
+ALTER TABLE users DROP COLUMN IF EXISTS email_verified_at;
@@ -0,0 +1,15 @@
+-- Audit log for security relevant events and administrative actions
+CREATE TABLE IF NOT EXISTS audit_log
+(
+    id          SERIAL PRIMARY KEY,
+    actor_id    INTEGER     REFERENCES users (id) ON DELETE SET NULL,
+    action      VARCHAR(50) NOT NULL,
+    target_type VARCHAR(20),
+    target_id   INTEGER,
+    ip_address  VARCHAR(64),
+    metadata    JSONB       NOT NULL DEFAULT '{}',
+    created_at  TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_audit_log_action ON audit_log(action);
+CREATE INDEX IF NOT EXISTS idx_audit_log_created_at ON audit_log(created_at);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS status_text;
+ALTER TABLE users DROP COLUMN IF EXISTS bio;
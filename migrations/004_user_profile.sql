@@ -0,0 +1,3 @@
+-- Free-form profile fields editable by the user
+ALTER TABLE users ADD COLUMN IF NOT EXISTS bio TEXT;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS status_text VARCHAR(140);
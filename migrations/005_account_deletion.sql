@@ -0,0 +1,2 @@
+-- Deleted accounts are anonymised in place; deleted_at marks them
+ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP;
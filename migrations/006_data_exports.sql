@@ -0,0 +1,15 @@
+-- Data-subject export jobs
+CREATE TABLE IF NOT EXISTS data_exports
+(
+    id           SERIAL PRIMARY KEY,
+    user_id      INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    status       VARCHAR(20) NOT NULL DEFAULT 'pending',
+    storage_key  VARCHAR(255),
+    error        TEXT,
+    created_at   TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP,
+    expires_at   TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id);
+CREATE INDEX IF NOT EXISTS idx_data_exports_status ON data_exports(status);
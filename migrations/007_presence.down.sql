@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS last_seen_visibility;
+ALTER TABLE users DROP COLUMN IF EXISTS last_seen_at;
@@ -0,0 +1,3 @@
+-- Last time the user was connected, and who may see it
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS last_seen_visibility VARCHAR(20) NOT NULL DEFAULT 'everyone';
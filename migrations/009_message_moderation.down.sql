@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_messages_held;
+ALTER TABLE messages DROP COLUMN IF EXISTS moderation_reason;
+ALTER TABLE messages DROP COLUMN IF EXISTS moderation_status;
@@ -0,0 +1,6 @@
+-- Moderation outcome of each message. Held messages wait for review and are
+-- not shown to the recipient.
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS moderation_status VARCHAR(16) NOT NULL DEFAULT 'approved';
+ALTER TABLE messages ADD COLUMN IF NOT EXISTS moderation_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_messages_held ON messages(created_at) WHERE moderation_status = 'held';
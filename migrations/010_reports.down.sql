@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN IF EXISTS suspended_until;
+DROP TABLE IF EXISTS reports;
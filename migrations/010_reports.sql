@@ -0,0 +1,31 @@
+-- Abuse reports filed by users against messages or other users
+CREATE TABLE IF NOT EXISTS reports
+(
+    id               SERIAL PRIMARY KEY,
+    reporter_id      INTEGER     REFERENCES users (id) ON DELETE SET NULL,
+    target_type      VARCHAR(20) NOT NULL,
+    reported_user_id INTEGER     NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    -- The message is kept as a snapshot so it survives content deletion
+    message_id       INTEGER,
+    message_content  TEXT,
+    reason           VARCHAR(20) NOT NULL,
+    details          TEXT,
+    status           VARCHAR(20) NOT NULL DEFAULT 'open',
+    assignee_id      INTEGER     REFERENCES users (id) ON DELETE SET NULL,
+    action           VARCHAR(20),
+    resolution_note  TEXT,
+    created_at       TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    claimed_at       TIMESTAMP,
+    resolved_at      TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status, created_at);
+CREATE INDEX IF NOT EXISTS idx_reports_reported_user_id ON reports(reported_user_id);
+
+-- A reporter can only have one unresolved report per target
+CREATE UNIQUE INDEX IF NOT EXISTS idx_reports_open_per_reporter
+    ON reports(reporter_id, target_type, reported_user_id, COALESCE(message_id, 0))
+    WHERE status <> 'resolved';
+
+-- Suspended users cannot log in until this time
+ALTER TABLE users ADD COLUMN IF NOT EXISTS suspended_until TIMESTAMP;
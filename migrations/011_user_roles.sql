@@ -0,0 +1,6 @@
+-- Roles and account restrictions managed through the admin API
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role VARCHAR(20) NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN IF NOT EXISTS banned_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS restriction_reason TEXT;
+
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role) WHERE role <> 'user';
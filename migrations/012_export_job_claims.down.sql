@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_data_exports_one_active;
+ALTER TABLE data_exports DROP COLUMN IF EXISTS attempts;
+ALTER TABLE data_exports DROP COLUMN IF EXISTS started_at;
@@ -0,0 +1,21 @@
+-- Export jobs record when a worker claimed them so jobs left in processing by
+-- a crashed node can be reclaimed, and how often they were claimed
+ALTER TABLE data_exports ADD COLUMN IF NOT EXISTS started_at TIMESTAMP;
+ALTER TABLE data_exports ADD COLUMN IF NOT EXISTS attempts INTEGER NOT NULL DEFAULT 0;
+
+UPDATE data_exports SET started_at = created_at WHERE status = 'processing' AND started_at IS NULL;
+
+-- At most one active export per user. Older duplicates created before the
+-- index existed are failed so it can be built.
+UPDATE data_exports d
+SET status = 'failed', error = 'superseded by a newer export', completed_at = CURRENT_TIMESTAMP
+WHERE d.status IN ('pending', 'processing')
+  AND EXISTS (
+    SELECT 1 FROM data_exports newer
+    WHERE newer.user_id = d.user_id
+      AND newer.status IN ('pending', 'processing')
+      AND newer.id > d.id
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_data_exports_one_active
+    ON data_exports(user_id) WHERE status IN ('pending', 'processing');
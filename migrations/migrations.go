@@ -0,0 +1,9 @@
+// Package migrations embeds the SQL migrations so the binaries can apply them.
+// NNN_name.sql upgrades the schema to version NNN and NNN_name.down.sql, if
+// present, reverts it.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS